@@ -0,0 +1,67 @@
+// Package archive records gobsp messages in a file that may be read from any
+// message onward, without scanning the messages before it.
+//
+// An archive begins with a gobsp Preamble, followed by the recorded messages as
+// an ordinary gobsp stream of frames. After the last frame comes an index
+// holding one fixed size entry per message: the Uint64 offset of its frame from
+// the start of the archive, its Uint32 message type, and the Int64 time it was
+// recorded, in nanoseconds since the Unix epoch. The archive ends with a footer
+// holding the Uint64 offset of the index, the Uint64 number of entries, and
+// the magic bytes "GBSPINDX". Because entries have a fixed size, a Reader finds
+// the N-th message with a single read of the index.
+package archive
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/karrick/gobsp"
+)
+
+// footerMagic are the bytes that end every archive.
+var footerMagic = [8]byte{'G', 'B', 'S', 'P', 'I', 'N', 'D', 'X'}
+
+const (
+	entrySize  = 8 + 4 + 8                // offset, message type, timestamp
+	footerSize = 8 + 8 + len(footerMagic) // index offset, entry count, magic
+)
+
+// Entry describes a single message recorded in an archive.
+type Entry struct {
+	Offset int64             // offset of the message's frame from the start of the archive
+	Type   gobsp.MessageType // message type
+	Time   time.Time         // time the message was recorded
+}
+
+// ErrNotArchive is an error that is returned when a file does not end with an
+// archive footer, or its footer does not describe an index within the file.
+type ErrNotArchive struct{}
+
+func (e ErrNotArchive) Error() string {
+	return "not an archive"
+}
+
+// ErrIndexOutOfRange is an error that is returned when a message number is
+// not within the archive.
+type ErrIndexOutOfRange int
+
+func (e ErrIndexOutOfRange) Error() string {
+	return "message index out of range: " + strconv.Itoa(int(e))
+}
+
+// ErrTypeNotFound is an error that is returned when no message of the required
+// type is found.
+type ErrTypeNotFound gobsp.MessageType
+
+func (e ErrTypeNotFound) Error() string {
+	return "message type not found: " + gobsp.UVWI(e).String()
+}
+
+// ErrTypeTooLarge is an error that is returned when a message type is too
+// large for an index entry. Only the message types gobsp.Scanner dispatches to
+// handlers, which fit in a uint32, may be archived.
+type ErrTypeTooLarge gobsp.MessageType
+
+func (e ErrTypeTooLarge) Error() string {
+	return "message type too large to archive: " + gobsp.UVWI(e).String()
+}
@@ -0,0 +1,164 @@
+package archive
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/karrick/gobsp"
+)
+
+func ensure(t *testing.T, actual, expected interface{}) {
+	t.Helper()
+	if actual != expected {
+		t.Errorf("Actual: %#v; Expected: %#v", actual, expected)
+	}
+}
+
+var epoch = time.Unix(1000, 0)
+
+// testArchive returns an archive holding ten messages, whose types cycle
+// through 1, 2, and 3, whose bodies are their numbers, and which were
+// recorded one second apart.
+func testArchive(t *testing.T) *Reader {
+	t.Helper()
+	bb := new(bytes.Buffer)
+	w, err := NewWriter(bb, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		body := []byte{byte('0' + i)}
+		if err := w.AppendAt(epoch.Add(time.Duration(i)*time.Second), gobsp.MessageType(1+i%3), body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ensure(t, w.Len(), 10)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReader(bytes.NewReader(bb.Bytes()), int64(bb.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// testScan returns each remaining message in the archive as "type:body".
+func testScan(t *testing.T, r *Reader) []string {
+	t.Helper()
+	var received []string
+	handlers := make(map[uint32]gobsp.MessageHandler)
+	for mt := uint32(1); mt <= 3; mt++ {
+		prefix := string(rune('0'+mt)) + ":"
+		handlers[mt] = func(ior io.Reader) error {
+			buf, err := ioutil.ReadAll(ior)
+			received = append(received, prefix+string(buf))
+			return err
+		}
+	}
+	scanner, err := r.Scanner(gobsp.Handlers(handlers))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for scanner.Scan() {
+		if err := scanner.Handle(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ensure(t, scanner.Err(), nil)
+	return received
+}
+
+func TestReaderFromStart(t *testing.T) {
+	r := testArchive(t)
+	ensure(t, r.Len(), 10)
+	ensure(t, r.Preamble().Application, gobsp.String("test"))
+
+	received := testScan(t, r)
+	ensure(t, len(received), 10)
+	ensure(t, received[0], "1:0")
+	ensure(t, received[9], "1:9")
+}
+
+func TestReaderSeek(t *testing.T) {
+	r := testArchive(t)
+	ensure(t, r.Seek(7), nil)
+	received := testScan(t, r)
+	ensure(t, len(received), 3)
+	ensure(t, received[0], "2:7")
+
+	ensure(t, r.Seek(10), nil)
+	ensure(t, len(testScan(t, r)), 0)
+
+	ensure(t, r.Seek(11), error(ErrIndexOutOfRange(11)))
+	ensure(t, r.Seek(-1), error(ErrIndexOutOfRange(-1)))
+}
+
+func TestReaderSeekType(t *testing.T) {
+	r := testArchive(t)
+	n, err := r.SeekType(3)
+	ensure(t, err, nil)
+	ensure(t, n, 2)
+	received := testScan(t, r)
+	ensure(t, len(received), 8)
+	ensure(t, received[0], "3:2")
+
+	_, err = r.SeekType(4)
+	ensure(t, err, error(ErrTypeNotFound(4)))
+}
+
+func TestReaderSeekTime(t *testing.T) {
+	r := testArchive(t)
+	n, err := r.SeekTime(epoch.Add(4500 * time.Millisecond))
+	ensure(t, err, nil)
+	ensure(t, n, 5)
+	received := testScan(t, r)
+	ensure(t, len(received), 5)
+	ensure(t, received[0], "3:5")
+
+	n, err = r.SeekTime(epoch.Add(time.Minute))
+	ensure(t, err, nil)
+	ensure(t, n, 10)
+}
+
+func TestReaderEntry(t *testing.T) {
+	r := testArchive(t)
+	e, err := r.Entry(4)
+	ensure(t, err, nil)
+	ensure(t, e.Type, gobsp.MessageType(2))
+	ensure(t, e.Time.Equal(epoch.Add(4*time.Second)), true)
+	_, err = r.Entry(10)
+	ensure(t, err, error(ErrIndexOutOfRange(10)))
+}
+
+func TestReaderEmptyArchive(t *testing.T) {
+	bb := new(bytes.Buffer)
+	w, err := NewWriter(bb, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ensure(t, w.Close(), nil)
+	r, err := NewReader(bytes.NewReader(bb.Bytes()), int64(bb.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ensure(t, r.Len(), 0)
+	ensure(t, len(testScan(t, r)), 0)
+}
+
+func TestReaderNotArchive(t *testing.T) {
+	buf := []byte("this is not an archive at all, it is just some bytes")
+	_, err := NewReader(bytes.NewReader(buf), int64(len(buf)))
+	ensure(t, err, error(ErrNotArchive{}))
+}
+
+func TestWriterTypeTooLarge(t *testing.T) {
+	w, err := NewWriter(new(bytes.Buffer), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ensure(t, w.Append(1<<32, nil), error(ErrTypeTooLarge(1<<32)))
+}
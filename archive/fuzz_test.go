@@ -0,0 +1,59 @@
+package archive
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/karrick/gobsp"
+)
+
+// FuzzReader checks that a Reader opened on arbitrary bytes neither panics nor
+// reports entries outside the archive.
+func FuzzReader(f *testing.F) {
+	bb := new(bytes.Buffer)
+	w, err := NewWriter(bb, "test")
+	if err != nil {
+		f.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err = w.AppendAt(epoch.Add(time.Duration(i)*time.Second), gobsp.MessageType(i+1), []byte{byte(i)}); err != nil {
+			f.Fatal(err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		f.Fatal(err)
+	}
+	f.Add(bb.Bytes())
+	f.Add(bb.Bytes()[:bb.Len()-1])
+	f.Add(append([]byte("GBSP"), bb.Bytes()[bb.Len()-footerSize:]...))
+
+	f.Fuzz(func(t *testing.T, buf []byte) {
+		r, err := NewReader(bytes.NewReader(buf), int64(len(buf)))
+		if err != nil {
+			return
+		}
+		if r.Len() < 0 || int64(r.Len())*entrySize > int64(len(buf)) {
+			t.Fatalf("%d entries in %d bytes", r.Len(), len(buf))
+		}
+		for n := 0; n < r.Len(); n++ {
+			if _, err = r.Entry(n); err != nil {
+				t.Fatal(err)
+			}
+		}
+		_, _ = r.SeekType(3)
+		_, _ = r.SeekTime(epoch.Add(time.Second))
+		if err = r.Seek(0); err != nil {
+			t.Fatal(err)
+		}
+		s, err := r.Scanner(gobsp.Raw(), gobsp.DefaultHandler(gobsp.DiscardAll))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for s.Scan() {
+			if err = s.Handle(); err != nil {
+				break
+			}
+		}
+	})
+}
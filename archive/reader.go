@@ -0,0 +1,178 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/karrick/gobsp"
+)
+
+// Reader reads an archive through an io.ReaderAt. It is an io.Reader that
+// returns the archive's frames as an ordinary gobsp stream, without a
+// preamble, starting from the message chosen by Seek, SeekType, or SeekTime,
+// and ending after the last message. It is not safe for concurrent use.
+type Reader struct {
+	ra          io.ReaderAt
+	preamble    gobsp.Preamble
+	indexOffset int64
+	count       int
+	pos         int64 // offset of the next byte Read returns
+}
+
+// NewReader reads the footer and preamble of the archive of the specified size
+// read from the specified io.ReaderAt, and returns a Reader positioned at its
+// first message.
+func NewReader(ra io.ReaderAt, size int64) (*Reader, error) {
+	if size < int64(footerSize) {
+		return nil, ErrNotArchive{}
+	}
+	footer := io.NewSectionReader(ra, size-int64(footerSize), int64(footerSize))
+	var indexOffset, count gobsp.Uint64
+	var magic [len(footerMagic)]byte
+	if err := indexOffset.UnmarshalBinaryFrom(footer); err != nil {
+		return nil, err
+	}
+	if err := count.UnmarshalBinaryFrom(footer); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(footer, magic[:]); err != nil {
+		return nil, err
+	}
+	// The index must lie within the archive, and the count is checked before
+	// multiplying, so a corrupt count cannot overflow to match the index size.
+	indexSize := size - int64(footerSize) - int64(indexOffset)
+	if magic != footerMagic || uint64(indexOffset) > uint64(size) || indexSize < 0 ||
+		uint64(count) > uint64(indexSize)/entrySize || uint64(indexSize) != uint64(count)*entrySize {
+		return nil, ErrNotArchive{}
+	}
+
+	r := &Reader{ra: ra, indexOffset: int64(indexOffset), count: int(count)}
+	preamble := bufio.NewReader(io.NewSectionReader(ra, 0, r.indexOffset))
+	if err := r.preamble.UnmarshalBinaryFrom(preamble); err != nil {
+		return nil, err
+	}
+	if r.count > 0 {
+		e, err := r.Entry(0)
+		if err != nil {
+			return nil, err
+		}
+		r.pos = e.Offset
+	} else {
+		r.pos = r.indexOffset
+	}
+	return r, nil
+}
+
+// Preamble returns the preamble read from the archive.
+func (r *Reader) Preamble() gobsp.Preamble {
+	return r.preamble
+}
+
+// Len returns the number of messages in the archive.
+func (r *Reader) Len() int {
+	return r.count
+}
+
+// Entry returns the index entry of the n-th message, counting from zero.
+func (r *Reader) Entry(n int) (Entry, error) {
+	if n < 0 || n >= r.count {
+		return Entry{}, ErrIndexOutOfRange(n)
+	}
+	var buf [entrySize]byte
+	if m, err := r.ra.ReadAt(buf[:], r.indexOffset+int64(n)*entrySize); m < len(buf) {
+		return Entry{}, err
+	}
+	return decodeEntry(buf[:]), nil
+}
+
+// decodeEntry decodes an index entry.
+func decodeEntry(buf []byte) Entry {
+	var offset gobsp.Uint64
+	var messageType gobsp.Uint32
+	var nanos gobsp.Int64
+	br := bytes.NewReader(buf)
+	_ = offset.UnmarshalBinaryFrom(br)
+	_ = messageType.UnmarshalBinaryFrom(br)
+	_ = nanos.UnmarshalBinaryFrom(br)
+	return Entry{Offset: int64(offset), Type: gobsp.MessageType(messageType), Time: time.Unix(0, int64(nanos))}
+}
+
+// Seek positions the Reader at the n-th message, counting from zero. Seeking to
+// Len() positions the Reader after the last message.
+func (r *Reader) Seek(n int) error {
+	if n == r.count {
+		r.pos = r.indexOffset
+		return nil
+	}
+	e, err := r.Entry(n)
+	if err != nil {
+		return err
+	}
+	r.pos = e.Offset
+	return nil
+}
+
+// SeekType positions the Reader at the first message of the specified type,
+// and returns its number.
+func (r *Reader) SeekType(messageType gobsp.MessageType) (int, error) {
+	br := bufio.NewReader(io.NewSectionReader(r.ra, r.indexOffset, int64(r.count)*entrySize))
+	var buf [entrySize]byte
+	for n := 0; n < r.count; n++ {
+		if _, err := io.ReadFull(br, buf[:]); err != nil {
+			return 0, err
+		}
+		if e := decodeEntry(buf[:]); e.Type == messageType {
+			r.pos = e.Offset
+			return n, nil
+		}
+	}
+	return 0, ErrTypeNotFound(messageType)
+}
+
+// SeekTime positions the Reader at the first message recorded at or after the
+// specified time, and returns its number. It assumes messages were recorded in
+// time order. When every message was recorded before the specified time, the
+// Reader is positioned after the last message.
+func (r *Reader) SeekTime(when time.Time) (int, error) {
+	var err error
+	n := sort.Search(r.count, func(n int) bool {
+		e, eerr := r.Entry(n)
+		if eerr != nil {
+			err = eerr
+			return true
+		}
+		return !e.Time.Before(when)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, r.Seek(n)
+}
+
+// Read reads the frames of the archive from the current position.
+func (r *Reader) Read(buf []byte) (int, error) {
+	remaining := r.indexOffset - r.pos
+	if remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(buf)) > remaining {
+		buf = buf[:remaining]
+	}
+	n, err := r.ra.ReadAt(buf, r.pos)
+	r.pos += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Scanner returns a new gobsp.Scanner that scans the archive's messages from
+// the current position. Because the Scanner buffers what it reads, the Reader
+// must not be used while the Scanner is in use. To scan from another position,
+// seek, then create a new Scanner.
+func (r *Reader) Scanner(configurators ...gobsp.ScannerConfig) (*gobsp.Scanner, error) {
+	return gobsp.NewScanner(r, configurators...)
+}
@@ -0,0 +1,111 @@
+package archive
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"time"
+
+	"github.com/karrick/gobsp"
+)
+
+// Writer records messages to an archive. It is not safe for concurrent use.
+type Writer struct {
+	bw     *bufio.Writer
+	cw     *countingWriter
+	index  []Entry
+	closed bool
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	iow io.Writer
+	n   int64
+}
+
+func (cw *countingWriter) Write(buf []byte) (int, error) {
+	n, err := cw.iow.Write(buf)
+	cw.n += int64(n)
+	return n, err
+}
+
+// NewWriter writes the preamble of a new archive, naming the specified
+// application, to the specified io.Writer, and returns a Writer to record
+// messages to it.
+func NewWriter(iow io.Writer, application string) (*Writer, error) {
+	cw := &countingWriter{iow: iow}
+	w := &Writer{bw: bufio.NewWriter(cw), cw: cw}
+	p := gobsp.Preamble{Version: gobsp.FramingVersion, Application: gobsp.String(application)}
+	if err := p.MarshalBinaryTo(w.bw); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// offset returns the offset from the start of the archive of the next byte
+// written.
+func (w *Writer) offset() int64 {
+	return w.cw.n + int64(w.bw.Buffered())
+}
+
+// Append records a message having the specified type and body, stamped with
+// the current time.
+func (w *Writer) Append(messageType gobsp.MessageType, messageBody []byte) error {
+	return w.AppendAt(time.Now(), messageType, messageBody)
+}
+
+// AppendAt records a message having the specified type and body, stamped with
+// the specified time.
+func (w *Writer) AppendAt(when time.Time, messageType gobsp.MessageType, messageBody []byte) error {
+	if messageType > math.MaxUint32 {
+		return ErrTypeTooLarge(messageType)
+	}
+	e := Entry{Offset: w.offset(), Type: messageType, Time: when}
+	if err := gobsp.UVWI(messageType).MarshalBinaryTo(w.bw); err != nil {
+		return err
+	}
+	if err := gobsp.UVWI(len(messageBody)).MarshalBinaryTo(w.bw); err != nil {
+		return err
+	}
+	if _, err := w.bw.Write(messageBody); err != nil {
+		return err
+	}
+	w.index = append(w.index, e)
+	return nil
+}
+
+// Len returns the number of messages recorded.
+func (w *Writer) Len() int {
+	return len(w.index)
+}
+
+// Close writes the index and footer that complete the archive, and flushes
+// it. It does not close the underlying io.Writer.
+func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	indexOffset := w.offset()
+	for _, e := range w.index {
+		if err := gobsp.Uint64(e.Offset).MarshalBinaryTo(w.bw); err != nil {
+			return err
+		}
+		if err := gobsp.Uint32(e.Type).MarshalBinaryTo(w.bw); err != nil {
+			return err
+		}
+		if err := gobsp.Int64(e.Time.UnixNano()).MarshalBinaryTo(w.bw); err != nil {
+			return err
+		}
+	}
+	if err := gobsp.Uint64(indexOffset).MarshalBinaryTo(w.bw); err != nil {
+		return err
+	}
+	if err := gobsp.Uint64(len(w.index)).MarshalBinaryTo(w.bw); err != nil {
+		return err
+	}
+	if _, err := w.bw.Write(footerMagic[:]); err != nil {
+		return err
+	}
+	return w.bw.Flush()
+}
@@ -0,0 +1,119 @@
+package gobsp
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"io"
+)
+
+// Batch accumulates messages to be sent together as a single batch message,
+// saving the per-message framing overhead on the wire, and optionally
+// compressing the messages as a unit. The receiving Scanner unpacks the batch
+// and returns each message in turn, so handlers are unaware of batching.
+type Batch struct {
+	w          *Composer
+	compressed bool
+	bb         bytes.Buffer
+	count      int
+}
+
+// Batch returns a new, empty Batch that is sent using the Composer.
+func (w *Composer) Batch() *Batch {
+	return &Batch{w: w}
+}
+
+// CompressedBatch returns a new, empty Batch that is compressed when sent
+// using the Composer. Peers ought to agree on FeatureCompression before
+// sending compressed batches.
+func (w *Composer) CompressedBatch() *Batch {
+	return &Batch{w: w, compressed: true}
+}
+
+// Add appends a message having the specified type and body to the batch.
+func (b *Batch) Add(messageType MessageType, messageBody []byte) error {
+	if err := b.w.permitted(messageType); err != nil {
+		return err
+	}
+	_ = UVWI(messageType).MarshalBinaryTo(&b.bb)
+	_ = UVWI(len(messageBody)).MarshalBinaryTo(&b.bb)
+	b.bb.Write(messageBody)
+	b.count++
+	return nil
+}
+
+// Len returns the number of messages in the batch.
+func (b *Batch) Len() int {
+	return b.count
+}
+
+// Send composes the batch as a single message, then empties the batch so it
+// may be reused. Sending an empty batch does nothing.
+func (b *Batch) Send() error {
+	if b.count == 0 {
+		return nil
+	}
+	messageType, body := MTBatch, b.bb.Bytes()
+	if b.compressed {
+		cb := new(bytes.Buffer)
+		fw, err := flate.NewWriter(cb, flate.DefaultCompression)
+		if err != nil {
+			return err
+		}
+		if _, err = fw.Write(body); err != nil {
+			return err
+		}
+		if err = fw.Close(); err != nil {
+			return err
+		}
+		messageType, body = MTCompressedBatch, cb.Bytes()
+	}
+	if err := b.w.write(messageType, body, false); err != nil {
+		return err
+	}
+	b.bb.Reset()
+	b.count = 0
+	return nil
+}
+
+// startBatch reads a batch message having the specified type and size from the
+// stream and prepares to unpack the messages it holds.
+func (s *Scanner) startBatch(messageType, messageSize UVWI) error {
+	body, err := io.ReadAll(io.LimitReader(s.bufferedReader, int64(messageSize)))
+	if err != nil {
+		return err
+	}
+	if uint64(len(body)) != uint64(messageSize) {
+		return io.ErrUnexpectedEOF
+	}
+	if MessageType(messageType) == MTCompressedBatch {
+		// The decompressor and its buffer are reused for later batches, until
+		// scanning ends.
+		if r, ok := s.inflater.(flate.Resetter); ok {
+			if err := r.Reset(bytes.NewReader(body), nil); err != nil {
+				return err
+			}
+			s.inflated.Reset(s.inflater)
+		} else {
+			s.inflater = flate.NewReader(bytes.NewReader(body))
+			s.inflated = bufio.NewReader(s.inflater)
+		}
+		s.batch = s.inflated
+	} else {
+		s.batch = bytes.NewReader(body)
+	}
+	return nil
+}
+
+// releaseInflater closes and drops the decompressor used for compressed
+// batches, if any. A later compressed batch makes a new one.
+func (s *Scanner) releaseInflater() {
+	if s.inflater == nil {
+		return
+	}
+	if s.batch == io.Reader(s.inflated) {
+		s.batch = nil
+	}
+	_ = s.inflater.Close() // closing a flate reader cannot fail
+	s.inflater, s.inflated = nil, nil
+}
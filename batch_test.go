@@ -0,0 +1,136 @@
+package gobsp
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"testing"
+)
+
+// testScanAll scans every message from the specified stream, returning the
+// type and body of each, joined as "type:body".
+func testScanAll(t *testing.T, ior io.Reader) []string {
+	var received []string
+	var scanner *Scanner
+	scanner, err := NewScanner(ior, DefaultHandler(func(ior io.Reader) error {
+		buf, err := io.ReadAll(ior)
+		received = append(received, UVWI(scanner.messageType).String()+":"+string(buf))
+		return err
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for scanner.Scan() {
+		if err := scanner.Handle(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ensure(t, scanner.Err(), nil)
+	return received
+}
+
+func testBatch(t *testing.T, compressed bool) {
+	bb := new(bytes.Buffer)
+	w := NewComposer(bb)
+
+	ensure(t, w.Compose(1, []byte("before")), nil)
+	b := w.Batch()
+	if compressed {
+		b = w.CompressedBatch()
+	}
+	ensure(t, b.Add(2, []byte("one")), nil)
+	ensure(t, b.Add(3, nil), nil)
+	ensure(t, b.Add(4, bytes.Repeat([]byte("x"), 1000)), nil)
+	ensure(t, b.Len(), 3)
+	ensure(t, b.Send(), nil)
+	ensure(t, b.Len(), 0)
+	ensure(t, w.Compose(5, []byte("after")), nil)
+	ensure(t, w.Close(), nil)
+
+	received := testScanAll(t, bb)
+	ensure(t, len(received), 5)
+	ensure(t, received[0], "1:before")
+	ensure(t, received[1], "2:one")
+	ensure(t, received[2], "3:")
+	ensure(t, received[3], "4:"+string(bytes.Repeat([]byte("x"), 1000)))
+	ensure(t, received[4], "5:after")
+}
+
+func TestBatch(t *testing.T) {
+	testBatch(t, false)
+}
+
+func TestCompressedBatch(t *testing.T) {
+	testBatch(t, true)
+}
+
+func TestCompressedBatchReusesInflater(t *testing.T) {
+	bb := new(bytes.Buffer)
+	w := NewComposer(bb)
+	for i := 1; i <= 2; i++ {
+		b := w.CompressedBatch()
+		ensure(t, b.Add(MessageType(i), []byte("x")), nil)
+		ensure(t, b.Send(), nil)
+	}
+	ensure(t, w.Close(), nil)
+
+	scanner, err := NewScanner(bb, DefaultHandler(DiscardAll))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ensure(t, scanner.Scan(), true)
+	ensure(t, scanner.Handle(), nil)
+	inflated := scanner.inflated
+	if inflated == nil {
+		t.Fatal("Actual: nil; Expected: decompressor")
+	}
+	ensure(t, scanner.Scan(), true)
+	ensure(t, scanner.Handle(), nil)
+	ensure(t, scanner.inflated, inflated)
+
+	// the decompressor is released once scanning ends
+	ensure(t, scanner.Scan(), false)
+	ensure(t, scanner.Err(), nil)
+	ensure(t, scanner.inflater, nil)
+	ensure(t, scanner.inflated, (*bufio.Reader)(nil))
+}
+
+func TestBatchEncoding(t *testing.T) {
+	bb := new(bytes.Buffer)
+	w := NewComposer(bb)
+	b := w.Batch()
+	ensure(t, b.Add(1, []byte("a")), nil)
+	ensure(t, b.Add(2, []byte("bc")), nil)
+	ensure(t, b.Send(), nil)
+	ensure(t, w.Close(), nil)
+
+	expected := []byte{
+		0x87, 0xFE, 0xFF, 0xFF, 0x0F, 0x07, // batch type and size
+		0x01, 0x01, 'a',
+		0x02, 0x02, 'b', 'c',
+	}
+	if actual := bb.Bytes(); !bytes.Equal(actual, expected) {
+		t.Errorf("Actual: %#v; Expected: %#v", actual, expected)
+	}
+}
+
+func TestBatchTruncated(t *testing.T) {
+	bb := bytes.NewBuffer([]byte{
+		0x87, 0xFE, 0xFF, 0xFF, 0x0F, 0x04, // batch type and size
+		0x01, 0x05, 'a', 'b', // record claims more bytes than the batch holds
+	})
+	scanner, err := NewScanner(bb, DefaultHandler(DiscardAll))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ensure(t, scanner.Scan(), false)
+	ensure(t, scanner.Err(), io.ErrUnexpectedEOF)
+}
+
+func TestEmptyBatchSendsNothing(t *testing.T) {
+	bb := new(bytes.Buffer)
+	w := NewComposer(bb)
+	ensure(t, w.Batch().Send(), nil)
+	ensure(t, w.Close(), nil)
+	ensure(t, bb.Len(), 0)
+}
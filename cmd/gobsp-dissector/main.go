@@ -0,0 +1,79 @@
+// gobsp-dissector writes a Wireshark dissector, in Lua, that decodes gobsp
+// streams carried over TCP: their preambles, frame headers, batches, and
+// fragments, and, given a schema (see the schema package), the named fields of
+// each message body.
+//
+// Copy the dissector to Wireshark's personal Lua plugins directory, shown under
+// Help, About Wireshark, Folders. Traffic to or from the ports given with -port
+// is decoded automatically; other traffic may be decoded with Decode As.
+//
+// Usage:
+//
+//	gobsp-dissector [-schema schema.json] [-name gobsp] [-port 7000,7001] [-o gobsp.lua]
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/karrick/gobsp/cmd/internal/cmdflag"
+	"github.com/karrick/gobsp/dissector"
+	"github.com/karrick/gobsp/schema"
+)
+
+func main() {
+	schemaName := flag.String("schema", "", "schema `file` describing the message types")
+	name := flag.String("name", dissector.DefaultName, "`name` of the protocol, which prefixes its display filter fields")
+	portList := flag.String("port", "", "comma separated list of TCP `ports` to decode")
+	output := flag.String("o", "-", "write the dissector to this `file`, or standard output when \"-\"")
+	flag.Parse()
+
+	var s *schema.Schema
+	if *schemaName != "" {
+		var err error
+		if s, err = cmdflag.Schema(*schemaName); err != nil {
+			fmt.Fprintf(os.Stderr, "gobsp-dissector: %s\n", err)
+			os.Exit(2)
+		}
+	}
+	ports, err := parsePorts(*portList)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gobsp-dissector: %s\n", err)
+		os.Exit(2)
+	}
+
+	out := os.Stdout
+	if *output != "-" {
+		if out, err = os.Create(*output); err != nil {
+			fmt.Fprintf(os.Stderr, "gobsp-dissector: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	err = dissector.Generate(out, s, dissector.Name(*name), dissector.Ports(ports...))
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gobsp-dissector: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// parsePorts parses a comma separated list of TCP ports.
+func parsePorts(list string) ([]int, error) {
+	if list == "" {
+		return nil, nil
+	}
+	var ports []int
+	for _, field := range strings.Split(list, ",") {
+		port, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return nil, fmt.Errorf("invalid port: %q", field)
+		}
+		ports = append(ports, port)
+	}
+	return ports, nil
+}
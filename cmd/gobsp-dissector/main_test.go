@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+)
+
+func ensure(t *testing.T, actual, expected interface{}) {
+	t.Helper()
+	if actual != expected {
+		t.Errorf("Actual: %#v; Expected: %#v", actual, expected)
+	}
+}
+
+func TestParsePorts(t *testing.T) {
+	ports, err := parsePorts("7000, 7001")
+	ensure(t, err, nil)
+	ensure(t, len(ports), 2)
+	ensure(t, ports[0], 7000)
+	ensure(t, ports[1], 7001)
+
+	ports, err = parsePorts("")
+	ensure(t, err, nil)
+	ensure(t, len(ports), 0)
+
+	_, err = parsePorts("7000,http")
+	ensure(t, err.Error(), `invalid port: "http"`)
+}
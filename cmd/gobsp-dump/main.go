@@ -0,0 +1,178 @@
+// gobsp-dump prints the frames of a gobsp stream read from files or standard
+// input, one frame at a time: its offset in the stream, its message type, its
+// size, and a hex and ASCII view of its body. Frames are printed exactly as
+// they appear in the stream, so batches and fragments are not unpacked.
+//
+// A framing error, such as a stream ending part way through a frame, is
+// reported with the offset of the frame, and gobsp-dump exits with a non-zero
+// status.
+//
+// Usage:
+//
+//	gobsp-dump [-types 1,2,0xFFFFFF02] [-from offset] [-to offset] [-max bytes] [-headers] [file ...]
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/karrick/gobsp"
+	"github.com/karrick/gobsp/cmd/internal/cmdflag"
+)
+
+// options controls which frames are printed, and how.
+type options struct {
+	types    map[gobsp.MessageType]bool // nil to print every type
+	from, to int64                      // offset range; to is zero for no limit
+	max      int                        // body bytes printed per frame; zero for all
+	headers  bool                       // print headers without bodies
+}
+
+func main() {
+	var o options
+	types := flag.String("types", "", "comma separated message `types` to print, in decimal or 0x hexadecimal")
+	flag.Int64Var(&o.from, "from", 0, "print frames starting at or after this `offset`")
+	flag.Int64Var(&o.to, "to", 0, "print frames starting before this `offset`")
+	flag.IntVar(&o.max, "max", 256, "print at most this many body `bytes` per frame, or 0 for all")
+	flag.BoolVar(&o.headers, "headers", false, "print frame headers without bodies")
+	flag.Parse()
+
+	list, err := cmdflag.MessageTypes(*types)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gobsp-dump: %s\n", err)
+		os.Exit(2)
+	}
+	if list != nil {
+		o.types = make(map[gobsp.MessageType]bool)
+		for _, mt := range list {
+			o.types[mt] = true
+		}
+	}
+
+	names := flag.Args()
+	if len(names) == 0 {
+		names = []string{"-"}
+	}
+	var failed bool
+	for _, name := range names {
+		if err := dumpFile(os.Stdout, name, o); err != nil {
+			fmt.Fprintf(os.Stderr, "gobsp-dump: %s: %s\n", name, err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// dumpFile prints the frames of the named file, or standard input when the name
+// is "-".
+func dumpFile(iow io.Writer, name string, o options) error {
+	if name == "-" {
+		return dump(iow, os.Stdin, o)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return dump(iow, f, o)
+}
+
+// dump prints the frames of the stream read from ior.
+func dump(iow io.Writer, ior io.Reader, o options) error {
+	configurators := []gobsp.ScannerConfig{gobsp.Raw(), gobsp.OptionalPreamble(func(p gobsp.Preamble) error {
+		_, err := fmt.Fprintf(iow, "preamble version %d features %s application %q\n", p.Version, p.Features, p.Application)
+		return err
+	})}
+
+	// Only the body bytes to be printed are kept; the rest are read and
+	// discarded, so a large frame need not fit in memory.
+	var body bytes.Buffer
+	var keep, read int64 // keep is negative to keep the whole body
+	configurators = append(configurators, gobsp.DefaultHandler(func(r io.Reader) error {
+		body.Reset()
+		src := r
+		if keep >= 0 {
+			src = io.LimitReader(r, keep)
+		}
+		kept, err := body.ReadFrom(src)
+		read = kept
+		if err != nil {
+			return err
+		}
+		discarded, err := io.Copy(io.Discard, r)
+		read += discarded
+		return err
+	}))
+	scanner, err := gobsp.NewScanner(ior, configurators...)
+	if err != nil {
+		return err
+	}
+
+	for scanner.Scan() {
+		offset, mt, size := scanner.Offset(), scanner.MessageType(), scanner.MessageSize()
+		if o.to > 0 && offset >= o.to {
+			return nil
+		}
+		selected := offset >= o.from && (o.types == nil || o.types[mt])
+		switch {
+		case !selected || o.headers:
+			keep = 0
+		case o.max > 0:
+			keep = int64(o.max)
+		default:
+			keep = -1
+		}
+		if err := scanner.Handle(); err != nil {
+			return fmt.Errorf("offset %d: %s", offset, err)
+		}
+		if uint64(read) != size {
+			return fmt.Errorf("offset %d: truncated frame: body has %d of %d bytes", offset, read, size)
+		}
+		if !selected {
+			continue
+		}
+		if err := printFrame(iow, offset, mt, size, body.Bytes(), o); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("offset %d: %s", scanner.Offset(), err)
+	}
+	return nil
+}
+
+// printFrame prints a single frame, having the specified size, of whose body
+// only the bytes to be printed are given.
+func printFrame(iow io.Writer, offset int64, mt gobsp.MessageType, size uint64, body []byte, o options) error {
+	name := mt.String()
+	if name != gobsp.UVWI(mt).String() {
+		name = fmt.Sprintf("%d (%s)", uint64(mt), name)
+	}
+	if _, err := fmt.Fprintf(iow, "%08x  type %s  size %d\n", offset, name, size); err != nil {
+		return err
+	}
+	if o.headers || len(body) == 0 {
+		return nil
+	}
+	elided := size - uint64(len(body))
+	for _, line := range strings.SplitAfter(hex.Dump(body), "\n") {
+		if line != "" {
+			if _, err := io.WriteString(iow, "    "+line); err != nil {
+				return err
+			}
+		}
+	}
+	if elided > 0 {
+		if _, err := fmt.Fprintf(iow, "    ... %d more bytes\n", elided); err != nil {
+			return err
+		}
+	}
+	return nil
+}
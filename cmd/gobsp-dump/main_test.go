@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/karrick/gobsp"
+)
+
+// testStream returns a stream holding a preamble, a message of type 1, a
+// heartbeat, and a message of type 2.
+func testStream(t *testing.T) []byte {
+	bb := new(bytes.Buffer)
+	w, err := gobsp.NewComposerWithOptions(bb, gobsp.WritePreamble("test", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Compose(1, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Compose(gobsp.MTHeartbeat, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Compose(2, []byte("world")); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return bb.Bytes()
+}
+
+func testDump(t *testing.T, ior io.Reader, o options) (string, error) {
+	bb := new(bytes.Buffer)
+	err := dump(bb, ior, o)
+	return bb.String(), err
+}
+
+func TestDump(t *testing.T) {
+	actual, err := testDump(t, bytes.NewReader(testStream(t)), options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `preamble version 1 features 0 application "test"
+0000000b  type 1  size 5
+    00000000  68 65 6c 6c 6f                                    |hello|
+00000012  type 4294967042 (Heartbeat)  size 0
+00000018  type 2  size 5
+    00000000  77 6f 72 6c 64                                    |world|
+`
+	if actual != expected {
+		t.Errorf("Actual:\n%s\nExpected:\n%s", actual, expected)
+	}
+}
+
+func TestDumpFilters(t *testing.T) {
+	types := map[gobsp.MessageType]bool{2: true, gobsp.MTHeartbeat: true}
+	actual, err := testDump(t, bytes.NewReader(testStream(t)), options{types: types, headers: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "preamble version 1 features 0 application \"test\"\n" +
+		"00000012  type 4294967042 (Heartbeat)  size 0\n" +
+		"00000018  type 2  size 5\n"
+	if actual != expected {
+		t.Errorf("Actual:\n%s\nExpected:\n%s", actual, expected)
+	}
+
+	actual, err = testDump(t, bytes.NewReader(testStream(t)), options{from: 0x0c, to: 0x18, headers: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = "preamble version 1 features 0 application \"test\"\n" +
+		"00000012  type 4294967042 (Heartbeat)  size 0\n"
+	if actual != expected {
+		t.Errorf("Actual:\n%s\nExpected:\n%s", actual, expected)
+	}
+}
+
+func TestDumpTruncated(t *testing.T) {
+	stream := testStream(t)
+	_, err := testDump(t, bytes.NewReader(stream[:len(stream)-2]), options{headers: true})
+	if err == nil || !strings.HasPrefix(err.Error(), "offset 24: truncated frame") {
+		t.Errorf("Actual: %v; Expected: truncated frame at offset 24", err)
+	}
+
+	_, err = testDump(t, bytes.NewReader([]byte{0x01, 0x80}), options{})
+	if err == nil || err.Error() != "offset 0: unexpected EOF" {
+		t.Errorf("Actual: %v; Expected: unexpected EOF at offset 0", err)
+	}
+}
+
+func TestDumpElidesLongBodies(t *testing.T) {
+	stream := []byte{0x01, 0x14}
+	stream = append(stream, bytes.Repeat([]byte("x"), 20)...)
+	actual, err := testDump(t, bytes.NewReader(stream), options{max: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "00000000  type 1  size 20\n" +
+		"    00000000  78 78 78 78                                       |xxxx|\n" +
+		"    ... 16 more bytes\n"
+	if actual != expected {
+		t.Errorf("Actual:\n%s\nExpected:\n%s", actual, expected)
+	}
+}
+
+// largeFrame returns a stream holding a single frame of type 1 whose body is
+// size zero bytes, without holding the body in memory.
+func largeFrame(t *testing.T, size int64) io.Reader {
+	header := new(bytes.Buffer)
+	if err := gobsp.UVWI(1).MarshalBinaryTo(header); err != nil {
+		t.Fatal(err)
+	}
+	if err := gobsp.UVWI(size).MarshalBinaryTo(header); err != nil {
+		t.Fatal(err)
+	}
+	return io.MultiReader(header, io.LimitReader(zeros{}, size))
+}
+
+// zeros is an endless stream of zero bytes.
+type zeros struct{}
+
+func (zeros) Read(buf []byte) (int, error) {
+	for i := range buf {
+		buf[i] = 0
+	}
+	return len(buf), nil
+}
+
+func TestDumpLargeBodyBounded(t *testing.T) {
+	const size = 64 << 20
+	for _, o := range []options{{max: 4}, {headers: true}, {types: map[gobsp.MessageType]bool{2: true}}} {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		actual, err := testDump(t, largeFrame(t, size), o)
+		runtime.ReadMemStats(&after)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if allocated := after.TotalAlloc - before.TotalAlloc; allocated > size/16 {
+			t.Errorf("%+v: Actual: %d bytes allocated; Expected: fewer than %d", o, allocated, size/16)
+		}
+		if o.max > 0 && !strings.HasSuffix(actual, "... 67108860 more bytes\n") {
+			t.Errorf("Actual:\n%s\nExpected: elided bytes", actual)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+// gobsp-encode writes a gobsp stream built from messages described in JSON
+// Lines, read from files or standard input, so test fixtures may be authored,
+// and traffic replayed, without writing Go.
+//
+// Each line describes one message. Its type is given in decimal, or as a
+// string in decimal or 0x hexadecimal, and its body as a list of typed fields,
+// each an object naming the gobsp primitive, case-insensitively, and its value:
+//
+//	{"type": 3, "fields": [{"uvwi": 5}, {"string": "hi"}]}
+//	{"type": "0x10", "fields": [{"stringslice": ["a", "b"]}, {"bytes": "dead"}]}
+//
+// When a schema is given, a message may be named rather than numbered, and its
+// fields given by name, as gobsp-json writes them. The reserved control
+// messages may always be named:
+//
+//	{"name": "Login", "fields": {"user": "bob", "attempts": 3}}
+//	{"name": "Ping", "fields": {"token": 7}}
+//
+// A body may instead be given verbatim, in hexadecimal:
+//
+//	{"type": 4, "body": "0102ff"}
+//
+// Integers too large for a float64 may be written as strings. Blank lines are
+// ignored. An invalid line is reported with its line number, and gobsp-encode
+// exits with a non-zero status.
+//
+// Usage:
+//
+//	gobsp-encode [-schema schema.json] [-preamble application] [-o output] [file ...]
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/karrick/gobsp"
+	"github.com/karrick/gobsp/cmd/internal/cmdflag"
+	"github.com/karrick/gobsp/schema"
+)
+
+func main() {
+	schemaName := flag.String("schema", "", "schema `file` describing the message types")
+	application := flag.String("preamble", "", "begin the stream with a preamble naming this `application`")
+	output := flag.String("o", "-", "write the stream to this `file`, or standard output when \"-\"")
+	flag.Parse()
+
+	s, err := cmdflag.Schema(*schemaName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gobsp-encode: %s\n", err)
+		os.Exit(2)
+	}
+
+	out := os.Stdout
+	if *output != "-" {
+		if out, err = os.Create(*output); err != nil {
+			fmt.Fprintf(os.Stderr, "gobsp-encode: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	var configurators []gobsp.ComposerConfig
+	if *application != "" {
+		configurators = append(configurators, gobsp.WritePreamble(*application, 0))
+	}
+	w, err := gobsp.NewComposerWithOptions(out, configurators...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gobsp-encode: %s\n", err)
+		os.Exit(1)
+	}
+
+	names := flag.Args()
+	if len(names) == 0 {
+		names = []string{"-"}
+	}
+	var failed bool
+	for _, name := range names {
+		if err := encodeFile(w, name, s); err != nil {
+			fmt.Fprintf(os.Stderr, "gobsp-encode: %s: %s\n", name, err)
+			failed = true
+			break // later messages would follow a gap in the stream
+		}
+	}
+	if err := w.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "gobsp-encode: %s\n", err)
+		failed = true
+	}
+	if err := out.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "gobsp-encode: %s\n", err)
+		failed = true
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// encodeFile encodes the messages of the named file, or standard input when the
+// name is "-".
+func encodeFile(w *gobsp.Composer, name string, s *schema.Schema) error {
+	if name == "-" {
+		return encode(w, os.Stdin, s)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return encode(w, f, s)
+}
+
+// encode composes a message for each line read from ior.
+func encode(w *gobsp.Composer, ior io.Reader, s *schema.Schema) error {
+	br := bufio.NewReader(ior)
+	for line := 1; ; line++ {
+		buf, err := br.ReadBytes('\n')
+		if len(bytes.TrimSpace(buf)) > 0 {
+			mt, body, err := parseMessage(buf, s)
+			if err != nil {
+				return fmt.Errorf("line %d: %s", line, err)
+			}
+			if err = w.Compose(mt, body); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// message is a line of input.
+type message struct {
+	Type   interface{}     `json:"type"`
+	Name   string          `json:"name"`
+	Fields json.RawMessage `json:"fields"`
+	Body   *string         `json:"body"`
+}
+
+// parseMessage returns the type and body of the message described by a line
+// of input.
+func parseMessage(line []byte, s *schema.Schema) (gobsp.MessageType, []byte, error) {
+	var m message
+	d := json.NewDecoder(bytes.NewReader(line))
+	d.DisallowUnknownFields()
+	d.UseNumber()
+	if err := d.Decode(&m); err != nil {
+		return 0, nil, err
+	}
+
+	mt, err := messageType(m, s)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	fields := bytes.TrimSpace(m.Fields)
+	if m.Body != nil {
+		if len(fields) > 0 {
+			return 0, nil, errors.New("message has both fields and body")
+		}
+		body, err := hex.DecodeString(*m.Body)
+		if err != nil {
+			return 0, nil, fmt.Errorf("invalid body: %s", err)
+		}
+		return mt, body, nil
+	}
+	if len(fields) == 0 || string(fields) == "null" {
+		return mt, nil, nil
+	}
+
+	d = json.NewDecoder(bytes.NewReader(fields))
+	d.UseNumber()
+	if fields[0] == '{' {
+		var named map[string]interface{}
+		if err := d.Decode(&named); err != nil {
+			return 0, nil, err
+		}
+		body, err := s.Encode(mt, named)
+		return mt, body, err
+	}
+	var typed []map[string]interface{}
+	if err := d.Decode(&typed); err != nil {
+		return 0, nil, errors.New("fields must be a list of typed values or an object of named values")
+	}
+	bb := new(bytes.Buffer)
+	for i, field := range typed {
+		if len(field) != 1 {
+			return 0, nil, fmt.Errorf("field %d: must name exactly one type", i)
+		}
+		for name, value := range field {
+			typ, ok := schema.FieldType(name)
+			if !ok {
+				return 0, nil, fmt.Errorf("field %d: unknown type: %q", i, name)
+			}
+			if err := schema.EncodeValue(bb, typ, value); err != nil {
+				return 0, nil, fmt.Errorf("field %d: %s", i, err)
+			}
+		}
+	}
+	return mt, bb.Bytes(), nil
+}
+
+// messageType returns the type of a message, given either as a number or by
+// the name the schema describes.
+func messageType(m message, s *schema.Schema) (gobsp.MessageType, error) {
+	var text string
+	switch v := m.Type.(type) {
+	case nil:
+		if m.Name == "" {
+			return 0, errors.New("message has neither type nor name")
+		}
+		return s.TypeOf(m.Name)
+	case json.Number:
+		text = string(v)
+	case string:
+		text = v
+	default:
+		return 0, fmt.Errorf("invalid message type: %v", v)
+	}
+	mt, err := strconv.ParseUint(text, 0, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid message type: %q", text)
+	}
+	if m.Name != "" {
+		if named, err := s.TypeOf(m.Name); err == nil && named != gobsp.MessageType(mt) {
+			return 0, fmt.Errorf("message %q has type %d, not %d", m.Name, uint64(named), mt)
+		}
+	}
+	return gobsp.MessageType(mt), nil
+}
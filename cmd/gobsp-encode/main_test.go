@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/karrick/gobsp"
+	"github.com/karrick/gobsp/schema"
+)
+
+const testSchema = `{"messages": {"1": {"name": "Login", "fields": [
+	{"name": "user", "type": "String"},
+	{"name": "attempts", "type": "Uint8"}
+]}}}`
+
+func testEncode(t *testing.T, input string) ([]byte, error) {
+	s, err := schema.Load(strings.NewReader(testSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bb := new(bytes.Buffer)
+	w := gobsp.NewComposer(bb)
+	err = encode(w, strings.NewReader(input), s)
+	if cerr := w.Close(); cerr != nil {
+		t.Fatal(cerr)
+	}
+	return bb.Bytes(), err
+}
+
+func TestEncode(t *testing.T) {
+	actual, err := testEncode(t, `{"type": 3, "fields": [{"uvwi": 5}, {"string": "hi"}]}
+
+{"name": "Login", "fields": {"user": "bob", "attempts": 2}}
+{"type": "0x4", "body": "0102ff"}
+{"name": "Ping", "fields": {"token": 7}}
+{"type": 5}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := new(bytes.Buffer)
+	w := gobsp.NewComposer(expected)
+	for _, m := range []struct {
+		mt   gobsp.MessageType
+		body []byte
+	}{
+		{3, []byte{0x05, 0x02, 'h', 'i'}},
+		{1, []byte{0x03, 'b', 'o', 'b', 0x02}},
+		{4, []byte{0x01, 0x02, 0xff}},
+		{gobsp.MTPing, []byte{0, 0, 0, 0, 0, 0, 0, 7}},
+		{5, nil},
+	} {
+		if err = w.Compose(m.mt, m.body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(actual, expected.Bytes()) {
+		t.Errorf("Actual: %x; Expected: %x", actual, expected.Bytes())
+	}
+}
+
+func TestEncodeErrors(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected string
+	}{
+		{`{"type": 1, "fields": [{"uint128": 5}]}`, `line 1: field 0: unknown type: "uint128"`},
+		{`{"type": 1, "fields": [{"uint8": 256}]}`, `line 1: field 0: cannot encode 256 as Uint8`},
+		{`{"type": 1, "fields": [{"uint8": 1, "int8": 1}]}`, `line 1: field 0: must name exactly one type`},
+		{`{"name": "Login", "fields": {"user": "bob"}}`, `line 1: missing field: "attempts"`},
+		{`{"name": "Logout"}`, `line 1: unknown message: "Logout"`},
+		{`{"name": "Login", "type": 2}`, `line 1: message "Login" has type 1, not 2`},
+		{`{"fields": []}`, `line 1: message has neither type nor name`},
+		{`{"type": -1}`, `line 1: invalid message type: "-1"`},
+		{`{"type": 1, "body": "zz"}`, `line 1: invalid body: encoding/hex: invalid byte: U+007A 'z'`},
+		{`{"type": 1, "body": "00", "fields": []}`, `line 1: message has both fields and body`},
+		{"{\"type\": 1}\n\n{\"typ\": 1}", `line 3: json: unknown field "typ"`},
+	}
+	for _, c := range cases {
+		_, err := testEncode(t, c.input)
+		if err == nil || err.Error() != c.expected {
+			t.Errorf("%s: Actual: %v; Expected: %s", c.input, err, c.expected)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+// gobsp-filter rewrites a gobsp stream, read from a file or standard input,
+// by dropping, sampling, truncating, and renumbering messages, and writes the
+// result as a new stream. Bodies are written unchanged unless truncated. See
+// the filter package for how batches, fragments, and control messages are
+// treated. Messages larger than -max-frame-size bytes are written as
+// fragments, so the output suits consumers that limit the size of frames.
+//
+// The rules are applied in a fixed order: -keep, -drop, -sample, -truncate,
+// then -renumber. Message types are selected by their original numbers, even
+// when they are also renumbered.
+//
+// Usage:
+//
+//	gobsp-filter [-keep types] [-drop types] [-sample n] [-truncate bytes [-truncate-types types]] [-renumber 1=101,2=102] [-max-frame-size bytes] [-o output] [file]
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/karrick/gobsp"
+	"github.com/karrick/gobsp/cmd/internal/cmdflag"
+	"github.com/karrick/gobsp/filter"
+)
+
+// options selects the rules.
+type options struct {
+	keep, drop    []gobsp.MessageType
+	sample        int
+	truncate      int // -1 to leave bodies whole
+	truncateTypes []gobsp.MessageType
+	renumber      map[gobsp.MessageType]gobsp.MessageType
+}
+
+func main() {
+	keep := flag.String("keep", "", "comma separated message `types` to keep, dropping all others")
+	drop := flag.String("drop", "", "comma separated message `types` to drop")
+	renumber := flag.String("renumber", "", "comma separated `old=new` message type pairs")
+	truncateTypes := flag.String("truncate-types", "", "comma separated message `types` to truncate, rather than all")
+	output := flag.String("o", "-", "write the stream to this `file`, or standard output when \"-\"")
+	maxFrameSize := flag.Int("max-frame-size", 0, "fragment messages larger than this many `bytes`, when greater than 0")
+	var o options
+	flag.IntVar(&o.sample, "sample", 1, "keep one of every `n` messages")
+	flag.IntVar(&o.truncate, "truncate", -1, "truncate message bodies to this many `bytes`")
+	flag.Parse()
+
+	rules, err := o.parse(*keep, *drop, *renumber, *truncateTypes)
+	if err == nil && flag.NArg() > 1 {
+		err = fmt.Errorf("at most one input file")
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gobsp-filter: %s\n", err)
+		os.Exit(2)
+	}
+
+	name := flag.Arg(0)
+	if name == "" {
+		name = "-"
+	}
+	f := filter.New(rules...)
+	if *maxFrameSize > 0 {
+		f.ComposerConfigs = append(f.ComposerConfigs, gobsp.MaxFrameSize(*maxFrameSize))
+	}
+	if err = filterFile(*output, name, f); err != nil {
+		fmt.Fprintf(os.Stderr, "gobsp-filter: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// parse parses the flags given as lists, and returns the rules the options
+// select.
+func (o *options) parse(keep, drop, renumber, truncateTypes string) ([]filter.Rule, error) {
+	var err error
+	if o.keep, err = cmdflag.MessageTypes(keep); err != nil {
+		return nil, err
+	}
+	if o.drop, err = cmdflag.MessageTypes(drop); err != nil {
+		return nil, err
+	}
+	if o.truncateTypes, err = cmdflag.MessageTypes(truncateTypes); err != nil {
+		return nil, err
+	}
+	if o.renumber, err = parseRenumber(renumber); err != nil {
+		return nil, err
+	}
+	return o.rules(), nil
+}
+
+// rules returns the rules the options select, in the order they are applied.
+func (o *options) rules() []filter.Rule {
+	var rules []filter.Rule
+	if o.keep != nil {
+		rules = append(rules, filter.Keep(o.keep...))
+	}
+	if o.drop != nil {
+		rules = append(rules, filter.Drop(o.drop...))
+	}
+	if o.sample > 1 {
+		rules = append(rules, filter.Sample(o.sample))
+	}
+	if o.truncate >= 0 {
+		rules = append(rules, filter.Truncate(o.truncate, o.truncateTypes...))
+	}
+	if o.renumber != nil {
+		rules = append(rules, filter.Renumber(o.renumber))
+	}
+	return rules
+}
+
+// parseRenumber parses a comma separated list of old=new message type pairs.
+func parseRenumber(list string) (map[gobsp.MessageType]gobsp.MessageType, error) {
+	if list == "" {
+		return nil, nil
+	}
+	mapping := make(map[gobsp.MessageType]gobsp.MessageType)
+	for _, pair := range strings.Split(list, ",") {
+		i := strings.IndexByte(pair, '=')
+		if i < 0 {
+			return nil, fmt.Errorf("invalid renumbering: %q", pair)
+		}
+		from, err := cmdflag.MessageType(pair[:i])
+		if err != nil {
+			return nil, err
+		}
+		to, err := cmdflag.MessageType(pair[i+1:])
+		if err != nil {
+			return nil, err
+		}
+		mapping[from] = to
+	}
+	return mapping, nil
+}
+
+// filterFile filters the named file, or standard input when the name is "-", to
+// the named output file, or standard output when the name is "-".
+func filterFile(output, name string, f *filter.Filter) error {
+	var in io.Reader = os.Stdin
+	if name != "-" {
+		fi, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer fi.Close()
+		in = fi
+	}
+	if output == "-" {
+		_, err := f.Copy(os.Stdout, in)
+		return err
+	}
+	out, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
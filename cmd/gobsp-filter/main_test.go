@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/karrick/gobsp/filter"
+)
+
+func TestOptionsRules(t *testing.T) {
+	o := options{sample: 1, truncate: 1}
+	rules, err := o.parse("1,2,0x3", "2", "1=0x10, 3=30", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := []byte{
+		0x01, 0x02, 'a', 'b',
+		0x02, 0x02, 'c', 'd',
+		0x03, 0x02, 'e', 'f',
+		0x04, 0x02, 'g', 'h',
+	}
+	bb := new(bytes.Buffer)
+	if _, err = filter.New(rules...).Copy(bb, bytes.NewReader(stream)); err != nil {
+		t.Fatal(err)
+	}
+	// Types are selected by their original numbers, and only type 3 is
+	// truncated.
+	expected := []byte{0x10, 0x02, 'a', 'b', 0x1e, 0x01, 'e'}
+	if !bytes.Equal(bb.Bytes(), expected) {
+		t.Errorf("Actual: %x; Expected: %x", bb.Bytes(), expected)
+	}
+}
+
+func TestOptionsErrors(t *testing.T) {
+	var o options
+	for _, c := range []struct {
+		keep, renumber, expected string
+	}{
+		{"1,x", "", `invalid message type: "x"`},
+		{"", "1", `invalid renumbering: "1"`},
+		{"", "1=-2", `invalid message type: "-2"`},
+	} {
+		_, err := o.parse(c.keep, "", c.renumber, "")
+		if err == nil || err.Error() != c.expected {
+			t.Errorf("Actual: %v; Expected: %s", err, c.expected)
+		}
+	}
+}
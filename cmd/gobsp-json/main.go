@@ -0,0 +1,76 @@
+// gobsp-json decodes the messages of a gobsp stream, read from files or
+// standard input, using a schema, and writes them as JSON Lines or as a JSON
+// array, so they may be processed by tools such as jq. See the schema package
+// for the format of the schema file.
+//
+// Usage:
+//
+//	gobsp-json -schema schema.json [-array] [file ...]
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/karrick/gobsp"
+	"github.com/karrick/gobsp/cmd/internal/cmdflag"
+	"github.com/karrick/gobsp/schema"
+)
+
+func main() {
+	schemaName := flag.String("schema", "", "schema `file` describing the message types")
+	array := flag.Bool("array", false, "write a single JSON array rather than JSON Lines")
+	flag.Parse()
+
+	s, err := cmdflag.Schema(*schemaName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gobsp-json: %s\n", err)
+		os.Exit(2)
+	}
+	format := schema.JSONLines
+	if *array {
+		format = schema.JSON
+	}
+
+	names := flag.Args()
+	if len(names) == 0 {
+		names = []string{"-"}
+	}
+	out := bufio.NewWriter(os.Stdout)
+	var failed bool
+	for _, name := range names {
+		if err := exportFile(out, name, s, format); err != nil {
+			fmt.Fprintf(os.Stderr, "gobsp-json: %s: %s\n", name, err)
+			failed = true
+		}
+	}
+	if err := out.Flush(); err != nil {
+		fmt.Fprintf(os.Stderr, "gobsp-json: %s\n", err)
+		failed = true
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// exportFile exports the named file, or standard input when the name is "-".
+func exportFile(iow io.Writer, name string, s *schema.Schema, format schema.Format) error {
+	if name == "-" {
+		return export(iow, os.Stdin, s, format)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return export(iow, f, s, format)
+}
+
+// export exports the stream read from ior, skipping its preamble, if any.
+func export(iow io.Writer, ior io.Reader, s *schema.Schema, format schema.Format) error {
+	skip := gobsp.OptionalPreamble(func(gobsp.Preamble) error { return nil })
+	return schema.Export(iow, ior, s, format, skip)
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/karrick/gobsp"
+	"github.com/karrick/gobsp/schema"
+)
+
+func TestExportSkipsPreamble(t *testing.T) {
+	bb := new(bytes.Buffer)
+	w, err := gobsp.NewComposerWithOptions(bb, gobsp.WritePreamble("test", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.ComposeBinary(gobsp.MTPing, &gobsp.PingMessage{Token: 7}); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := schema.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := new(bytes.Buffer)
+	if err = export(out, bb, s, schema.JSONLines); err != nil {
+		t.Fatal(err)
+	}
+	if actual, expected := out.String(), "{\"offset\":11,\"type\":4294967045,\"name\":\"Ping\",\"fields\":{\"token\":7}}\n"; actual != expected {
+		t.Errorf("Actual: %s; Expected: %s", actual, expected)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/karrick/gobsp"
+	"github.com/karrick/gobsp/archive"
+)
+
+// event identifies what a capture record holds.
+type event uint8
+
+const (
+	fromClient     event = iota // frame the client sent to the server
+	fromServer                  // frame the server sent to the client
+	clientPreamble              // preamble the client sent to the server
+	serverPreamble              // preamble the server sent to the client
+	closed                      // connection ended
+)
+
+func (e event) String() string {
+	switch e {
+	case fromClient:
+		return "client"
+	case fromServer:
+		return "server"
+	case clientPreamble:
+		return "client preamble"
+	case serverPreamble:
+		return "server preamble"
+	case closed:
+		return "closed"
+	}
+	return fmt.Sprintf("event %d", uint8(e))
+}
+
+// captureApplication is the application named by the preamble of a capture
+// file.
+const captureApplication = "gobsp-proxy"
+
+// record is a single captured event. Frames are recorded with their own
+// message types, and other events with type zero.
+type record struct {
+	when        time.Time
+	event       event
+	connection  uint64
+	messageType gobsp.MessageType
+	body        []byte // frame body, or the encoded preamble
+}
+
+// marshal returns the body of the archive message recording the event: the
+// Uint8 event, the UVWI connection number, then the frame body or preamble.
+func (r record) marshal() []byte {
+	bb := new(bytes.Buffer)
+	_ = gobsp.Uint8(r.event).MarshalBinaryTo(bb)
+	_ = gobsp.UVWI(r.connection).MarshalBinaryTo(bb)
+	bb.Write(r.body)
+	return bb.Bytes()
+}
+
+// unmarshal decodes the body of an archive message recording an event.
+func (r *record) unmarshal(buf []byte) error {
+	br := bytes.NewReader(buf)
+	var e gobsp.Uint8
+	var connection gobsp.UVWI
+	err := e.UnmarshalBinaryFrom(br)
+	if err == nil {
+		err = connection.UnmarshalBinaryFrom(br)
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return fmt.Errorf("invalid capture record: %s", err)
+	}
+	r.event, r.connection = event(e), uint64(connection)
+	r.body = buf[len(buf)-br.Len():]
+	return nil
+}
+
+// recorder appends events to a capture file. It is safe for concurrent use, and
+// a nil recorder discards events.
+type recorder struct {
+	mu  sync.Mutex
+	w   *archive.Writer
+	err error // first error recording an event
+}
+
+func newRecorder(iow io.Writer) (*recorder, error) {
+	w, err := archive.NewWriter(iow, captureApplication)
+	if err != nil {
+		return nil, err
+	}
+	return &recorder{w: w}, nil
+}
+
+// record appends an event, stamped with the current time. A frame whose message
+// type is too large to archive is skipped, and the error returned, so that the
+// capture continues. Once recording otherwise fails, later events are
+// discarded, and the error returned by close.
+func (c *recorder) record(e event, connection uint64, messageType gobsp.MessageType, body []byte) error {
+	if c == nil {
+		return nil
+	}
+	r := record{event: e, connection: connection, messageType: messageType, body: body}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return nil
+	}
+	err := c.w.AppendAt(time.Now(), messageType, r.marshal())
+	if _, ok := err.(archive.ErrTypeTooLarge); ok {
+		return err
+	}
+	c.err = err
+	return nil
+}
+
+// close completes the capture file.
+func (c *recorder) close() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.w.Close(); c.err == nil {
+		c.err = err
+	}
+	return c.err
+}
+
+// readCapture returns the events recorded in a capture file.
+func readCapture(ra io.ReaderAt, size int64) ([]record, error) {
+	r, err := archive.NewReader(ra, size)
+	if err != nil {
+		return nil, err
+	}
+	if p := r.Preamble(); string(p.Application) != captureApplication {
+		return nil, fmt.Errorf("not a capture file: application %q", p.Application)
+	}
+
+	records := make([]record, 0, r.Len())
+	var body bytes.Buffer
+	scanner, err := r.Scanner(gobsp.Raw(), gobsp.DefaultHandler(func(ior io.Reader) error {
+		body.Reset()
+		_, err := body.ReadFrom(ior)
+		return err
+	}))
+	if err != nil {
+		return nil, err
+	}
+	for n := 0; scanner.Scan(); n++ {
+		if err := scanner.Handle(); err != nil {
+			return nil, err
+		}
+		e, err := r.Entry(n)
+		if err != nil {
+			return nil, err
+		}
+		rec := record{when: e.Time, messageType: scanner.MessageType()}
+		if err = rec.unmarshal(append([]byte(nil), body.Bytes()...)); err != nil {
+			return nil, fmt.Errorf("record %d: %s", n, err)
+		}
+		records = append(records, rec)
+	}
+	return records, scanner.Err()
+}
@@ -0,0 +1,114 @@
+// gobsp-proxy sits between gobsp clients and a server, forwarding frames in
+// both directions, and optionally recording them to a capture file, so traffic
+// may later be replayed against a server to reproduce what it saw.
+//
+// In proxy mode, gobsp-proxy accepts client connections on the listen address,
+// and opens a connection to the target server for each. Frames are forwarded
+// exactly as they are framed, so batches and fragments are neither unpacked nor
+// rebuilt, and control messages such as heartbeats pass through. When streams
+// begin with a preamble, the -preamble flag must be given. On interrupt, open
+// connections are closed and the capture file completed.
+//
+// A capture file is an archive (see the archive package) in which each record
+// holds a frame, with its message type, time of arrival, connection number,
+// and direction, or marks a preamble or the end of a connection.
+//
+// In replay mode, gobsp-proxy opens a connection to the target server for each
+// captured client connection, and sends what the client sent, with the
+// original timing scaled by -speed, or without delay when -speed is 0. What the
+// server sends is read and discarded.
+//
+// Usage:
+//
+//	gobsp-proxy -listen :7000 -target server:7000 [-capture file] [-preamble]
+//	gobsp-proxy -replay file -target server:7000 [-speed 1]
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func main() {
+	listen := flag.String("listen", "", "accept client connections on this `address`")
+	target := flag.String("target", "", "forward connections to, or replay against, the server at this `address`")
+	capture := flag.String("capture", "", "record forwarded frames to this capture `file`")
+	preamble := flag.Bool("preamble", false, "streams in both directions begin with a preamble")
+	replayName := flag.String("replay", "", "replay the client frames of this capture `file`")
+	speed := flag.Float64("speed", 1, "replay at this multiple of the original speed, or 0 for no delay")
+	flag.Parse()
+
+	if *target == "" || (*listen == "") == (*replayName == "") || *speed < 0 {
+		fmt.Fprintf(os.Stderr, "gobsp-proxy: requires -target, and either -listen or -replay\n")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var err error
+	if *replayName != "" {
+		err = replayFile(*replayName, *target, *speed)
+	} else {
+		err = serve(*listen, *target, *capture, *preamble)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gobsp-proxy: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// serve runs the proxy until interrupted.
+func serve(listen, target, capture string, preamble bool) error {
+	p := &proxy{target: target, preamble: preamble, logf: log.Printf}
+	if capture != "" {
+		f, err := os.Create(capture)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if p.recorder, err = newRecorder(f); err != nil {
+			return err
+		}
+	}
+
+	l, err := net.Listen("tcp", listen)
+	if err != nil {
+		return err
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		p.shutdown()
+		_ = l.Close()
+	}()
+
+	err = p.serve(l)
+	p.shutdown()
+	if rerr := p.recorder.close(); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// replayFile replays the named capture file against the target server.
+func replayFile(name, target string, speed float64) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	records, err := readCapture(f, fi.Size())
+	if err != nil {
+		return err
+	}
+	return replay(records, func() (net.Conn, error) { return net.Dial("tcp", target) }, speed)
+}
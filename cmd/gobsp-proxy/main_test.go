@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/karrick/gobsp"
+	"github.com/karrick/gobsp/archive"
+)
+
+func ensure(t *testing.T, actual, expected interface{}) {
+	t.Helper()
+	if actual != expected {
+		t.Errorf("Actual: %#v; Expected: %#v", actual, expected)
+	}
+}
+
+// testServer starts a server that answers every message with a message of its
+// type plus 100 and the same body, and sends a description of each connection's
+// messages on the returned channel when the connection ends.
+func testServer(t *testing.T) (string, <-chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	connections := make(chan string, 4)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				connections <- testEcho(conn)
+			}()
+		}
+	}()
+	return l.Addr().String(), connections
+}
+
+func testEcho(conn net.Conn) string {
+	var received string
+	w, err := gobsp.NewComposerWithOptions(conn, gobsp.WritePreamble("server", 0))
+	if err != nil {
+		return err.Error()
+	}
+	if err = w.Flush(); err != nil {
+		return err.Error()
+	}
+	var scanner *gobsp.Scanner
+	scanner, err = gobsp.NewScanner(conn,
+		gobsp.CheckPreamble(func(p gobsp.Preamble) error {
+			received += fmt.Sprintf("preamble %s;", p.Application)
+			return nil
+		}),
+		gobsp.DefaultHandler(func(r io.Reader) error {
+			body, err := ioutil.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			received += fmt.Sprintf("%d %s;", uint64(scanner.MessageType()), body)
+			if err = w.Compose(scanner.MessageType()+100, body); err != nil {
+				return err
+			}
+			return w.Flush()
+		}))
+	if err != nil {
+		return err.Error()
+	}
+	for scanner.Scan() {
+		if err = scanner.Handle(); err != nil {
+			return err.Error()
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return err.Error()
+	}
+	return received
+}
+
+// testClient sends two messages through the proxy, and returns the replies.
+func testClient(t *testing.T, address string) string {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	w, err := gobsp.NewComposerWithOptions(conn, gobsp.WritePreamble("client", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Compose(1, []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Compose(2, []byte("bc")); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	var replies string
+	var scanner *gobsp.Scanner
+	scanner, err = gobsp.NewScanner(conn,
+		gobsp.ExpectPreamble("server", 0),
+		gobsp.DefaultHandler(func(r io.Reader) error {
+			body, err := ioutil.ReadAll(r)
+			replies += fmt.Sprintf("%d %s;", uint64(scanner.MessageType()), body)
+			return err
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for scanner.Scan() {
+		if err = scanner.Handle(); err != nil {
+			t.Fatal(err)
+		}
+		if scanner.MessageType() == 102 {
+			// Ending the client's stream ends the server's, which ends
+			// the scan.
+			if err = conn.(*net.TCPConn).CloseWrite(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return replies
+}
+
+func TestProxyRecordAndReplay(t *testing.T) {
+	target, connections := testServer(t)
+
+	capture := new(bytes.Buffer)
+	c, err := newRecorder(capture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &proxy{target: target, preamble: true, recorder: c, logf: t.Logf}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- p.serve(l) }()
+
+	ensure(t, testClient(t, l.Addr().String()), "101 a;102 bc;")
+	ensure(t, <-connections, "preamble client;1 a;2 bc;")
+
+	p.shutdown()
+	_ = l.Close()
+	ensure(t, <-served, nil)
+	ensure(t, c.close(), nil)
+
+	records, err := readCapture(bytes.NewReader(capture.Bytes()), int64(capture.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var client, server string
+	for _, r := range records {
+		ensure(t, r.connection, uint64(0))
+		switch r.event {
+		case fromClient:
+			client += fmt.Sprintf("%d %s;", uint64(r.messageType), r.body)
+		case fromServer:
+			server += fmt.Sprintf("%d %s;", uint64(r.messageType), r.body)
+		default:
+			if r.event != closed {
+				ensure(t, bytes.HasPrefix(r.body, []byte("GBSP")), true)
+			}
+		}
+	}
+	ensure(t, client, "1 a;2 bc;")
+	ensure(t, server, "101 a;102 bc;")
+	ensure(t, len(records), 7)
+	ensure(t, records[len(records)-1].event, closed)
+
+	// Replaying the capture sends what the client sent.
+	err = replay(records, func() (net.Conn, error) { return net.Dial("tcp", target) }, 0)
+	ensure(t, err, nil)
+	ensure(t, <-connections, "preamble client;1 a;2 bc;")
+}
+
+func TestReplayTiming(t *testing.T) {
+	target, connections := testServer(t)
+	start := time.Now()
+	records := []record{
+		{when: start, event: clientPreamble, body: testPreamble(t)},
+		{when: start, event: fromClient, messageType: 1, body: []byte("a")},
+		{when: start.Add(200 * time.Millisecond), event: fromServer, messageType: 101, body: []byte("a")},
+		{when: start.Add(400 * time.Millisecond), event: fromClient, messageType: 2, body: []byte("b")},
+		{when: start.Add(400 * time.Millisecond), event: closed},
+	}
+
+	began := time.Now()
+	err := replay(records, func() (net.Conn, error) { return net.Dial("tcp", target) }, 2)
+	ensure(t, err, nil)
+	if elapsed := time.Since(began); elapsed < 200*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("Actual: %s; Expected: about 200ms at twice the original speed", elapsed)
+	}
+	ensure(t, <-connections, "preamble client;1 a;2 b;")
+}
+
+func testPreamble(t *testing.T) []byte {
+	bb := new(bytes.Buffer)
+	p := gobsp.Preamble{Version: gobsp.FramingVersion, Application: "client"}
+	if err := p.MarshalBinaryTo(bb); err != nil {
+		t.Fatal(err)
+	}
+	return bb.Bytes()
+}
+
+func TestRecorderSkipsLargeType(t *testing.T) {
+	capture := new(bytes.Buffer)
+	c, err := newRecorder(capture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ensure(t, c.record(fromClient, 0, 1, []byte("a")), nil)
+	ensure(t, c.record(fromClient, 0, 1<<32, []byte("b")), error(archive.ErrTypeTooLarge(1<<32)))
+	ensure(t, c.record(fromClient, 0, 2, []byte("c")), nil)
+	ensure(t, c.close(), nil)
+
+	records, err := readCapture(bytes.NewReader(capture.Bytes()), int64(capture.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ensure(t, len(records), 2)
+	ensure(t, string(records[1].body), "c")
+}
+
+func TestRecordMarshal(t *testing.T) {
+	r := record{event: fromServer, connection: 300, body: []byte("xyz")}
+	var actual record
+	ensure(t, actual.unmarshal(r.marshal()), nil)
+	ensure(t, actual.event, fromServer)
+	ensure(t, actual.connection, uint64(300))
+	ensure(t, string(actual.body), "xyz")
+
+	ensure(t, actual.unmarshal([]byte{1}).Error(), "invalid capture record: unexpected EOF")
+}
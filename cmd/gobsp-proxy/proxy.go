@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/karrick/gobsp"
+)
+
+// proxy accepts client connections, and forwards the frames of each to and
+// from its own connection to the target server.
+type proxy struct {
+	target   string    // address of the server
+	preamble bool      // streams in both directions begin with a preamble
+	recorder *recorder // nil when not capturing
+	logf     func(format string, args ...interface{})
+
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{} // open connections, closed on shutdown
+	next   uint64                // number of the next connection
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// serve accepts connections from the listener until it is closed.
+func (p *proxy) serve(l net.Listener) error {
+	for {
+		client, err := l.Accept()
+		if err != nil {
+			p.mu.Lock()
+			closed := p.closed
+			p.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			_ = client.Close()
+			return nil
+		}
+		connection := p.next
+		p.next++
+		p.wg.Add(1)
+		p.mu.Unlock()
+		go p.handle(connection, client)
+	}
+}
+
+// shutdown closes every open connection, and waits for their handlers to
+// finish. Connections accepted afterwards are closed at once, and serve
+// returns without error once the caller closes the listener.
+func (p *proxy) shutdown() {
+	p.mu.Lock()
+	p.closed = true
+	for c := range p.conns {
+		_ = c.Close()
+	}
+	p.mu.Unlock()
+	p.wg.Wait()
+}
+
+// track adds a connection to those closed on shutdown, or closes it and
+// returns false when the proxy is already shutting down.
+func (p *proxy) track(c net.Conn) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		_ = c.Close()
+		return false
+	}
+	if p.conns == nil {
+		p.conns = make(map[net.Conn]struct{})
+	}
+	p.conns[c] = struct{}{}
+	return true
+}
+
+func (p *proxy) untrack(c net.Conn) {
+	p.mu.Lock()
+	delete(p.conns, c)
+	p.mu.Unlock()
+	_ = c.Close()
+}
+
+// handle forwards frames between a client and a new connection to the server,
+// until both sides end their streams, or either sends a malformed frame.
+func (p *proxy) handle(connection uint64, client net.Conn) {
+	defer p.wg.Done()
+	if !p.track(client) {
+		return
+	}
+	defer p.untrack(client)
+
+	server, err := net.Dial("tcp", p.target)
+	if err != nil {
+		p.logf("connection %d: %s", connection, err)
+		return
+	}
+	if !p.track(server) {
+		return
+	}
+	defer p.untrack(server)
+
+	errs := make(chan error, 2)
+	pipe := func(dst, src net.Conn, direction event) {
+		err := p.forward(dst, src, connection, direction)
+		if cw, ok := dst.(interface{ CloseWrite() error }); ok && err == nil {
+			// Pass on a clean end of stream, while still forwarding what
+			// the peer sends in reply.
+			_ = cw.CloseWrite()
+		} else {
+			_ = client.Close()
+			_ = server.Close()
+		}
+		errs <- err
+	}
+	go pipe(server, client, fromClient)
+	go pipe(client, server, fromServer)
+	err = <-errs
+	if err2 := <-errs; err == nil {
+		err = err2
+	}
+	_ = p.recorder.record(closed, connection, 0, nil)
+	if err != nil {
+		p.logf("connection %d: %s", connection, err)
+	}
+}
+
+// forward copies frames read from src to dst, recording each one. It returns
+// nil when src ends cleanly, or dst or src is closed by the other direction.
+func (p *proxy) forward(dst io.Writer, src io.Reader, connection uint64, direction event) error {
+	configurators := []gobsp.ScannerConfig{gobsp.Raw()}
+	if p.preamble {
+		e := clientPreamble
+		if direction == fromServer {
+			e = serverPreamble
+		}
+		configurators = append(configurators, gobsp.CheckPreamble(func(preamble gobsp.Preamble) error {
+			bb := new(bytes.Buffer)
+			if err := preamble.MarshalBinaryTo(bb); err != nil {
+				return err
+			}
+			if _, err := dst.Write(bb.Bytes()); err != nil {
+				return err
+			}
+			return p.recorder.record(e, connection, 0, bb.Bytes())
+		}))
+	}
+
+	var body bytes.Buffer
+	configurators = append(configurators, gobsp.DefaultHandler(func(r io.Reader) error {
+		body.Reset()
+		_, err := body.ReadFrom(r)
+		return err
+	}))
+	scanner, err := gobsp.NewScanner(src, configurators...)
+	if err != nil {
+		return quiet(fmt.Errorf("%s: %w", direction, err))
+	}
+	w := gobsp.NewComposer(dst)
+
+	for scanner.Scan() {
+		mt, size := scanner.MessageType(), scanner.MessageSize()
+		if err := scanner.Handle(); err != nil {
+			return quiet(fmt.Errorf("%s: %w", direction, err))
+		}
+		if uint64(body.Len()) != size {
+			return fmt.Errorf("%s: truncated frame: body has %d of %d bytes", direction, body.Len(), size)
+		}
+		if err := p.recorder.record(direction, connection, mt, body.Bytes()); err != nil {
+			p.logf("connection %d: %s: frame not recorded: %s", connection, direction, err)
+		}
+		if err := w.Compose(mt, body.Bytes()); err != nil {
+			return quiet(err)
+		}
+		if err := w.Flush(); err != nil {
+			return quiet(err)
+		}
+	}
+	return quiet(scanner.Err())
+}
+
+// quiet returns nil for the errors expected when a connection is closed, either
+// by its peer or by the proxy ending the other direction.
+func quiet(err error) error {
+	if err == nil || err == io.EOF || errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
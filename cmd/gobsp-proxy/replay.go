@@ -0,0 +1,122 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"time"
+
+	"github.com/karrick/gobsp"
+)
+
+// replayConn is a connection to the server replaying one captured client
+// connection.
+type replayConn struct {
+	conn    net.Conn
+	w       *gobsp.Composer
+	drained chan struct{} // closed once the server ends its stream
+}
+
+// drainTimeout is how long replay waits for the server to end its stream after
+// a replayed client ends its own.
+const drainTimeout = 5 * time.Second
+
+// close ends the client's stream, as the captured client did, while still
+// reading what the server sends, until it ends its own stream or the drain
+// timeout passes.
+func (c *replayConn) close() error {
+	cw, ok := c.conn.(interface{ CloseWrite() error })
+	if !ok {
+		return c.conn.Close()
+	}
+	if err := cw.CloseWrite(); err != nil {
+		return err
+	}
+	return c.conn.SetReadDeadline(time.Now().Add(drainTimeout))
+}
+
+// replay sends the frames each captured client sent to the server over a new
+// connection for each, and ends each connection's stream when its capture
+// does. What the server sends is read and discarded. Before returning, replay
+// waits for the server to end the streams of the connections that ended.
+//
+// Events are replayed in the order they were captured. When speed is positive,
+// each is delayed until its original time from the start of the capture,
+// divided by speed, so 1 replays with the original timing, and 2 twice as
+// fast. When speed is zero, events are replayed without delay.
+func replay(records []record, dial func() (net.Conn, error), speed float64) error {
+	conns := make(map[uint64]*replayConn)
+	var ended []*replayConn
+	defer func() {
+		for _, c := range conns {
+			_ = c.conn.Close()
+		}
+		for _, c := range ended {
+			<-c.drained
+			_ = c.conn.Close()
+		}
+	}()
+
+	// connect returns the connection replaying the specified captured
+	// connection, dialing the server when it is first used.
+	connect := func(connection uint64) (*replayConn, error) {
+		if c, ok := conns[connection]; ok {
+			return c, nil
+		}
+		conn, err := dial()
+		if err != nil {
+			return nil, err
+		}
+		w := gobsp.NewComposer(conn)
+		c := &replayConn{conn: conn, w: w, drained: make(chan struct{})}
+		go func() {
+			_, _ = io.Copy(ioutil.Discard, conn)
+			close(c.drained)
+		}()
+		conns[connection] = c
+		return c, nil
+	}
+
+	start := time.Now()
+	for _, r := range records {
+		switch r.event {
+		case fromClient, clientPreamble, closed:
+		default:
+			continue // sent by the server
+		}
+		if speed > 0 {
+			due := start.Add(time.Duration(float64(r.when.Sub(records[0].when)) / speed))
+			time.Sleep(time.Until(due))
+		}
+
+		if r.event == closed {
+			if c, ok := conns[r.connection]; ok {
+				delete(conns, r.connection)
+				ended = append(ended, c)
+				if err := c.close(); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		c, err := connect(r.connection)
+		if err != nil {
+			return err
+		}
+		if r.event == clientPreamble {
+			// The Composer has buffered nothing, so the preamble is
+			// written ahead of every frame.
+			if _, err = c.conn.Write(r.body); err != nil {
+				return err
+			}
+			continue
+		}
+		if err = c.w.Compose(r.messageType, r.body); err != nil {
+			return err
+		}
+		if err = c.w.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+// gobsp-stat summarizes the frames of gobsp streams read from files or standard
+// input: the count, body bytes, and size histogram of each message type, the
+// framing overhead, and the widths of the variable width integers in frame
+// headers. Frames are counted as they appear in the stream, so batches and
+// fragments are counted as frames of those types.
+//
+// An archive file (see the archive package) is summarized from its recorded
+// messages, and its timestamps are used to report throughput per interval.
+// Given a schema (see the schema package), gobsp-stat also reports, for each
+// integer field, the bytes its values took, and the bytes they would take as
+// variable width integers, or as the narrowest fixed width integers that hold
+// them.
+//
+// The statistics of every input are combined into a single report.
+//
+// Usage:
+//
+//	gobsp-stat [-schema schema.json] [-interval 1s] [file ...]
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/karrick/gobsp"
+	"github.com/karrick/gobsp/archive"
+	"github.com/karrick/gobsp/cmd/internal/cmdflag"
+	"github.com/karrick/gobsp/stats"
+)
+
+func main() {
+	schemaName := flag.String("schema", "", "schema `file` describing the message types")
+	interval := flag.Duration("interval", stats.DefaultInterval, "report archive throughput per `interval`")
+	flag.Parse()
+
+	configurators := []stats.Config{stats.Interval(*interval)}
+	if *schemaName != "" {
+		s, err := cmdflag.Schema(*schemaName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "gobsp-stat: %s\n", err)
+			os.Exit(2)
+		}
+		configurators = append(configurators, stats.Schema(s))
+	}
+	c, err := stats.NewCollector(configurators...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gobsp-stat: %s\n", err)
+		os.Exit(2)
+	}
+
+	names := flag.Args()
+	if len(names) == 0 {
+		names = []string{"-"}
+	}
+	var failed bool
+	for _, name := range names {
+		if err := collectFile(c, name); err != nil {
+			fmt.Fprintf(os.Stderr, "gobsp-stat: %s: %s\n", name, err)
+			failed = true
+		}
+	}
+	if err := report(os.Stdout, c.Summary()); err != nil {
+		fmt.Fprintf(os.Stderr, "gobsp-stat: %s\n", err)
+		failed = true
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// collectFile collects the statistics of the named file, or standard input when
+// the name is "-".
+func collectFile(c *stats.Collector, name string) error {
+	if name == "-" {
+		_, err := c.ReadFrom(os.Stdin)
+		return err
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.Mode().IsRegular() {
+		r, err := archive.NewReader(f, fi.Size())
+		if err == nil {
+			return collectArchive(c, r)
+		}
+		if _, ok := err.(archive.ErrNotArchive); !ok {
+			return err
+		}
+	}
+	_, err = c.ReadFrom(f)
+	return err
+}
+
+// collectArchive collects the statistics of an archive's messages, with the
+// times they were recorded.
+func collectArchive(c *stats.Collector, r *archive.Reader) error {
+	var body bytes.Buffer
+	scanner, err := r.Scanner(gobsp.Raw(), gobsp.DefaultHandler(func(ior io.Reader) error {
+		body.Reset()
+		_, err := body.ReadFrom(ior)
+		return err
+	}))
+	if err != nil {
+		return err
+	}
+	for n := 0; scanner.Scan(); n++ {
+		if err := scanner.Handle(); err != nil {
+			return err
+		}
+		e, err := r.Entry(n)
+		if err != nil {
+			return err
+		}
+		c.AddAt(e.Time, scanner.MessageType(), body.Bytes())
+	}
+	return scanner.Err()
+}
+
+// typeName returns a message type's number, followed by its name when it is a
+// reserved type.
+func typeName(mt gobsp.MessageType) string {
+	name := mt.String()
+	if name != gobsp.UVWI(mt).String() {
+		return fmt.Sprintf("%d (%s)", uint64(mt), name)
+	}
+	return name
+}
+
+// bucket returns the range of body sizes counted by a histogram bucket.
+func bucket(n int) string {
+	switch n {
+	case 0:
+		return "0"
+	case 1:
+		return "1"
+	}
+	return strconv.FormatUint(1<<(n-1), 10) + "-" + strconv.FormatUint(1<<n-1, 10)
+}
+
+// widths formats a count of integers by width.
+func widths(counts [stats.MaxWidth + 1]int64) string {
+	var fields []string
+	for width, count := range counts {
+		if count > 0 {
+			fields = append(fields, fmt.Sprintf("%dB:%d", width, count))
+		}
+	}
+	return strings.Join(fields, " ")
+}
+
+// report writes a summary as text.
+func report(iow io.Writer, s stats.Summary) error {
+	tw := tabwriter.NewWriter(iow, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "frames %d, header bytes %d, body bytes %d, framing overhead %.1f%%\n",
+		s.Frames, s.HeaderBytes, s.BodyBytes, s.Overhead())
+	fmt.Fprintf(tw, "header widths: type %s; size %s\n", widths(s.TypeWidths), widths(s.SizeWidths))
+
+	// Each table is flushed so its columns are aligned independently.
+
+	if len(s.Types) > 0 {
+		fmt.Fprintf(tw, "\nTYPE\tCOUNT\tBYTES\tMIN\tMAX\tMEAN\t  SIZES\n")
+		for _, t := range s.Types {
+			var sizes []string
+			for n, count := range t.Histogram {
+				if count > 0 {
+					sizes = append(sizes, bucket(n)+":"+strconv.FormatInt(count, 10))
+				}
+			}
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%.1f\t  %s\n", typeName(t.Type), t.Count, t.Bytes, t.Min, t.Max,
+				float64(t.Bytes)/float64(t.Count), strings.Join(sizes, " "))
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if len(s.Fields) > 0 {
+		fmt.Fprintf(tw, "\nFIELD\tTYPE\tCOUNT\tBYTES\tVARIABLE\tFIXED\t\n")
+		for _, f := range s.Fields {
+			fmt.Fprintf(tw, "%s.%s\t%s\t%d\t%d\t%d\t%d (%d-byte)\t\n", f.Message, f.Field, f.FieldType, f.Count, f.Bytes,
+				f.VariableBytes, f.FixedBytes(), f.FixedWidth)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if len(s.Intervals) > 0 {
+		fmt.Fprintf(tw, "\nTIME\tFRAMES\tBYTES\t\n")
+		for _, i := range s.Intervals {
+			fmt.Fprintf(tw, "%s\t%d\t%d\t\n", i.Start.UTC().Format(time.RFC3339Nano), i.Frames, i.Bytes)
+		}
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/karrick/gobsp"
+	"github.com/karrick/gobsp/archive"
+	"github.com/karrick/gobsp/stats"
+)
+
+func testReport(t *testing.T, name string) string {
+	c, err := stats.NewCollector(stats.Interval(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = collectFile(c, name); err != nil {
+		t.Fatal(err)
+	}
+	bb := new(bytes.Buffer)
+	if err = report(bb, c.Summary()); err != nil {
+		t.Fatal(err)
+	}
+	return bb.String()
+}
+
+func TestReportStream(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "stream")
+	stream := []byte{0x01, 0x03, 'a', 'b', 'c', 0x01, 0x00}
+	stream = append(stream, []byte{0x82, 0xFE, 0xFF, 0xFF, 0x0F, 0x00}...) // heartbeat
+	if err := ioutil.WriteFile(name, stream, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	actual := testReport(t, name)
+	expected := `frames 3, header bytes 10, body bytes 3, framing overhead 76.9%
+header widths: type 1B:2 5B:1; size 1B:3
+
+                    TYPE  COUNT  BYTES  MIN  MAX  MEAN  SIZES
+                       1      2      3    0    3   1.5  0:1 2-3:1
+  4294967042 (Heartbeat)      1      0    0    0   0.0  0:1
+`
+	if actual != expected {
+		t.Errorf("Actual:\n%s\nExpected:\n%s", actual, expected)
+	}
+}
+
+func TestReportArchive(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "archive")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := archive.NewWriter(f, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, when := range []time.Duration{0, 30 * time.Second, 90 * time.Second} {
+		if err = w.AppendAt(start.Add(when), gobsp.MessageType(i+1), []byte("x")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	actual := testReport(t, name)
+	expected := `
+                  TIME  FRAMES  BYTES
+  2020-01-01T00:00:00Z       2      6
+  2020-01-01T00:01:00Z       1      3
+`
+	if !strings.HasSuffix(actual, expected) {
+		t.Errorf("Actual:\n%s\nExpected suffix:\n%s", actual, expected)
+	}
+}
@@ -0,0 +1,53 @@
+// Package cmdflag parses the values of the command-line flags shared by the
+// gobsp commands.
+package cmdflag
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/karrick/gobsp"
+	"github.com/karrick/gobsp/schema"
+)
+
+// MessageType parses a message type, in decimal or 0x hexadecimal.
+func MessageType(field string) (gobsp.MessageType, error) {
+	mt, err := strconv.ParseUint(strings.TrimSpace(field), 0, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid message type: %q", field)
+	}
+	return gobsp.MessageType(mt), nil
+}
+
+// MessageTypes parses a comma separated list of message types. It returns nil
+// for an empty list.
+func MessageTypes(list string) ([]gobsp.MessageType, error) {
+	if list == "" {
+		return nil, nil
+	}
+	var types []gobsp.MessageType
+	for _, field := range strings.Split(list, ",") {
+		mt, err := MessageType(field)
+		if err != nil {
+			return nil, err
+		}
+		types = append(types, mt)
+	}
+	return types, nil
+}
+
+// Schema loads the named schema file. When the name is empty, it returns a
+// schema describing only the reserved control messages.
+func Schema(name string) (*schema.Schema, error) {
+	if name == "" {
+		return schema.New(nil)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return schema.Load(f)
+}
@@ -0,0 +1,40 @@
+package cmdflag
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/karrick/gobsp"
+)
+
+func ensure(t *testing.T, actual, expected interface{}) {
+	t.Helper()
+	if actual != expected {
+		t.Errorf("Actual: %#v; Expected: %#v", actual, expected)
+	}
+}
+
+func TestMessageTypes(t *testing.T) {
+	types, err := MessageTypes("2, 0xFFFFFF02")
+	ensure(t, err, nil)
+	ensure(t, fmt.Sprint(types), "[2 Heartbeat]")
+
+	types, err = MessageTypes("")
+	ensure(t, err, nil)
+	ensure(t, types == nil, true)
+
+	_, err = MessageTypes("1,x")
+	ensure(t, err.Error(), `invalid message type: "x"`)
+}
+
+func TestSchema(t *testing.T) {
+	s, err := Schema("")
+	ensure(t, err, nil)
+	mt, err := s.TypeOf("Ping")
+	ensure(t, err, nil)
+	ensure(t, mt, gobsp.MTPing)
+
+	_, err = Schema("testdata/missing.json")
+	ensure(t, os.IsNotExist(err), true)
+}
@@ -0,0 +1,339 @@
+package gobsp
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"sync"
+	"time"
+)
+
+// Composer defines an object used to write binary messages to a particular
+// io.Writer. It is safe for concurrent use by multiple goroutines: each
+// message's header and body are written together, and never interleaved with
+// another message.
+type Composer struct {
+	mu        sync.Mutex // guards bw, lastWrite, and err
+	bw        *bufio.Writer
+	lastWrite time.Time
+	err       error // first write error from the queue writer
+
+	// amu guards allowed apart from mu, so that checking a message type need
+	// not wait for a write in progress.
+	amu     sync.RWMutex
+	allowed map[MessageType]struct{} // nil until Negotiate
+
+	heartbeatInterval time.Duration
+	stop, stopped     chan struct{} // nil without heartbeats
+
+	queueSize int
+	qmu       sync.RWMutex         // guards closing queue while requests are sent on it
+	queue     chan composerRequest // nil without WriteQueue
+	queueDone chan struct{}
+
+	flushMessages   int
+	flushBytes      int
+	flushLatency    time.Duration
+	pendingMessages int         // messages written since the last flush
+	flushTimer      *time.Timer // nil unless a latency flush is pending
+
+	maxFrameSize   int
+	nextFragmentID UVWI // guarded by mu
+}
+
+// composerRequest is a frame to be written by the queue writer, or a request
+// for it to flush, or both.
+type composerRequest struct {
+	frame   []byte
+	flushed chan error // nil unless the sender waits for a flush
+}
+
+// ComposerConfig is a function that modifies a newly created Composer instance.
+type ComposerConfig func(*Composer) error
+
+// WriteQueue causes a Composer to hand each message to a background goroutine
+// through a queue holding up to the specified number of messages, rather than
+// writing it in the calling goroutine. The background goroutine writes queued
+// messages one after the other and flushes only when the queue is empty, so
+// many small messages composed by several goroutines are coalesced into fewer
+// writes to the underlying io.Writer. A write error is returned by a later
+// call to Compose, Flush, or Close.
+func WriteQueue(size int) ComposerConfig {
+	return func(w *Composer) error {
+		w.queueSize = size
+		return nil
+	}
+}
+
+// FlushEvery causes a Composer to flush after every specified number of
+// messages. FlushEvery(1) flushes each message as soon as it is composed,
+// which suits interactive peers.
+func FlushEvery(messages int) ComposerConfig {
+	return func(w *Composer) error {
+		w.flushMessages = messages
+		return nil
+	}
+}
+
+// FlushBytes causes a Composer to flush as soon as at least the specified
+// number of bytes are buffered. The Composer always flushes when its buffer
+// is full, so sizes larger than the buffer have no effect.
+func FlushBytes(size int) ComposerConfig {
+	return func(w *Composer) error {
+		w.flushBytes = size
+		return nil
+	}
+}
+
+// FlushLatency causes a Composer to flush no later than the specified duration
+// after a message is composed, bounding how long a message may wait in the
+// buffer for more messages to join it. It has no effect with WriteQueue, which
+// flushes whenever its queue is empty.
+func FlushLatency(latency time.Duration) ComposerConfig {
+	return func(w *Composer) error {
+		w.flushLatency = latency
+		return nil
+	}
+}
+
+// NewComposer returns a new Composer instance to write messages to the
+// specified io.Writer stream.
+func NewComposer(iow io.Writer) *Composer {
+	w, _ := NewComposerWithOptions(iow) // only configuration functions fail
+	return w
+}
+
+// NewComposerWithOptions returns a new Composer instance to write messages to
+// the specified io.Writer stream, modified by the specified configuration
+// functions.
+func NewComposerWithOptions(iow io.Writer, configurators ...ComposerConfig) (*Composer, error) {
+	w := &Composer{bw: bufio.NewWriter(iow), lastWrite: time.Now()}
+	for _, c := range configurators {
+		if err := c(w); err != nil {
+			return nil, err
+		}
+	}
+	if w.queueSize > 0 {
+		w.queue = make(chan composerRequest, w.queueSize)
+		w.queueDone = make(chan struct{})
+		go w.writeQueue(w.queue, w.queueDone)
+	}
+	if w.heartbeatInterval > 0 {
+		w.stop = make(chan struct{})
+		w.stopped = make(chan struct{})
+		go w.heartbeat()
+	}
+	return w, nil
+}
+
+// Compose writes a single message having the specified type and body. The
+// message may remain buffered until the Composer is flushed.
+func (w *Composer) Compose(messageType MessageType, messageBody []byte) error {
+	if err := w.permitted(messageType); err != nil {
+		return err
+	}
+	return w.write(messageType, messageBody, false)
+}
+
+// permitted returns an error when Negotiate agreed the peer does not support
+// the specified message type.
+func (w *Composer) permitted(messageType MessageType) error {
+	if messageType >= MinReservedMessageType {
+		return nil
+	}
+	w.amu.RLock()
+	defer w.amu.RUnlock()
+	if w.allowed != nil {
+		if _, ok := w.allowed[messageType]; !ok {
+			return ErrPeerUnsupportedMessageType(messageType)
+		}
+	}
+	return nil
+}
+
+// write writes a single message, and optionally flushes it, either directly or
+// through the queue.
+func (w *Composer) write(messageType MessageType, messageBody []byte, flush bool) error {
+	if w.maxFrameSize > 0 && len(messageBody) > w.maxFrameSize {
+		return w.writeFragments(messageType, messageBody, flush)
+	}
+	if w.queue != nil {
+		return w.enqueue(frame(messageType, messageBody), flush)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.compose(messageType, messageBody); err != nil {
+		return err
+	}
+	return w.flushPolicy(flush)
+}
+
+// flushPolicy flushes bw when flush is true or the flush policy requires it,
+// and otherwise arranges a latency flush if one is configured. The caller must
+// hold w.mu.
+func (w *Composer) flushPolicy(flush bool) error {
+	if flush || w.wrote() {
+		return w.flush()
+	}
+	if w.flushLatency > 0 && w.flushTimer == nil {
+		w.flushTimer = time.AfterFunc(w.flushLatency, func() {
+			_ = w.Flush() // a failed write is reported by the next Compose
+		})
+	}
+	return nil
+}
+
+// wrote records that a message has been written to bw, and returns true when
+// the flush policy requires a flush. The caller must hold w.mu, or be the queue
+// writer.
+func (w *Composer) wrote() bool {
+	w.pendingMessages++
+	return (w.flushMessages > 0 && w.pendingMessages >= w.flushMessages) ||
+		(w.flushBytes > 0 && w.bw.Buffered() >= w.flushBytes)
+}
+
+// flush flushes bw and resets the flush policy state. The caller must hold
+// w.mu, or be the queue writer.
+func (w *Composer) flush() error {
+	w.pendingMessages = 0
+	if w.flushTimer != nil {
+		w.flushTimer.Stop()
+		w.flushTimer = nil
+	}
+	return w.bw.Flush()
+}
+
+// frame returns the encoded header and body of a single message.
+func frame(messageType MessageType, messageBody []byte) []byte {
+	bb := bytes.NewBuffer(make([]byte, 0, len(messageBody)+10))
+	_ = UVWI(messageType).MarshalBinaryTo(bb)
+	_ = UVWI(len(messageBody)).MarshalBinaryTo(bb)
+	bb.Write(messageBody)
+	return bb.Bytes()
+}
+
+// compose writes a single message. The caller must hold w.mu.
+func (w *Composer) compose(messageType MessageType, messageBody []byte) error {
+	w.lastWrite = time.Now()
+	if err := UVWI(messageType).MarshalBinaryTo(w.bw); err != nil {
+		return err
+	}
+	if err := UVWI(len(messageBody)).MarshalBinaryTo(w.bw); err != nil {
+		return err
+	}
+	_, err := w.bw.Write(messageBody)
+	return err
+}
+
+// enqueue hands a frame, which may be nil, to the queue writer. When flush is
+// true, it waits for the queue writer to write and flush everything queued
+// before it.
+func (w *Composer) enqueue(frame []byte, flush bool) error {
+	w.mu.Lock()
+	err := w.err
+	if frame != nil {
+		w.lastWrite = time.Now()
+	}
+	w.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	req := composerRequest{frame: frame}
+	if flush {
+		req.flushed = make(chan error, 1)
+	}
+	w.qmu.RLock()
+	if w.queueDone == nil {
+		w.qmu.RUnlock()
+		return ErrComposerClosed{}
+	}
+	w.queue <- req
+	w.qmu.RUnlock()
+
+	if flush {
+		return <-req.flushed
+	}
+	return nil
+}
+
+// writeQueue writes frames from the queue until it is closed, flushing
+// whenever the queue is empty.
+func (w *Composer) writeQueue(queue <-chan composerRequest, done chan<- struct{}) {
+	defer close(done)
+	var err error
+	for req := range queue {
+		// Only this goroutine writes to bw once the queue is running.
+		var policy bool
+		if req.frame != nil && err == nil {
+			_, err = w.bw.Write(req.frame)
+			policy = w.wrote()
+		}
+		if (policy || req.flushed != nil || len(queue) == 0) && err == nil {
+			err = w.flush()
+		}
+		if err != nil {
+			w.setErr(err)
+		}
+		if req.flushed != nil {
+			req.flushed <- err
+		}
+	}
+	if err == nil {
+		w.setErr(w.flush())
+	}
+}
+
+// setErr records the first write error from the queue writer.
+func (w *Composer) setErr(err error) {
+	w.mu.Lock()
+	if w.err == nil {
+		w.err = err
+	}
+	w.mu.Unlock()
+}
+
+// ErrComposerClosed is an error that is returned when a message is composed
+// after a Composer with a WriteQueue has been closed.
+type ErrComposerClosed struct{}
+
+func (e ErrComposerClosed) Error() string {
+	return "composer closed"
+}
+
+// Flush writes any buffered data to the underlying io.Writer.
+func (w *Composer) Flush() error {
+	if w.queue != nil {
+		return w.enqueue(nil, true)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.flush()
+}
+
+// Close stops any background goroutines started for the Composer, and flushes
+// any buffered data to the underlying io.Writer. It does not close the
+// io.Writer.
+func (w *Composer) Close() error {
+	if w.stop != nil {
+		close(w.stop)
+		<-w.stopped
+		w.stop = nil
+	}
+	if w.queue != nil {
+		w.qmu.Lock()
+		done := w.queueDone
+		if done != nil {
+			close(w.queue)
+			w.queueDone = nil
+		}
+		w.qmu.Unlock()
+		if done != nil {
+			<-done
+		}
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		return w.err
+	}
+	return w.Flush()
+}
@@ -0,0 +1,165 @@
+package gobsp
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingWriter counts the number of writes made to it.
+type countingWriter struct {
+	mu     sync.Mutex
+	bb     bytes.Buffer
+	writes int
+}
+
+func (cw *countingWriter) Write(buf []byte) (int, error) {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	cw.writes++
+	return cw.bb.Write(buf)
+}
+
+// testConcurrentCompose composes messages from many goroutines at once, then
+// verifies every message can be scanned intact from the stream.
+func testConcurrentCompose(t *testing.T, configurators ...ComposerConfig) *countingWriter {
+	const goroutines, messages = 8, 200
+
+	cw := new(countingWriter)
+	w, err := NewComposerWithOptions(cw, configurators...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			body := bytes.Repeat([]byte{byte(g)}, 100+g)
+			for i := 0; i < messages; i++ {
+				if err := w.Compose(MessageType(g), body); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	counts := make(map[uint32]int)
+	handlers := make(map[uint32]MessageHandler)
+	for g := 0; g < goroutines; g++ {
+		g := uint32(g)
+		handlers[g] = func(ior io.Reader) error {
+			buf, err := io.ReadAll(ior)
+			if err != nil {
+				return err
+			}
+			if actual, expected := buf, bytes.Repeat([]byte{byte(g)}, 100+int(g)); !bytes.Equal(actual, expected) {
+				t.Errorf("Actual: %#v; Expected: %#v", actual, expected)
+			}
+			counts[g]++
+			return nil
+		}
+	}
+	scanner, err := NewScanner(bytes.NewReader(cw.bb.Bytes()), Handlers(handlers))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for scanner.Scan() {
+		if err := scanner.Handle(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ensure(t, scanner.Err(), nil)
+	for g := uint32(0); g < goroutines; g++ {
+		ensure(t, counts[g], messages)
+	}
+	return cw
+}
+
+func TestComposerConcurrent(t *testing.T) {
+	testConcurrentCompose(t)
+}
+
+func TestComposerWriteQueueConcurrent(t *testing.T) {
+	cw := testConcurrentCompose(t, WriteQueue(64))
+	if cw.writes >= 8*200 {
+		t.Errorf("Actual: %d writes; Expected: fewer writes than messages", cw.writes)
+	}
+}
+
+func TestComposerWriteQueueFlush(t *testing.T) {
+	cw := new(countingWriter)
+	w, err := NewComposerWithOptions(cw, WriteQueue(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ensure(t, w.Compose(1, []byte("a")), nil)
+	ensure(t, w.Flush(), nil)
+
+	cw.mu.Lock()
+	ensure(t, cw.bb.String(), "\x01\x01a")
+	cw.mu.Unlock()
+
+	ensure(t, w.Close(), nil)
+	ensure(t, w.Compose(1, nil), error(ErrComposerClosed{}))
+}
+
+// written returns the bytes written so far.
+func (cw *countingWriter) written() string {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	return cw.bb.String()
+}
+
+func TestComposerFlushEvery(t *testing.T) {
+	cw := new(countingWriter)
+	w, err := NewComposerWithOptions(cw, FlushEvery(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ensure(t, w.Compose(1, []byte("a")), nil)
+	ensure(t, cw.written(), "")
+	ensure(t, w.Compose(2, []byte("b")), nil)
+	ensure(t, cw.written(), "\x01\x01a\x02\x01b")
+	ensure(t, w.Compose(3, []byte("c")), nil)
+	ensure(t, cw.written(), "\x01\x01a\x02\x01b")
+	ensure(t, w.Flush(), nil)
+	ensure(t, cw.written(), "\x01\x01a\x02\x01b\x03\x01c")
+}
+
+func TestComposerFlushBytes(t *testing.T) {
+	cw := new(countingWriter)
+	w, err := NewComposerWithOptions(cw, FlushBytes(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ensure(t, w.Compose(1, []byte("abc")), nil)
+	ensure(t, cw.written(), "")
+	ensure(t, w.Compose(1, []byte("def")), nil)
+	ensure(t, cw.written(), "\x01\x03abc\x01\x03def")
+}
+
+func TestComposerFlushLatency(t *testing.T) {
+	cw := new(countingWriter)
+	w, err := NewComposerWithOptions(cw, FlushLatency(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ensure(t, w.Compose(1, []byte("a")), nil)
+	ensure(t, cw.written(), "")
+
+	deadline := time.Now().Add(time.Second)
+	for cw.written() == "" && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	ensure(t, cw.written(), "\x01\x01a")
+	ensure(t, w.Close(), nil)
+}
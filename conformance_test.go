@@ -0,0 +1,344 @@
+package gobsp
+
+//go:generate go run gen_conformance.go
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// The conformance corpus in testdata/conformance.json holds the encodings other
+// gobsp implementations must agree on; its description explains the format.
+
+type conformanceCorpus struct {
+	Primitives []struct {
+		Type       string          `json:"type"`
+		Value      json.RawMessage `json:"value"`
+		Bytes      string          `json:"bytes"`
+		DecodeOnly bool            `json:"decode_only"`
+		Error      string          `json:"error"`
+	} `json:"primitives"`
+	Streams []struct {
+		Name     string `json:"name"`
+		Preamble *struct {
+			Version     uint8  `json:"version"`
+			Features    string `json:"features"`
+			Application string `json:"application"`
+		} `json:"preamble"`
+		AcceptFeatures string               `json:"accept_features"`
+		Bytes          string               `json:"bytes"`
+		Messages       []conformanceMessage `json:"messages"`
+		Compose        bool                 `json:"compose"`
+		Error          string               `json:"error"`
+	} `json:"streams"`
+}
+
+type conformanceMessage struct {
+	Type string `json:"type"`
+	Body string `json:"body"`
+}
+
+func loadConformance(t testing.TB) conformanceCorpus {
+	t.Helper()
+	buf, err := ioutil.ReadFile("testdata/conformance.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var c conformanceCorpus
+	if err = json.Unmarshal(buf, &c); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// conformanceError returns the name the corpus uses for an error.
+func conformanceError(err error) string {
+	switch err.(type) {
+	case nil:
+		return ""
+	case ErrNotGobspStream:
+		return "not-gobsp"
+	case ErrUnsupportedVersion:
+		return "unsupported-version"
+	case ErrUnsupportedFeatures:
+		return "unsupported-features"
+	case ErrVWIOverflow:
+		return "overflow"
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return "truncated"
+	}
+	return err.Error()
+}
+
+// newPrimitive returns a new zero value of the named primitive.
+func newPrimitive(typ string) (Binary, error) {
+	switch typ {
+	case "Int8":
+		return new(Int8), nil
+	case "Uint8":
+		return new(Uint8), nil
+	case "Int16":
+		return new(Int16), nil
+	case "Uint16":
+		return new(Uint16), nil
+	case "Int32":
+		return new(Int32), nil
+	case "Uint32":
+		return new(Uint32), nil
+	case "Int64":
+		return new(Int64), nil
+	case "Uint64":
+		return new(Uint64), nil
+	case "VWI":
+		return new(VWI), nil
+	case "UVWI":
+		return new(UVWI), nil
+	case "Float32":
+		return new(Float32), nil
+	case "Float64":
+		return new(Float64), nil
+	case "String":
+		return new(String), nil
+	case "StringSlice":
+		return new(StringSlice), nil
+	}
+	return nil, fmt.Errorf("unknown primitive type: %q", typ)
+}
+
+// conformanceValue returns the named primitive holding the value from the
+// corpus.
+func conformanceValue(typ string, value json.RawMessage) (Binary, error) {
+	v, err := newPrimitive(typ)
+	if err != nil {
+		return nil, err
+	}
+	switch p := v.(type) {
+	case *String:
+		return v, json.Unmarshal(value, p)
+	case *StringSlice:
+		var ss []string
+		if err = json.Unmarshal(value, &ss); err != nil {
+			return nil, err
+		}
+		*p = make(StringSlice, len(ss))
+		for i, s := range ss {
+			(*p)[i] = String(s)
+		}
+		return v, nil
+	}
+
+	var s string
+	if err = json.Unmarshal(value, &s); err != nil {
+		return nil, err
+	}
+	var i int64
+	var u uint64
+	var f float64
+	switch p := v.(type) {
+	case *Int8:
+		i, err = strconv.ParseInt(s, 10, 8)
+		*p = Int8(i)
+	case *Int16:
+		i, err = strconv.ParseInt(s, 10, 16)
+		*p = Int16(i)
+	case *Int32:
+		i, err = strconv.ParseInt(s, 10, 32)
+		*p = Int32(i)
+	case *Int64:
+		i, err = strconv.ParseInt(s, 10, 64)
+		*p = Int64(i)
+	case *VWI:
+		i, err = strconv.ParseInt(s, 10, 64)
+		*p = VWI(i)
+	case *Uint8:
+		u, err = strconv.ParseUint(s, 10, 8)
+		*p = Uint8(u)
+	case *Uint16:
+		u, err = strconv.ParseUint(s, 10, 16)
+		*p = Uint16(u)
+	case *Uint32:
+		u, err = strconv.ParseUint(s, 10, 32)
+		*p = Uint32(u)
+	case *Uint64:
+		u, err = strconv.ParseUint(s, 10, 64)
+		*p = Uint64(u)
+	case *UVWI:
+		u, err = strconv.ParseUint(s, 10, 64)
+		*p = UVWI(u)
+	case *Float32:
+		f, err = strconv.ParseFloat(s, 32)
+		*p = Float32(f)
+	case *Float64:
+		f, err = strconv.ParseFloat(s, 64)
+		*p = Float64(f)
+	}
+	return v, err
+}
+
+// samePrimitive returns true when two primitives hold the same value, treating
+// NaN as equal to itself.
+func samePrimitive(a, b Binary) bool {
+	switch a := a.(type) {
+	case *Float32:
+		if math.IsNaN(float64(*a)) {
+			return math.IsNaN(float64(*b.(*Float32)))
+		}
+	case *Float64:
+		if math.IsNaN(float64(*a)) {
+			return math.IsNaN(float64(*b.(*Float64)))
+		}
+	case *StringSlice:
+		// An empty slice decodes as a non-nil empty slice.
+		return len(*a) == len(*b.(*StringSlice)) && (len(*a) == 0 || reflect.DeepEqual(a, b))
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func TestConformancePrimitives(t *testing.T) {
+	for _, c := range loadConformance(t).Primitives {
+		name := c.Type + " " + c.Bytes
+		buf, err := hex.DecodeString(c.Bytes)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+
+		decoded, err := newPrimitive(c.Type)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		br := bytes.NewReader(buf)
+		err = decoded.UnmarshalBinaryFrom(br)
+		if actual, expected := conformanceError(err), c.Error; actual != expected {
+			t.Errorf("%s: decode error: Actual: %q; Expected: %q", name, actual, expected)
+			continue
+		}
+		if c.Error != "" {
+			continue
+		}
+		if br.Len() != 0 {
+			t.Errorf("%s: decode left %d bytes", name, br.Len())
+		}
+
+		expected, err := conformanceValue(c.Type, c.Value)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if !samePrimitive(expected, decoded) {
+			t.Errorf("%s: decode: Actual: %v; Expected: %v", name, decoded, expected)
+		}
+		if c.DecodeOnly {
+			continue
+		}
+		bb := new(bytes.Buffer)
+		if err = expected.MarshalBinaryTo(bb); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if actual := hex.EncodeToString(bb.Bytes()); actual != c.Bytes {
+			t.Errorf("%s: encode: Actual: %s; Expected: %s", name, actual, c.Bytes)
+		}
+	}
+}
+
+func TestConformanceStreams(t *testing.T) {
+	for _, c := range loadConformance(t).Streams {
+		buf, err := hex.DecodeString(c.Bytes)
+		if err != nil {
+			t.Fatalf("%s: %s", c.Name, err)
+		}
+		var features, accept uint64
+		if c.Preamble != nil {
+			if features, err = strconv.ParseUint(c.Preamble.Features, 10, 64); err != nil {
+				t.Fatalf("%s: %s", c.Name, err)
+			}
+			accept = features
+			if c.AcceptFeatures != "" {
+				if accept, err = strconv.ParseUint(c.AcceptFeatures, 10, 64); err != nil {
+					t.Fatalf("%s: %s", c.Name, err)
+				}
+			}
+		}
+
+		// Read the stream, as an application would.
+		var messages []conformanceMessage
+		var scanner *Scanner
+		configurators := []ScannerConfig{DefaultHandler(func(ior io.Reader) error {
+			body, err := ioutil.ReadAll(ior)
+			if err != nil {
+				return err
+			}
+			if uint64(len(body)) < scanner.MessageSize() {
+				return io.ErrUnexpectedEOF
+			}
+			messages = append(messages, conformanceMessage{
+				Type: strconv.FormatUint(uint64(scanner.MessageType()), 10),
+				Body: hex.EncodeToString(body),
+			})
+			return nil
+		})}
+		if c.Preamble != nil {
+			configurators = append(configurators, ExpectPreamble(c.Preamble.Application, Feature(accept)))
+		}
+		if scanner, err = NewScanner(bytes.NewReader(buf), configurators...); err != nil {
+			t.Fatalf("%s: %s", c.Name, err)
+		}
+		for err == nil && scanner.Scan() {
+			err = scanner.Handle()
+		}
+		if err == nil {
+			err = scanner.Err()
+		}
+		if actual, expected := conformanceError(err), c.Error; actual != expected {
+			t.Errorf("%s: error: Actual: %q; Expected: %q", c.Name, actual, expected)
+		}
+		if actual, expected := fmt.Sprint(messages), fmt.Sprint(c.Messages); actual != expected {
+			t.Errorf("%s: messages: Actual: %s; Expected: %s", c.Name, actual, expected)
+		}
+		if c.Preamble != nil && c.Error == "" && len(buf) > 0 {
+			p := scanner.Preamble()
+			if p.Version != Uint8(c.Preamble.Version) || p.Features != Feature(features) || string(p.Application) != c.Preamble.Application {
+				t.Errorf("%s: preamble: Actual: %#v; Expected: %#v", c.Name, p, *c.Preamble)
+			}
+		}
+
+		if !c.Compose {
+			continue
+		}
+		bb := new(bytes.Buffer)
+		var composerConfigurators []ComposerConfig
+		if c.Preamble != nil {
+			composerConfigurators = append(composerConfigurators, WritePreamble(c.Preamble.Application, Feature(features)))
+		}
+		w, err := NewComposerWithOptions(bb, composerConfigurators...)
+		if err != nil {
+			t.Fatalf("%s: %s", c.Name, err)
+		}
+		for _, m := range c.Messages {
+			mt, err := strconv.ParseUint(m.Type, 10, 64)
+			if err != nil {
+				t.Fatalf("%s: %s", c.Name, err)
+			}
+			body, err := hex.DecodeString(m.Body)
+			if err != nil {
+				t.Fatalf("%s: %s", c.Name, err)
+			}
+			if err = w.Compose(MessageType(mt), body); err != nil {
+				t.Fatalf("%s: %s", c.Name, err)
+			}
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("%s: %s", c.Name, err)
+		}
+		if actual := hex.EncodeToString(bb.Bytes()); actual != c.Bytes {
+			t.Errorf("%s: compose: Actual: %s; Expected: %s", c.Name, actual, c.Bytes)
+		}
+	}
+}
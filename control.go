@@ -0,0 +1,252 @@
+package gobsp
+
+import (
+	"bytes"
+	"io"
+)
+
+// MinReservedMessageType is the first of the message types gobsp reserves for
+// control messages. Applications must not define message types at or above
+// this value. Within the reserved range, the message types below
+// MinReservedMessageType+0x80 are control messages defined by this package,
+// and the remainder are allocated to its subpackages: rpc uses offsets 0x80
+// through 0x9F, and mux uses offsets 0xA0 through 0xBF.
+const MinReservedMessageType MessageType = 0xFFFFFF00
+
+// Reserved control message types. Unless the program registers its own handler
+// for one of them, Scanner.Handle processes control messages itself, as
+// described for each type.
+const (
+	// MTHello carries a HelloMessage, introducing the sender. Scanner discards
+	// it.
+	MTHello = MinReservedMessageType + iota
+
+	// MTCapabilities carries the Capabilities exchanged by Negotiate.
+	MTCapabilities
+
+	// MTHeartbeat carries no payload, and is sent only to show the peer that
+	// the connection remains alive. Scanner.Scan consumes heartbeats without
+	// returning them.
+	MTHeartbeat
+
+	// MTError carries an ErrorMessage. Scanner.Handle returns the decoded
+	// ErrorMessage as its error, without ending the scan.
+	MTError
+
+	// MTClose carries a CloseMessage, saying the sender will send nothing
+	// more. Scanner.Handle records it, and the following Scanner.Scan returns
+	// false without error.
+	MTClose
+
+	// MTPing carries a PingMessage. Scanner replies with a PongMessage having
+	// the same token when configured with ReplyTo, and otherwise discards it.
+	MTPing
+
+	// MTPong carries a PongMessage answering a PingMessage. Scanner discards
+	// it.
+	MTPong
+
+	// MTBatch carries several messages, each encoded as its UVWI type, UVWI
+	// size, and body, one after the other. Scanner.Scan unpacks batches,
+	// returning each message in turn.
+	MTBatch
+
+	// MTCompressedBatch is like MTBatch, except its payload is compressed
+	// using DEFLATE, as described in RFC 1951.
+	MTCompressedBatch
+
+	// MTFragment carries one piece of a message too large for a single frame,
+	// as described for MaxFrameSize. Scanner.Scan reassembles fragments,
+	// returning the original message.
+	MTFragment
+)
+
+// controlNames are the names of the reserved control message types.
+var controlNames = map[MessageType]string{
+	MTHello:           "Hello",
+	MTCapabilities:    "Capabilities",
+	MTHeartbeat:       "Heartbeat",
+	MTError:           "Error",
+	MTClose:           "Close",
+	MTPing:            "Ping",
+	MTPong:            "Pong",
+	MTBatch:           "Batch",
+	MTCompressedBatch: "CompressedBatch",
+	MTFragment:        "Fragment",
+}
+
+// String returns the name of a reserved control message type, and otherwise
+// the message type as a decimal number.
+func (mt MessageType) String() string {
+	if name, ok := controlNames[mt]; ok {
+		return name
+	}
+	return UVWI(mt).String()
+}
+
+// Error codes for ErrorMessage. Applications may define their own codes at or
+// above ErrorCodeApplication.
+const (
+	ErrorCodeUnknown UVWI = iota
+	ErrorCodeProtocolViolation
+	ErrorCodeUnsupportedMessageType
+	ErrorCodeInternal
+	ErrorCodeApplication UVWI = 1024
+)
+
+// HelloMessage introduces the sender, naming the program and its version.
+type HelloMessage struct {
+	Name, Version String
+}
+
+func (m HelloMessage) MarshalBinaryTo(iow io.Writer) error {
+	if err := m.Name.MarshalBinaryTo(iow); err != nil {
+		return err
+	}
+	return m.Version.MarshalBinaryTo(iow)
+}
+
+func (m *HelloMessage) UnmarshalBinaryFrom(ior io.Reader) error {
+	if err := m.Name.UnmarshalBinaryFrom(ior); err != nil {
+		return err
+	}
+	return m.Version.UnmarshalBinaryFrom(ior)
+}
+
+// ErrorMessage reports an error to the peer, such as a protocol violation or
+// a message type the sender cannot handle. It is also an error, so that it may
+// be returned by Scanner.Handle.
+type ErrorMessage struct {
+	Code UVWI
+	Text String
+}
+
+func (m ErrorMessage) MarshalBinaryTo(iow io.Writer) error {
+	if err := m.Code.MarshalBinaryTo(iow); err != nil {
+		return err
+	}
+	return m.Text.MarshalBinaryTo(iow)
+}
+
+func (m *ErrorMessage) UnmarshalBinaryFrom(ior io.Reader) error {
+	if err := m.Code.UnmarshalBinaryFrom(ior); err != nil {
+		return err
+	}
+	return m.Text.UnmarshalBinaryFrom(ior)
+}
+
+func (m ErrorMessage) Error() string {
+	return "peer error " + m.Code.String() + ": " + string(m.Text)
+}
+
+// CloseMessage says the sender will send nothing more, and why.
+type CloseMessage struct {
+	Reason String
+}
+
+func (m CloseMessage) MarshalBinaryTo(iow io.Writer) error {
+	return m.Reason.MarshalBinaryTo(iow)
+}
+
+func (m *CloseMessage) UnmarshalBinaryFrom(ior io.Reader) error {
+	return m.Reason.UnmarshalBinaryFrom(ior)
+}
+
+// PingMessage asks the peer to reply with a PongMessage having the same token.
+type PingMessage struct {
+	Token Uint64
+}
+
+func (m PingMessage) MarshalBinaryTo(iow io.Writer) error {
+	return m.Token.MarshalBinaryTo(iow)
+}
+
+func (m *PingMessage) UnmarshalBinaryFrom(ior io.Reader) error {
+	return m.Token.UnmarshalBinaryFrom(ior)
+}
+
+// PongMessage answers a PingMessage.
+type PongMessage struct {
+	Token Uint64
+}
+
+func (m PongMessage) MarshalBinaryTo(iow io.Writer) error {
+	return m.Token.MarshalBinaryTo(iow)
+}
+
+func (m *PongMessage) UnmarshalBinaryFrom(ior io.Reader) error {
+	return m.Token.UnmarshalBinaryFrom(ior)
+}
+
+// ReplyTo specifies the Composer a Scanner uses to reply to control messages
+// that require a reply, such as MTPing.
+func ReplyTo(w *Composer) ScannerConfig {
+	return func(s *Scanner) error {
+		s.replyTo = w
+		return nil
+	}
+}
+
+// PassControl causes a Scanner's Handle to dispatch reserved control messages
+// that have no handler of their own to the default handler, rather than
+// processing them itself. Scan still consumes heartbeats, unpacks batches, and
+// reassembles fragmented messages. It suits tools that relay the messages of a
+// stream, whatever their types.
+func PassControl() ScannerConfig {
+	return func(s *Scanner) error {
+		s.passControl = true
+		return nil
+	}
+}
+
+// ComposeBinary writes a single message whose payload is the encoded form of
+// the specified value.
+func (w *Composer) ComposeBinary(messageType MessageType, v Binary) error {
+	bb := new(bytes.Buffer)
+	if err := v.MarshalBinaryTo(bb); err != nil {
+		return err
+	}
+	return w.Compose(messageType, bb.Bytes())
+}
+
+// handleControl processes a reserved control message that has no registered
+// handler. It returns false when the message type is not a control message
+// Scanner processes itself.
+func (s *Scanner) handleControl(ior io.Reader) (bool, error) {
+	switch MessageType(s.messageType) {
+	case MTError:
+		var m ErrorMessage
+		if err := m.UnmarshalBinaryFrom(ior); err != nil {
+			return true, err
+		}
+		return true, m
+	case MTClose:
+		var m CloseMessage
+		if err := m.UnmarshalBinaryFrom(ior); err != nil {
+			return true, err
+		}
+		s.closed = &m
+		return true, nil
+	case MTPing:
+		var m PingMessage
+		if err := m.UnmarshalBinaryFrom(ior); err != nil {
+			return true, err
+		}
+		if s.replyTo == nil {
+			return true, nil
+		}
+		if err := s.replyTo.ComposeBinary(MTPong, &PongMessage{Token: m.Token}); err != nil {
+			return true, err
+		}
+		return true, s.replyTo.Flush()
+	case MTHello, MTPong:
+		return true, DiscardAll(ior)
+	}
+	return false, nil
+}
+
+// Closed returns the CloseMessage received from the peer, or nil when none has
+// been received.
+func (s *Scanner) Closed() *CloseMessage {
+	return s.closed
+}
@@ -0,0 +1,161 @@
+package gobsp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+)
+
+func TestControlMessageTypes(t *testing.T) {
+	ensure(t, MTHello, MessageType(0xFFFFFF00))
+	ensure(t, MTCapabilities, MessageType(0xFFFFFF01))
+	ensure(t, MTHeartbeat, MessageType(0xFFFFFF02))
+	ensure(t, MTError, MessageType(0xFFFFFF03))
+	ensure(t, MTClose, MessageType(0xFFFFFF04))
+	ensure(t, MTPing, MessageType(0xFFFFFF05))
+	ensure(t, MTPong, MessageType(0xFFFFFF06))
+	ensure(t, MTBatch, MessageType(0xFFFFFF07))
+	ensure(t, MTCompressedBatch, MessageType(0xFFFFFF08))
+	ensure(t, MTFragment, MessageType(0xFFFFFF09))
+}
+
+func TestErrorMessageRoundTrip(t *testing.T) {
+	bb := new(bytes.Buffer)
+	vin := ErrorMessage{Code: ErrorCodeProtocolViolation, Text: "bad"}
+	if err := vin.MarshalBinaryTo(bb); err != nil {
+		t.Fatal(err)
+	}
+	if actual, expected := bb.Bytes(), []byte("\x01\x03bad"); !bytes.Equal(actual, expected) {
+		t.Errorf("Actual: %#v; Expected: %#v", actual, expected)
+	}
+	var vout ErrorMessage
+	if err := vout.UnmarshalBinaryFrom(bb); err != nil {
+		t.Fatal(err)
+	}
+	ensure(t, vout, vin)
+}
+
+// testControlStream returns a stream holding the specified messages.
+func testControlStream(t *testing.T, messages ...func(*Composer) error) *bytes.Buffer {
+	bb := new(bytes.Buffer)
+	w := NewComposer(bb)
+	for _, m := range messages {
+		if err := m(w); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return bb
+}
+
+func TestScannerHandlesError(t *testing.T) {
+	bb := testControlStream(t,
+		func(w *Composer) error {
+			return w.ComposeBinary(MTError, &ErrorMessage{Code: ErrorCodeInternal, Text: "oops"})
+		},
+		func(w *Composer) error { return w.Compose(1, nil) },
+	)
+	scanner, err := NewScanner(bb, DefaultHandler(DiscardAll))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ensure(t, scanner.Scan(), true)
+	ensure(t, scanner.Handle(), error(ErrorMessage{Code: ErrorCodeInternal, Text: "oops"}))
+	ensure(t, scanner.Scan(), true)
+	ensure(t, scanner.Handle(), nil)
+}
+
+func TestScannerHandlesClose(t *testing.T) {
+	bb := testControlStream(t,
+		func(w *Composer) error { return w.ComposeBinary(MTClose, &CloseMessage{Reason: "bye"}) },
+		func(w *Composer) error { return w.Compose(1, nil) },
+	)
+	scanner, err := NewScanner(bb, DefaultHandler(DiscardAll))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ensure(t, scanner.Scan(), true)
+	ensure(t, scanner.Handle(), nil)
+	ensure(t, scanner.Scan(), false)
+	ensure(t, scanner.Err(), nil)
+	ensure(t, scanner.Closed().Reason, String("bye"))
+}
+
+func TestScannerRepliesToPing(t *testing.T) {
+	bb := testControlStream(t,
+		func(w *Composer) error { return w.ComposeBinary(MTPing, &PingMessage{Token: 42}) },
+	)
+	replies := new(bytes.Buffer)
+	w := NewComposer(replies)
+	scanner, err := NewScanner(bb, DefaultHandler(DiscardAll), ReplyTo(w))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ensure(t, scanner.Scan(), true)
+	ensure(t, scanner.Handle(), nil)
+
+	var pong PongMessage
+	scanner, err = NewScanner(replies, Handlers(map[uint32]MessageHandler{
+		uint32(MTPong): pong.UnmarshalBinaryFrom,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ensure(t, scanner.Scan(), true)
+	ensure(t, scanner.Handle(), nil)
+	ensure(t, pong.Token, Uint64(42))
+}
+
+func TestScannerControlHandlerOverride(t *testing.T) {
+	bb := testControlStream(t,
+		func(w *Composer) error { return w.ComposeBinary(MTClose, &CloseMessage{Reason: "bye"}) },
+		func(w *Composer) error { return w.Compose(1, nil) },
+	)
+	var closes int
+	scanner, err := NewScanner(bb, DefaultHandler(DiscardAll), Handlers(map[uint32]MessageHandler{
+		uint32(MTClose): func(ior io.Reader) error {
+			closes++
+			return DiscardAll(ior)
+		},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ensure(t, scanner.Scan(), true)
+	ensure(t, scanner.Handle(), nil)
+	ensure(t, scanner.Scan(), true) // program handled close, so scanning continues
+	ensure(t, scanner.Handle(), nil)
+	ensure(t, closes, 1)
+}
+
+func TestScannerPassControl(t *testing.T) {
+	bb := testControlStream(t,
+		func(w *Composer) error {
+			return w.ComposeBinary(MTError, &ErrorMessage{Code: ErrorCodeInternal, Text: "oops"})
+		},
+		func(w *Composer) error { return w.Compose(MTHeartbeat, nil) },
+		func(w *Composer) error { return w.ComposeBinary(MTClose, &CloseMessage{Reason: "bye"}) },
+		func(w *Composer) error { return w.Compose(1, nil) },
+	)
+	var types []MessageType
+	var scanner *Scanner
+	scanner, err := NewScanner(bb, PassControl(), DefaultHandler(func(ior io.Reader) error {
+		types = append(types, scanner.MessageType())
+		return DiscardAll(ior)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for scanner.Scan() {
+		ensure(t, scanner.Handle(), nil)
+	}
+	ensure(t, scanner.Err(), nil)
+	ensure(t, fmt.Sprint(types), fmt.Sprint([]MessageType{MTError, MTClose, 1}))
+}
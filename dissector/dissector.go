@@ -0,0 +1,218 @@
+// Package dissector generates Wireshark dissectors, written in Lua, that decode
+// gobsp streams carried over TCP.
+//
+// A generated dissector follows gobsp's framing rules: it decodes the preamble
+// that may begin a stream, splits the stream into frames by their variable
+// width message type and body size, reassembles frames that span TCP segments,
+// unpacks batches, decompresses compressed batches, and shows the header of
+// each fragment. Given a schema (see the schema package), it also decodes the
+// body of each message the schema describes into a tree of named fields: zig
+// zag encoded VWI, big-endian fixed width integers, IEEE floating point
+// numbers, and length prefixed strings. Each field may be used in display
+// filters, as protocol.message.field, such as gobsp.login.user.
+//
+// To use a generated dissector, copy it to Wireshark's personal Lua plugins
+// directory, shown under Help, About Wireshark, Folders, and either generate
+// it with the TCP ports the protocol uses, or select the protocol with Decode
+// As.
+package dissector
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/karrick/gobsp"
+	"github.com/karrick/gobsp/schema"
+)
+
+// DefaultName is the name of the protocol a dissector decodes, unless
+// configured with Name.
+const DefaultName = "gobsp"
+
+// ErrInvalidName is an error that is returned when a protocol name is not
+// suitable for use in Wireshark display filters.
+type ErrInvalidName string
+
+func (e ErrInvalidName) Error() string {
+	return "invalid protocol name: " + strconv.Quote(string(e))
+}
+
+// ErrInvalidPort is an error that is returned when a TCP port is out of range.
+type ErrInvalidPort int
+
+func (e ErrInvalidPort) Error() string {
+	return "invalid port: " + strconv.Itoa(int(e))
+}
+
+// validName matches the protocol names Wireshark accepts.
+var validName = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// Config is a function that modifies the generation of a dissector.
+type Config func(*generator) error
+
+// Name sets the name of the protocol the dissector decodes, which prefixes
+// its display filter fields, so dissectors for several gobsp applications may
+// be used at once. The name must be lower case letters, digits, and
+// underscores, beginning with a letter.
+func Name(name string) Config {
+	return func(g *generator) error {
+		if !validName.MatchString(name) {
+			return ErrInvalidName(name)
+		}
+		g.name = name
+		return nil
+	}
+}
+
+// Ports causes the dissector to decode TCP traffic to or from the specified
+// ports.
+func Ports(ports ...int) Config {
+	return func(g *generator) error {
+		for _, port := range ports {
+			if port < 1 || port > math.MaxUint16 {
+				return ErrInvalidPort(port)
+			}
+		}
+		g.ports = append(g.ports, ports...)
+		return nil
+	}
+}
+
+// generator holds the options for generating a dissector.
+type generator struct {
+	name   string
+	ports  []int
+	schema *schema.Schema
+	abbrev map[string]bool // display filter fields already defined
+}
+
+// protoFields maps schema field types to the Wireshark ProtoField constructors
+// of the fields that show them.
+var protoFields = map[string]string{
+	"Int8": "int8", "Uint8": "uint8", "Int16": "int16", "Uint16": "uint16",
+	"Int32": "int32", "Uint32": "uint32", "Int64": "int64", "Uint64": "uint64",
+	"VWI": "int64", "UVWI": "uint64", "Float32": "float", "Float64": "double",
+	"String": "string", "StringSlice": "string", "Bytes": "bytes",
+}
+
+// Generate writes a Lua dissector to the specified io.Writer. When the schema is
+// nil, the dissector decodes the bodies of only the reserved control messages.
+func Generate(iow io.Writer, s *schema.Schema, configurators ...Config) error {
+	g := &generator{name: DefaultName, schema: s, abbrev: make(map[string]bool)}
+	for _, configurator := range configurators {
+		if err := configurator(g); err != nil {
+			return err
+		}
+	}
+	if g.schema == nil {
+		var err error
+		if g.schema, err = schema.New(nil); err != nil {
+			return err
+		}
+	}
+
+	bb := new(bytes.Buffer)
+	fmt.Fprintf(bb, "-- Code generated by gobsp-dissector. DO NOT EDIT.\n\n")
+	fmt.Fprintf(bb, "local PROTO_NAME = %s\n", quote(g.name))
+	fmt.Fprintf(bb, "local PORTS = {%s}\n", g.portList())
+	bb.WriteString(prelude)
+	g.writeNames(bb)
+	g.writeMessages(bb)
+	bb.WriteString(postlude)
+	_, err := iow.Write(bb.Bytes())
+	return err
+}
+
+// portList returns the TCP ports as the elements of a Lua table.
+func (g *generator) portList() string {
+	ports := make([]string, len(g.ports))
+	for i, port := range g.ports {
+		ports[i] = strconv.Itoa(port)
+	}
+	return strings.Join(ports, ", ")
+}
+
+// writeNames writes the table of reserved message type names.
+func (g *generator) writeNames(bb *bytes.Buffer) {
+	bb.WriteString("\n-- reserved holds the names of the reserved message types.\nlocal reserved = {\n")
+	for mt := gobsp.MinReservedMessageType; mt <= math.MaxUint32; mt++ {
+		if name := mt.String(); name != gobsp.UVWI(mt).String() {
+			fmt.Fprintf(bb, "\t[\"%d\"] = %s,\n", uint64(mt), quote(name))
+		}
+	}
+	bb.WriteString("}\n")
+}
+
+// writeMessages writes the table of messages the schema describes, defining
+// a display filter field for each of their fields.
+func (g *generator) writeMessages(bb *bytes.Buffer) {
+	bb.WriteString("\n-- messages describes the bodies of message types, keyed by type.\nlocal messages = {\n")
+	for _, mt := range g.schema.Types() {
+		m := g.schema.Messages[mt]
+		fmt.Fprintf(bb, "\t[\"%d\"] = {\n\t\tname = %s,\n\t\tfields = {\n", uint64(mt), quote(m.Name))
+		for _, f := range m.Fields {
+			abbrev := g.abbreviation(mt, m.Name, f.Name)
+			fmt.Fprintf(bb, "\t\t\t{ %s, field(ProtoField.%s(%s, %s)), %s },\n",
+				quote(f.Type), protoFields[f.Type], quote(abbrev), quote(f.Name), quote(f.Name))
+		}
+		bb.WriteString("\t\t},\n\t},\n")
+	}
+	bb.WriteString("}\n")
+}
+
+// abbreviation returns a display filter field name for a message field, unique
+// within the dissector.
+func (g *generator) abbreviation(mt gobsp.MessageType, message, field string) string {
+	if message == "" {
+		message = "type" + gobsp.UVWI(mt).String()
+	}
+	abbrev := g.name + "." + identifier(message) + "." + identifier(field)
+	for n := 2; g.abbrev[abbrev]; n++ {
+		abbrev = g.name + "." + identifier(message) + "." + identifier(field) + "_" + strconv.Itoa(n)
+	}
+	g.abbrev[abbrev] = true
+	return abbrev
+}
+
+// identifier returns a name converted to lower case letters, digits, and
+// underscores, as display filter fields require.
+func identifier(name string) string {
+	var sb strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			sb.WriteRune(r)
+		} else {
+			sb.WriteByte('_')
+		}
+	}
+	if sb.Len() == 0 {
+		return "_"
+	}
+	return sb.String()
+}
+
+// quote returns a Lua string literal holding the specified string. Bytes other
+// than printable ASCII are written as decimal escapes, which every version of
+// Lua accepts.
+func quote(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case c >= 0x20 && c < 0x7f:
+			sb.WriteByte(c)
+		default:
+			fmt.Fprintf(&sb, "\\%03d", c)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
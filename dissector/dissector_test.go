@@ -0,0 +1,85 @@
+package dissector
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/karrick/gobsp/schema"
+)
+
+func ensure(t *testing.T, actual, expected interface{}) {
+	t.Helper()
+	if actual != expected {
+		t.Errorf("Actual: %#v; Expected: %#v", actual, expected)
+	}
+}
+
+func generate(t *testing.T, s *schema.Schema, configurators ...Config) string {
+	t.Helper()
+	bb := new(bytes.Buffer)
+	if err := Generate(bb, s, configurators...); err != nil {
+		t.Fatal(err)
+	}
+	return bb.String()
+}
+
+func TestGenerate(t *testing.T) {
+	s, err := schema.Load(strings.NewReader(`{"messages": {"1": {"name": "Login", "fields": [
+		{"name": "user", "type": "String"},
+		{"name": "user name", "type": "String"},
+		{"name": "attempts", "type": "VWI"}
+	]}, "2": {"fields": [{"name": "ratio", "type": "Float32"}]}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lua := generate(t, s, Name("chat"), Ports(7000, 7001))
+
+	for _, line := range []string{
+		`local PROTO_NAME = "chat"`,
+		`local PORTS = {7000, 7001}`,
+		`	["4294967047"] = "Batch",`,
+		`	["1"] = {`,
+		`		name = "Login",`,
+		`			{ "String", field(ProtoField.string("chat.login.user", "user")), "user" },`,
+		`			{ "String", field(ProtoField.string("chat.login.user_name", "user name")), "user name" },`,
+		`			{ "VWI", field(ProtoField.int64("chat.login.attempts", "attempts")), "attempts" },`,
+		`			{ "Float32", field(ProtoField.float("chat.type2.ratio", "ratio")), "ratio" },`,
+		`			{ "UVWI", field(ProtoField.uint64("chat.error.code", "code")), "code" },`,
+		`function proto.dissector(tvb, pinfo, tree)`,
+		`	if at_start and may_be_preamble(tvb) then`,
+	} {
+		if !strings.Contains(lua, "\n"+line+"\n") {
+			t.Errorf("missing line: %s", line)
+		}
+	}
+}
+
+func TestGenerateWithoutSchema(t *testing.T) {
+	lua := generate(t, nil)
+	ensure(t, strings.Contains(lua, `local PROTO_NAME = "gobsp"`), true)
+	ensure(t, strings.Contains(lua, `local PORTS = {}`), true)
+	ensure(t, strings.Contains(lua, `"gobsp.ping.token"`), true)
+}
+
+func TestAbbreviation(t *testing.T) {
+	g := &generator{name: "p", abbrev: make(map[string]bool)}
+	ensure(t, g.abbreviation(1, "A-B", "c"), "p.a_b.c")
+	ensure(t, g.abbreviation(1, "a_b", "C"), "p.a_b.c_2")
+	ensure(t, g.abbreviation(1, "a_b", "c"), "p.a_b.c_3")
+	ensure(t, g.abbreviation(9, "", ""), "p.type9._")
+}
+
+func TestQuote(t *testing.T) {
+	ensure(t, quote(`a"b\c`), `"a\"b\\c"`)
+	ensure(t, quote("\n\xff"), `"\010\255"`)
+}
+
+func TestConfigErrors(t *testing.T) {
+	bb := new(bytes.Buffer)
+	ensure(t, Generate(bb, nil, Name("Chat")), ErrInvalidName("Chat"))
+	ensure(t, Generate(bb, nil, Name("1chat")), ErrInvalidName("1chat"))
+	ensure(t, Generate(bb, nil, Ports(80, 0)), ErrInvalidPort(0))
+	ensure(t, Generate(bb, nil, Ports(65536)), ErrInvalidPort(65536))
+	ensure(t, bb.Len(), 0)
+}
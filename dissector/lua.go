@@ -0,0 +1,376 @@
+package dissector
+
+// prelude follows the generated definitions of PROTO_NAME and PORTS, and
+// defines the protocol, its framing fields, and the functions that decode
+// primitives.
+const prelude = `
+local proto = Proto(PROTO_NAME, "gobsp binary stream protocol (" .. PROTO_NAME .. ")")
+
+-- fields collects every display filter field, to register with the protocol.
+local fields = {}
+
+local function field(f)
+	fields[#fields + 1] = f
+	return f
+end
+
+local pf = {
+	preamble = field(ProtoField.none(PROTO_NAME .. ".preamble", "Preamble")),
+	magic = field(ProtoField.string(PROTO_NAME .. ".preamble.magic", "Magic")),
+	version = field(ProtoField.uint8(PROTO_NAME .. ".preamble.version", "Version")),
+	features = field(ProtoField.uint64(PROTO_NAME .. ".preamble.features", "Features", base.HEX)),
+	application = field(ProtoField.string(PROTO_NAME .. ".preamble.application", "Application")),
+	type = field(ProtoField.uint64(PROTO_NAME .. ".type", "Message type")),
+	name = field(ProtoField.string(PROTO_NAME .. ".name", "Message name")),
+	size = field(ProtoField.uint64(PROTO_NAME .. ".size", "Body size")),
+	body = field(ProtoField.bytes(PROTO_NAME .. ".body", "Body")),
+	fragment_id = field(ProtoField.uint64(PROTO_NAME .. ".fragment.id", "Fragmented message ID")),
+	fragment_flags = field(ProtoField.uint8(PROTO_NAME .. ".fragment.flags", "Fragment flags", base.HEX)),
+	fragment_type = field(ProtoField.uint64(PROTO_NAME .. ".fragment.type", "Fragmented message type")),
+	fragment_data = field(ProtoField.bytes(PROTO_NAME .. ".fragment.data", "Fragment data")),
+}
+
+local malformed = ProtoExpert.new(PROTO_NAME .. ".malformed", "Malformed gobsp data",
+	expert.group.MALFORMED, expert.severity.ERROR)
+proto.experts = { malformed }
+
+-- read_uvwi returns the value of the variable width integer at offset in tvb,
+-- as a UInt64, and its width in bytes. It returns nil when tvb ends first, and
+-- nil and true when the integer is longer than ten bytes.
+local function read_uvwi(tvb, offset)
+	local value = UInt64(0)
+	for i = 0, 9 do
+		if offset + i >= tvb:len() then
+			return nil
+		end
+		local b = tvb(offset + i, 1):uint()
+		value = value + UInt64(b % 128):lshift(7 * i)
+		if b < 128 then
+			return value, i + 1
+		end
+	end
+	return nil, true
+end
+
+-- zigzag returns the signed value of a VWI, whose sign bit is encoded in its
+-- least significant bit.
+local function zigzag(u)
+	local half = Int64(u:rshift(1))
+	if u:lower() % 2 == 1 then
+		return -half - 1
+	end
+	return half
+end
+
+-- widths holds the widths of the fixed width field types, which Wireshark
+-- decodes as big-endian.
+local widths = {
+	Int8 = 1, Uint8 = 1, Int16 = 2, Uint16 = 2, Int32 = 4, Uint32 = 4,
+	Int64 = 8, Uint64 = 8, Float32 = 4, Float64 = 8,
+}
+
+-- decode_string adds the length prefixed string at offset in tvb to tree, and
+-- returns the offset following it, or nil when tvb ends first.
+local function decode_string(tvb, offset, tree, f)
+	local size, width = read_uvwi(tvb, offset)
+	if not size then
+		return nil
+	end
+	size = size:tonumber()
+	if offset + width + size > tvb:len() then
+		return nil
+	end
+	local s = ""
+	if size > 0 then
+		s = tvb(offset + width, size):string(ENC_UTF_8)
+	end
+	tree:add(f, tvb(offset, width + size), s)
+	return offset + width + size
+end
+
+-- decode_field adds the field of the specified type at offset in tvb to tree,
+-- and returns the offset following it, or nil when tvb ends first.
+local function decode_field(tvb, offset, tree, typ, f, label)
+	local width = widths[typ]
+	if width then
+		if offset + width > tvb:len() then
+			return nil
+		end
+		tree:add(f, tvb(offset, width))
+		return offset + width
+	elseif typ == "VWI" or typ == "UVWI" then
+		local value, w = read_uvwi(tvb, offset)
+		if not value then
+			return nil
+		end
+		if typ == "VWI" then
+			value = zigzag(value)
+		end
+		tree:add(f, tvb(offset, w), value)
+		return offset + w
+	elseif typ == "String" then
+		return decode_string(tvb, offset, tree, f)
+	elseif typ == "StringSlice" then
+		local count, w = read_uvwi(tvb, offset)
+		if not count then
+			return nil
+		end
+		count = count:tonumber()
+		local item = tree:add(tvb(offset, w), label .. ": " .. count .. " strings")
+		local next = offset + w
+		for _ = 1, count do
+			next = decode_string(tvb, next, item, f)
+			if not next then
+				return nil
+			end
+		end
+		item:set_len(next - offset)
+		return next
+	elseif typ == "Bytes" then
+		if offset < tvb:len() then
+			tree:add(f, tvb(offset))
+		end
+		return tvb:len()
+	end
+	return nil
+end
+`
+
+// postlude follows the generated tables of reserved names and messages, and
+// defines the dissector and registers it.
+const postlude = `
+-- type_name returns the name of a message type, or its number when it has no
+-- name.
+local function type_name(key)
+	local m = messages[key]
+	if m and m.name ~= "" then
+		return m.name
+	end
+	return reserved[key] or key
+end
+
+local dissect_frames
+
+-- dissect_body adds the fields of a message body to tree.
+local function dissect_body(tvb, key, pinfo, tree)
+	local name = reserved[key]
+	if name == "Batch" then
+		dissect_frames(tvb, pinfo, tree)
+		return
+	elseif name == "CompressedBatch" then
+		local range = tvb()
+		local uncompress = range.uncompress_zlib or range.uncompress
+		local ok, decompressed = pcall(uncompress, range, "Decompressed batch")
+		if ok and decompressed then
+			dissect_frames(decompressed, pinfo, tree)
+		else
+			tree:add(pf.body, range)
+			tree:add_proto_expert_info(malformed, "cannot decompress batch")
+		end
+		return
+	elseif name == "Fragment" then
+		local id, w = read_uvwi(tvb, 0)
+		if not id or w >= tvb:len() then
+			tree:add_proto_expert_info(malformed, "fragment header ends early")
+			return
+		end
+		tree:add(pf.fragment_id, tvb(0, w), id)
+		local flags = tvb(w, 1):uint()
+		local item = tree:add(pf.fragment_flags, tvb(w, 1))
+		local offset = w + 1
+		if flags % 2 == 1 then
+			item:append_text(" (first)")
+			local mt, tw = read_uvwi(tvb, offset)
+			if not mt then
+				tree:add_proto_expert_info(malformed, "fragment header ends early")
+				return
+			end
+			tree:add(pf.fragment_type, tvb(offset, tw), mt):append_text(" (" .. type_name(tostring(mt)) .. ")")
+			offset = offset + tw
+		end
+		if math.floor(flags / 2) % 2 == 1 then
+			item:append_text(" (last)")
+		end
+		if offset < tvb:len() then
+			tree:add(pf.fragment_data, tvb(offset))
+		end
+		return
+	end
+
+	local m = messages[key]
+	if not m then
+		tree:add(pf.body, tvb())
+		return
+	end
+	local offset = 0
+	for _, f in ipairs(m.fields) do
+		local next = decode_field(tvb, offset, tree, f[1], f[2], f[3])
+		if not next then
+			tree:add_proto_expert_info(malformed, "body ends part way through field " .. f[3])
+			return
+		end
+		offset = next
+	end
+	if offset < tvb:len() then
+		tree:add_proto_expert_info(malformed, (tvb:len() - offset) .. " bytes after last field")
+		tree:add(pf.body, tvb(offset))
+	end
+end
+
+-- dissect_frame adds the frame at offset in tvb to tree, and returns its
+-- length. When tvb ends part way through the frame, it instead returns the
+-- negated number of bytes still needed, or of DESEGMENT_ONE_MORE_SEGMENT when
+-- that is not yet known.
+local function dissect_frame(tvb, pinfo, tree, offset, names)
+	local mt, tw = read_uvwi(tvb, offset)
+	local size, sw
+	if mt then
+		size, sw = read_uvwi(tvb, offset + tw)
+	end
+	if tw == true or sw == true then
+		tree:add_proto_expert_info(malformed, "variable width integer longer than ten bytes")
+		return tvb:len() - offset
+	end
+	if not size then
+		return -DESEGMENT_ONE_MORE_SEGMENT
+	end
+	local length = tw + sw + size:tonumber()
+	if offset + length > tvb:len() then
+		return -(offset + length - tvb:len())
+	end
+
+	local key = tostring(mt)
+	local name = type_name(key)
+	if names then
+		names[#names + 1] = name
+	end
+	local item = tree:add(proto, tvb(offset, length), "gobsp frame: " .. name)
+	item:add(pf.type, tvb(offset, tw), mt)
+	item:add(pf.name, tvb(offset, tw), name)
+	item:add(pf.size, tvb(offset + tw, sw), size)
+	if length > tw + sw then
+		dissect_body(tvb(offset + tw + sw, length - tw - sw):tvb(), key, pinfo, item)
+	end
+	return length
+end
+
+-- dissect_frames adds every frame of a batch to tree.
+dissect_frames = function(tvb, pinfo, tree)
+	local offset = 0
+	while offset < tvb:len() do
+		local length = dissect_frame(tvb, pinfo, tree, offset, nil)
+		if length < 0 then
+			tree:add_proto_expert_info(malformed, "batch ends part way through a frame")
+			return
+		end
+		offset = offset + length
+	end
+end
+
+-- dissect_preamble adds the preamble beginning tvb to tree, and returns its
+-- length, or the negated number of bytes still needed.
+local function dissect_preamble(tvb, tree)
+	if tvb:len() < 6 then
+		return -DESEGMENT_ONE_MORE_SEGMENT
+	end
+	local features, fw = read_uvwi(tvb, 5)
+	local size, sw
+	if features then
+		size, sw = read_uvwi(tvb, 5 + fw)
+	end
+	if not size then
+		return -DESEGMENT_ONE_MORE_SEGMENT
+	end
+	local length = 5 + fw + sw + size:tonumber()
+	if length > tvb:len() then
+		return -(length - tvb:len())
+	end
+	local item = tree:add(pf.preamble, tvb(0, length))
+	item:add(pf.magic, tvb(0, 4))
+	item:add(pf.version, tvb(4, 1))
+	item:add(pf.features, tvb(5, fw), features)
+	decode_string(tvb, 5 + fw, item, pf.application)
+	return length
+end
+
+-- Only the first bytes of each direction of a TCP stream may be a preamble, so
+-- that a frame whose bytes happen to begin with the magic is not taken for one.
+-- Packets are dissected in order on the first pass, so a direction expects a
+-- preamble until its first bytes have been dissected. Later passes may visit
+-- packets in any order, so the packets whose dissection began at the start of
+-- a stream are remembered.
+local tcp_stream = Field.new("tcp.stream")
+local expecting = {}     -- false once the start of a direction has been dissected
+local stream_starts = {} -- packets dissected from the start of a direction
+
+-- direction returns a key identifying the direction of the TCP stream of the
+-- packet being dissected.
+local function direction(pinfo)
+	local stream = tcp_stream()
+	return tostring(stream and stream.value) .. " " .. tostring(pinfo.src) .. ":" .. tostring(pinfo.src_port)
+end
+
+-- may_be_preamble returns true when bytes beginning tvb could be the start
+-- of the preamble.
+local function may_be_preamble(tvb)
+	local n = math.min(tvb:len(), 4)
+	return n > 0 and tvb(0, n):string() == ("GBSP"):sub(1, n)
+end
+
+function proto.dissector(tvb, pinfo, tree)
+	pinfo.cols.protocol = string.upper(PROTO_NAME)
+	local offset = 0
+	local names = {}
+
+	local at_start
+	local key = direction(pinfo)
+	if pinfo.visited then
+		at_start = stream_starts[pinfo.number] == true
+	else
+		at_start = expecting[key] ~= false
+		expecting[key] = false
+		if at_start then
+			stream_starts[pinfo.number] = true
+		end
+	end
+	if at_start and may_be_preamble(tvb) then
+		local length = -DESEGMENT_ONE_MORE_SEGMENT
+		if tvb:len() >= 4 then
+			length = dissect_preamble(tvb, tree)
+		end
+		if length < 0 then
+			if not pinfo.visited then
+				expecting[key] = nil -- the preamble continues in a later segment
+			end
+			pinfo.desegment_offset = 0
+			pinfo.desegment_len = -length
+			return tvb:len()
+		end
+		names[1] = "Preamble"
+		offset = length
+	end
+	while offset < tvb:len() do
+		local length = dissect_frame(tvb, pinfo, tree, offset, names)
+		if length < 0 then
+			pinfo.desegment_offset = offset
+			pinfo.desegment_len = -length
+			break
+		end
+		offset = offset + length
+	end
+	if #names > 0 then
+		pinfo.cols.info = table.concat(names, ", ")
+	end
+	return tvb:len()
+end
+
+proto.fields = fields
+
+local tcp_port = DissectorTable.get("tcp.port")
+if tcp_port.add_for_decode_as then
+	tcp_port:add_for_decode_as(proto)
+end
+for _, port in ipairs(PORTS) do
+	tcp_port:add(port, proto)
+end
+`
@@ -0,0 +1,189 @@
+// Package filter rewrites gobsp streams one message at a time, by applying
+// rules that drop messages, or change their types or bodies, and writing those
+// that remain to a new stream.
+//
+// Rules see messages rather than frames: batches are unpacked, and fragmented
+// messages reassembled, so a rule that drops a message type drops every
+// message of that type, however it was framed. Each message that remains is
+// written as a frame of its own, with its body unchanged unless a rule changed
+// it. Because a reassembled message may be larger than its consumer accepts in
+// one frame, the output Composer may be configured with gobsp.MaxFrameSize,
+// which fragments larger messages again. Heartbeats carry no data and are not
+// written. Other control messages are passed to the rules like any other
+// message.
+//
+//	f := filter.New(filter.Drop(secretType), filter.Renumber(map[gobsp.MessageType]gobsp.MessageType{1: 101}))
+//	counts, err := f.Copy(out, in)
+package filter
+
+import (
+	"io"
+	"io/ioutil"
+
+	"github.com/karrick/gobsp"
+)
+
+// Message is a message passing through a Filter.
+type Message struct {
+	Type gobsp.MessageType
+	Body []byte
+}
+
+// Rule examines a message passing through a Filter, and may change its type or
+// body. It returns false to drop the message. A rule may replace the body, but
+// must not modify the bytes of the body it was given.
+type Rule func(m *Message) bool
+
+// types returns the set of the specified message types.
+func types(messageTypes []gobsp.MessageType) map[gobsp.MessageType]bool {
+	set := make(map[gobsp.MessageType]bool, len(messageTypes))
+	for _, mt := range messageTypes {
+		set[mt] = true
+	}
+	return set
+}
+
+// Drop returns a Rule that drops messages of the specified types.
+func Drop(messageTypes ...gobsp.MessageType) Rule {
+	set := types(messageTypes)
+	return func(m *Message) bool {
+		return !set[m.Type]
+	}
+}
+
+// Keep returns a Rule that drops messages of all but the specified types.
+func Keep(messageTypes ...gobsp.MessageType) Rule {
+	set := types(messageTypes)
+	return func(m *Message) bool {
+		return set[m.Type]
+	}
+}
+
+// Renumber returns a Rule that changes the type of each message whose type is a
+// key of the specified map to the corresponding value.
+func Renumber(mapping map[gobsp.MessageType]gobsp.MessageType) Rule {
+	return func(m *Message) bool {
+		if mt, ok := mapping[m.Type]; ok {
+			m.Type = mt
+		}
+		return true
+	}
+}
+
+// Truncate returns a Rule that shortens the bodies of messages of the specified
+// types, or of every message when no types are specified, to at most size
+// bytes.
+func Truncate(size int, messageTypes ...gobsp.MessageType) Rule {
+	set := types(messageTypes)
+	return func(m *Message) bool {
+		if len(m.Body) > size && (len(set) == 0 || set[m.Type]) {
+			m.Body = m.Body[:size]
+		}
+		return true
+	}
+}
+
+// Sample returns a Rule that keeps one of every n messages it sees, starting
+// with the first, and drops the rest. It keeps every message when n is less
+// than two. Rules before it choose which messages it sees, so sampling one
+// message type is done by following Keep with Sample.
+//
+// The Rule counts the messages it sees for as long as it is used, so a Filter
+// that copies several streams continues sampling each stream where the
+// previous one left off. A Filter with a new Sample Rule starts afresh. The
+// Rule is not safe for concurrent use.
+func Sample(n int) Rule {
+	var seen int
+	return func(m *Message) bool {
+		keep := n < 2 || seen%n == 0
+		seen++
+		return keep
+	}
+}
+
+// Filter applies rules to messages, in order.
+type Filter struct {
+	// ComposerConfigs are applied to the Composer that Copy writes with, such
+	// as gobsp.MaxFrameSize to bound the size of the frames written. They
+	// ought not include gobsp.WritePreamble, as Copy writes the preamble of
+	// the input stream, if any.
+	ComposerConfigs []gobsp.ComposerConfig
+
+	rules []Rule
+}
+
+// New returns a Filter that applies the specified rules, in order, to each
+// message. Once a rule drops a message, the rules after it do not see it.
+func New(rules ...Rule) *Filter {
+	return &Filter{rules: rules}
+}
+
+// Apply applies the filter's rules to a message, and returns false when one of
+// them drops it.
+func (f *Filter) Apply(m *Message) bool {
+	for _, rule := range f.rules {
+		if !rule(m) {
+			return false
+		}
+	}
+	return true
+}
+
+// Counts reports how many messages Copy read and wrote.
+type Counts struct {
+	Read    int
+	Written int
+}
+
+// Copy reads the messages of the gobsp stream read from ior, applies the
+// filter's rules to each, and writes those that remain to iow. When the input
+// stream begins with a preamble, the same preamble begins the output stream.
+// The configuration functions are applied to the Scanner, so limits such as
+// gobsp.MaxReassembledSize may be set.
+func (f *Filter) Copy(iow io.Writer, ior io.Reader, configurators ...gobsp.ScannerConfig) (Counts, error) {
+	var counts Counts
+	w, err := gobsp.NewComposerWithOptions(iow, f.ComposerConfigs...)
+	if err != nil {
+		return counts, err
+	}
+
+	configurators = append(configurators, gobsp.OptionalPreamble(func(p gobsp.Preamble) error {
+		// The Composer has not written anything, so the preamble is
+		// written ahead of every message.
+		return p.MarshalBinaryTo(iow)
+	}))
+
+	var scanner *gobsp.Scanner
+	handler := func(r io.Reader) error {
+		counts.Read++
+		body, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		if uint64(len(body)) < scanner.MessageSize() {
+			return io.ErrUnexpectedEOF // stream ended part way through the frame
+		}
+		m := Message{Type: scanner.MessageType(), Body: body}
+		if !f.Apply(&m) {
+			return nil
+		}
+		counts.Written++
+		return w.Compose(m.Type, m.Body)
+	}
+	// Control messages are filtered like any other, rather than being
+	// processed by the Scanner.
+	configurators = append(configurators, gobsp.PassControl(), gobsp.DefaultHandler(handler))
+	if scanner, err = gobsp.NewScanner(ior, configurators...); err != nil {
+		return counts, err
+	}
+
+	for scanner.Scan() {
+		if err := scanner.Handle(); err != nil {
+			return counts, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return counts, err
+	}
+	return counts, w.Close()
+}
@@ -0,0 +1,188 @@
+package filter
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/karrick/gobsp"
+)
+
+func ensure(t *testing.T, actual, expected interface{}) {
+	t.Helper()
+	if actual != expected {
+		t.Errorf("Actual: %#v; Expected: %#v", actual, expected)
+	}
+}
+
+// testStream returns a stream that begins with a preamble, and holds a message
+// of type 1, a batch of types 2 and 3, a heartbeat, a fragmented message of
+// type 2, and a Close message.
+func testStream(t *testing.T) []byte {
+	bb := new(bytes.Buffer)
+	w, err := gobsp.NewComposerWithOptions(bb, gobsp.WritePreamble("test", 0), gobsp.MaxFrameSize(32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Compose(1, []byte("one")); err != nil {
+		t.Fatal(err)
+	}
+	b := w.Batch()
+	if err = b.Add(2, []byte("two")); err != nil {
+		t.Fatal(err)
+	}
+	if err = b.Add(3, []byte("three")); err != nil {
+		t.Fatal(err)
+	}
+	if err = b.Send(); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Compose(gobsp.MTHeartbeat, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Compose(2, []byte(strings.Repeat("x", 100))); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.ComposeBinary(gobsp.MTClose, &gobsp.CloseMessage{Reason: "done"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return bb.Bytes()
+}
+
+// testMessages describes the messages of a stream, which must begin with a
+// preamble naming the test application.
+func testMessages(t *testing.T, stream []byte) string {
+	var description string
+	var scanner *gobsp.Scanner
+	handler := func(r io.Reader) error {
+		body, err := ioutil.ReadAll(r)
+		description += fmt.Sprintf("%s %q;", scanner.MessageType(), body)
+		return err
+	}
+	scanner, err := gobsp.NewScanner(bytes.NewReader(stream),
+		gobsp.ExpectPreamble("test", 0),
+		gobsp.Handlers(map[uint32]gobsp.MessageHandler{uint32(gobsp.MTClose): handler}),
+		gobsp.DefaultHandler(handler))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for scanner.Scan() {
+		if err = scanner.Handle(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return description
+}
+
+func testCopy(t *testing.T, rules ...Rule) (string, Counts) {
+	bb := new(bytes.Buffer)
+	counts, err := New(rules...).Copy(bb, bytes.NewReader(testStream(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testMessages(t, bb.Bytes()), counts
+}
+
+func TestCopyUnchanged(t *testing.T) {
+	actual, counts := testCopy(t)
+	ensure(t, actual, `1 "one";2 "two";3 "three";2 "`+strings.Repeat("x", 100)+`";Close "\x04done";`)
+	ensure(t, counts, Counts{Read: 5, Written: 5})
+}
+
+func TestCopyDropAndKeep(t *testing.T) {
+	actual, counts := testCopy(t, Drop(2, gobsp.MTClose))
+	ensure(t, actual, `1 "one";3 "three";`)
+	ensure(t, counts, Counts{Read: 5, Written: 2})
+
+	actual, _ = testCopy(t, Keep(3))
+	ensure(t, actual, `3 "three";`)
+}
+
+func TestCopyRenumberAndTruncate(t *testing.T) {
+	actual, _ := testCopy(t, Renumber(map[gobsp.MessageType]gobsp.MessageType{1: 10, 2: 20}), Truncate(2, 20))
+	ensure(t, actual, `10 "one";20 "tw";3 "three";20 "xx";Close "\x04done";`)
+
+	actual, _ = testCopy(t, Keep(1, 3), Truncate(1))
+	ensure(t, actual, `1 "o";3 "t";`)
+}
+
+func TestCopySample(t *testing.T) {
+	actual, counts := testCopy(t, Sample(2))
+	ensure(t, actual, `1 "one";3 "three";Close "\x04done";`)
+	ensure(t, counts, Counts{Read: 5, Written: 3})
+
+	actual, _ = testCopy(t, Keep(2), Sample(2))
+	ensure(t, actual, `2 "two";`)
+}
+
+func TestCopySampleAcrossStreams(t *testing.T) {
+	f := New(Sample(2))
+	stream := []byte{0x01, 0x01, 'a', 0x02, 0x01, 'b', 0x03, 0x01, 'c'}
+	for _, expected := range []string{"\x01\x01a\x03\x01c", "\x02\x01b"} {
+		bb := new(bytes.Buffer)
+		_, err := f.Copy(bb, bytes.NewReader(stream))
+		ensure(t, err, nil)
+		ensure(t, bb.String(), expected) // the count continues from the previous stream
+	}
+}
+
+func TestCopyWithoutPreamble(t *testing.T) {
+	bb := new(bytes.Buffer)
+	counts, err := New(Drop(1)).Copy(bb, bytes.NewReader([]byte{0x01, 0x01, 'a', 0x02, 0x01, 'b'}))
+	ensure(t, err, nil)
+	ensure(t, counts, Counts{Read: 2, Written: 1})
+	ensure(t, bytes.Equal(bb.Bytes(), []byte{0x02, 0x01, 'b'}), true)
+}
+
+func TestCopyTruncatedStream(t *testing.T) {
+	_, err := New().Copy(ioutil.Discard, bytes.NewReader([]byte{0x01, 0x05, 'a', 'b'}))
+	ensure(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestCopyMaxFrameSize(t *testing.T) {
+	f := New()
+	f.ComposerConfigs = []gobsp.ComposerConfig{gobsp.MaxFrameSize(32)}
+	bb := new(bytes.Buffer)
+	if _, err := f.Copy(bb, bytes.NewReader(testStream(t))); err != nil {
+		t.Fatal(err)
+	}
+	ensure(t, testMessages(t, bb.Bytes()), `1 "one";2 "two";3 "three";2 "`+strings.Repeat("x", 100)+`";Close "\x04done";`)
+
+	// The reassembled message is fragmented again.
+	scanner, err := gobsp.NewScanner(bytes.NewReader(bb.Bytes()), gobsp.Raw(), gobsp.ExpectPreamble("test", 0), gobsp.DefaultHandler(gobsp.DiscardAll))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fragments int
+	for scanner.Scan() {
+		if scanner.MessageSize() > 32 {
+			t.Errorf("Actual: %d byte frame; Expected: at most 32", scanner.MessageSize())
+		}
+		if scanner.MessageType() == gobsp.MTFragment {
+			fragments++
+		}
+		if err = scanner.Handle(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ensure(t, scanner.Err(), nil)
+	if fragments < 2 {
+		t.Errorf("Actual: %d fragments; Expected: at least 2", fragments)
+	}
+}
+
+func TestCopyInvalidComposerConfig(t *testing.T) {
+	f := New()
+	f.ComposerConfigs = []gobsp.ComposerConfig{gobsp.MaxFrameSize(8)}
+	_, err := f.Copy(ioutil.Discard, bytes.NewReader(nil))
+	ensure(t, err, error(gobsp.ErrFrameSizeTooSmall(8)))
+}
@@ -0,0 +1,178 @@
+// Package follow reads a gobsp stream from a file that another process is
+// still appending to, in the manner of "tail -f".
+//
+// A Reader never reports the end of the file. When it has read everything
+// written so far, it waits for more to be written, so a gobsp.Scanner reading
+// from it waits for the next message rather than ending its scan, even when
+// the writer has written only part of a frame. A Reader polls the file for new
+// data, because portable file change notification is not available in the
+// standard library.
+//
+// When the file is truncated, or rotated by renaming it and creating a new file
+// in its place, the stream starts over. The Reader returns ErrTruncated or
+// ErrRotated, which ends the Scanner's scan, then continues with the new
+// contents of the file. The program creates a new Scanner, and scans again:
+//
+//	for {
+//		scanner, err := gobsp.NewScanner(r, gobsp.Handlers(handlers))
+//		if err != nil {
+//			return err
+//		}
+//		for scanner.Scan() {
+//			if err := scanner.Handle(); err != nil {
+//				return err
+//			}
+//		}
+//		switch scanner.Err().(type) {
+//		case follow.ErrTruncated, follow.ErrRotated:
+//			continue
+//		}
+//		return scanner.Err()
+//	}
+package follow
+
+import (
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultPollInterval is how often a Reader checks the file for new data,
+// unless configured with PollInterval.
+const DefaultPollInterval = 250 * time.Millisecond
+
+// ErrTruncated is an error that is returned once when the followed file
+// becomes shorter than the Reader's position in it. The Reader continues from
+// the start of the file.
+type ErrTruncated struct{}
+
+func (e ErrTruncated) Error() string {
+	return "followed file truncated"
+}
+
+// ErrRotated is an error that is returned once when the followed file is
+// replaced by a new file of the same name, after the Reader has read
+// everything written to the old file. The Reader continues from the start of
+// the new file.
+type ErrRotated struct{}
+
+func (e ErrRotated) Error() string {
+	return "followed file rotated"
+}
+
+// Config is a function that modifies a newly opened Reader instance.
+type Config func(*Reader) error
+
+// PollInterval specifies how often a Reader checks the file for new data, and
+// for truncation or rotation, when it has read everything written so far.
+func PollInterval(interval time.Duration) Config {
+	return func(r *Reader) error {
+		r.interval = interval
+		return nil
+	}
+}
+
+// Reader is an io.ReadCloser that follows a growing file. Close may be called
+// from another goroutine to stop a Read that is waiting for data, which then
+// returns io.EOF.
+type Reader struct {
+	name     string
+	interval time.Duration
+	closed   chan struct{}
+
+	mu     sync.Mutex // guards f and offset
+	f      *os.File   // nil once closed
+	offset int64      // bytes read from f
+}
+
+// Open opens the named file to be followed from its start.
+func Open(name string, configurators ...Config) (*Reader, error) {
+	r := &Reader{name: name, interval: DefaultPollInterval, closed: make(chan struct{})}
+	for _, c := range configurators {
+		if err := c(r); err != nil {
+			return nil, err
+		}
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	r.f = f
+	return r, nil
+}
+
+// Read reads from the followed file, waiting for data to be appended when it
+// has read everything written so far.
+func (r *Reader) Read(buf []byte) (int, error) {
+	for {
+		n, err := r.read(buf)
+		if n > 0 || err != nil {
+			return n, err
+		}
+		select {
+		case <-r.closed:
+			return 0, io.EOF
+		case <-time.After(r.interval):
+		}
+	}
+}
+
+// read reads from the followed file, returning zero bytes and a nil error when
+// the Reader must wait for more data.
+func (r *Reader) read(buf []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return 0, io.EOF
+	}
+	n, err := r.f.Read(buf)
+	r.offset += int64(n)
+	if n > 0 || (err != nil && err != io.EOF) {
+		return n, err
+	}
+
+	// Everything written so far has been read.
+	fi, err := r.f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if fi.Size() < r.offset {
+		if _, err = r.f.Seek(0, io.SeekStart); err != nil {
+			return 0, err
+		}
+		r.offset = 0
+		return 0, ErrTruncated{}
+	}
+	nfi, err := os.Stat(r.name)
+	if err != nil || os.SameFile(fi, nfi) {
+		return 0, nil // not rotated, or new file not yet created
+	}
+
+	// Read anything appended to the old file since it was last read, before
+	// switching to the new file.
+	if n, err = r.f.Read(buf); n > 0 || (err != nil && err != io.EOF) {
+		r.offset += int64(n)
+		return n, err
+	}
+	f, err := os.Open(r.name)
+	if err != nil {
+		return 0, nil // new file removed again; keep waiting
+	}
+	_ = r.f.Close()
+	r.f, r.offset = f, 0
+	return 0, ErrRotated{}
+}
+
+// Close stops following the file, and closes it.
+func (r *Reader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return nil
+	}
+	close(r.closed)
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
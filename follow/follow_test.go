@@ -0,0 +1,159 @@
+package follow
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/karrick/gobsp"
+)
+
+func ensure(t *testing.T, actual, expected interface{}) {
+	t.Helper()
+	if actual != expected {
+		t.Errorf("Actual: %#v; Expected: %#v", actual, expected)
+	}
+}
+
+// testFollow scans messages from the Reader in a background goroutine,
+// sending each message body, then the error that ended the scan.
+func testFollow(t *testing.T, r io.Reader) (<-chan string, <-chan error) {
+	bodies := make(chan string, 10)
+	errs := make(chan error, 1)
+	go func() {
+		scanner, err := gobsp.NewScanner(r, gobsp.DefaultHandler(func(ior io.Reader) error {
+			buf, err := ioutil.ReadAll(ior)
+			bodies <- string(buf)
+			return err
+		}))
+		if err != nil {
+			errs <- err
+			return
+		}
+		for scanner.Scan() {
+			if err := scanner.Handle(); err != nil {
+				errs <- err
+				return
+			}
+		}
+		errs <- scanner.Err()
+	}()
+	return bodies, errs
+}
+
+// testReceive waits for the next message body.
+func testReceive(t *testing.T, bodies <-chan string) string {
+	t.Helper()
+	select {
+	case body := <-bodies:
+		return body
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for message")
+		return ""
+	}
+}
+
+// testEnded waits for the scan to end.
+func testEnded(t *testing.T, errs <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-errs:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for scan to end")
+		return nil
+	}
+}
+
+// testWrite appends raw bytes to the named file.
+func testWrite(t *testing.T, name string, buf []byte) {
+	t.Helper()
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFollowWaitsForPartialFrame(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "log")
+	testWrite(t, name, []byte("\x01\x03one"))
+
+	r, err := Open(name, PollInterval(time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bodies, errs := testFollow(t, r)
+	ensure(t, testReceive(t, bodies), "one")
+
+	testWrite(t, name, []byte("\x01")) // header split across writes
+	time.Sleep(10 * time.Millisecond)
+	testWrite(t, name, []byte("\x03tw"))
+	time.Sleep(10 * time.Millisecond)
+	testWrite(t, name, []byte("o"))
+	ensure(t, testReceive(t, bodies), "two")
+
+	ensure(t, r.Close(), nil)
+	ensure(t, testEnded(t, errs), nil)
+}
+
+func TestFollowTruncated(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "log")
+	testWrite(t, name, []byte("\x01\x03one\x01\x03two"))
+
+	r, err := Open(name, PollInterval(time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	bodies, errs := testFollow(t, r)
+	ensure(t, testReceive(t, bodies), "one")
+	ensure(t, testReceive(t, bodies), "two")
+
+	if err = os.Truncate(name, 0); err != nil {
+		t.Fatal(err)
+	}
+	ensure(t, testEnded(t, errs), error(ErrTruncated{}))
+
+	testWrite(t, name, []byte("\x01\x05three"))
+	bodies, errs = testFollow(t, r)
+	ensure(t, testReceive(t, bodies), "three")
+	ensure(t, r.Close(), nil)
+	ensure(t, testEnded(t, errs), nil)
+}
+
+func TestFollowRotated(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "log")
+	testWrite(t, name, []byte("\x01\x03one"))
+
+	r, err := Open(name, PollInterval(time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	bodies, errs := testFollow(t, r)
+	ensure(t, testReceive(t, bodies), "one")
+
+	// Rotate after appending to the old file, which must be read first.
+	testWrite(t, name, []byte("\x01\x03two"))
+	if err = os.Rename(name, filepath.Join(dir, "log.1")); err != nil {
+		t.Fatal(err)
+	}
+	testWrite(t, name, []byte("\x01\x05three"))
+	ensure(t, testReceive(t, bodies), "two")
+	ensure(t, testEnded(t, errs), error(ErrRotated{}))
+
+	bodies, errs = testFollow(t, r)
+	ensure(t, testReceive(t, bodies), "three")
+	ensure(t, r.Close(), nil)
+	ensure(t, testEnded(t, errs), nil)
+}
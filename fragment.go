@@ -0,0 +1,349 @@
+package gobsp
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+)
+
+// Fragment flags, sent in the Uint8 following the message ID of each fragment.
+const (
+	fragmentFirst = 1 << iota // fragment begins a message, and carries its type
+	fragmentLast              // fragment ends a message
+)
+
+// minFrameSize is the smallest frame size that leaves room for a fragment
+// header and some of the message body in every fragment.
+const minFrameSize = 32
+
+// Default limits for a Scanner reassembling fragmented messages.
+const (
+	DefaultMaxReassembledSize = 16 << 20
+	DefaultMaxPartialMessages = 16
+)
+
+// ErrFrameSizeTooSmall is an error that is returned by NewComposerWithOptions
+// when the size specified by MaxFrameSize is too small to hold a fragment.
+type ErrFrameSizeTooSmall int
+
+func (e ErrFrameSizeTooSmall) Error() string {
+	return "frame size too small: " + strconv.Itoa(int(e)) + " < " + strconv.Itoa(minFrameSize)
+}
+
+// ErrMessageTooLarge is an error that is returned when a fragmented message
+// grows larger than the Scanner's MaxReassembledSize.
+type ErrMessageTooLarge UVWI
+
+func (e ErrMessageTooLarge) Error() string {
+	return "fragmented message too large: " + UVWI(e).String()
+}
+
+// ErrTooManyPartialMessages is an error that is returned when the peer
+// interleaves the fragments of more messages than the Scanner's
+// MaxPartialMessages allows.
+type ErrTooManyPartialMessages struct{}
+
+func (e ErrTooManyPartialMessages) Error() string {
+	return "too many partial messages"
+}
+
+// ErrReassemblyBufferFull is an error that is returned when the messages a
+// Scanner buffers while streaming a fragmented message to its handler hold
+// more than the Scanner's MaxReassembledSize bytes.
+type ErrReassemblyBufferFull struct{}
+
+func (e ErrReassemblyBufferFull) Error() string {
+	return "reassembly buffer full"
+}
+
+// ErrUnexpectedFragment is an error that is returned when a fragment does not
+// belong to any message the Scanner is reassembling, or begins a message whose
+// ID is already in use.
+type ErrUnexpectedFragment UVWI
+
+func (e ErrUnexpectedFragment) Error() string {
+	return "unexpected fragment for message: " + UVWI(e).String()
+}
+
+// MaxFrameSize causes a Composer to split any message whose body is larger
+// than the specified size into several MTFragment messages, none of whose
+// bodies is larger than the specified size. Each fragment's body holds the
+// UVWI message ID that ties the fragments together, a Uint8 of flags marking
+// the first and last fragments, the UVWI type of the original message in the
+// first fragment only, then the next piece of the original message body.
+//
+// The receiving Scanner reassembles the fragments, so handlers see the
+// original message.
+func MaxFrameSize(size int) ComposerConfig {
+	return func(w *Composer) error {
+		if size < minFrameSize {
+			return ErrFrameSizeTooSmall(size)
+		}
+		w.maxFrameSize = size
+		return nil
+	}
+}
+
+// MaxReassembledSize limits the size of each fragmented message a Scanner
+// reassembles, and the total size of the messages it buffers while the peer
+// interleaves them with the fragments of the message being streamed to its
+// handler. The default is DefaultMaxReassembledSize.
+func MaxReassembledSize(size int64) ScannerConfig {
+	return func(s *Scanner) error {
+		s.maxReassembledSize = size
+		return nil
+	}
+}
+
+// MaxPartialMessages limits how many fragmented messages a Scanner reassembles
+// at once, when the peer interleaves the fragments of several messages. The
+// default is DefaultMaxPartialMessages.
+func MaxPartialMessages(count int) ScannerConfig {
+	return func(s *Scanner) error {
+		s.maxPartialMessages = count
+		return nil
+	}
+}
+
+// fragments returns the bodies of the fragments, none larger than frameSize,
+// of a message having the specified ID, type, and body.
+func fragments(id UVWI, messageType MessageType, messageBody []byte, frameSize int) [][]byte {
+	var bodies [][]byte
+	for first := true; ; first = false {
+		header := new(bytes.Buffer)
+		_ = id.MarshalBinaryTo(header)
+		var flags Uint8
+		var typ bytes.Buffer
+		if first {
+			flags = fragmentFirst
+			_ = UVWI(messageType).MarshalBinaryTo(&typ)
+		}
+		chunk := len(messageBody)
+		if room := frameSize - header.Len() - 1 - typ.Len(); chunk > room {
+			chunk = room
+		} else {
+			flags |= fragmentLast
+		}
+		_ = flags.MarshalBinaryTo(header)
+		header.Write(typ.Bytes())
+		header.Write(messageBody[:chunk])
+		bodies = append(bodies, header.Bytes())
+		messageBody = messageBody[chunk:]
+		if flags&fragmentLast != 0 {
+			return bodies
+		}
+	}
+}
+
+// writeFragments writes a message too large for a single frame as several
+// fragments, and optionally flushes them.
+func (w *Composer) writeFragments(messageType MessageType, messageBody []byte, flush bool) error {
+	if w.queue != nil {
+		bodies := fragments(w.fragmentID(), messageType, messageBody, w.maxFrameSize)
+		for i, body := range bodies {
+			if err := w.enqueue(frame(MTFragment, body), flush && i == len(bodies)-1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	id := w.nextFragmentID
+	w.nextFragmentID++
+	for _, body := range fragments(id, messageType, messageBody, w.maxFrameSize) {
+		if err := w.compose(MTFragment, body); err != nil {
+			return err
+		}
+	}
+	return w.flushPolicy(flush)
+}
+
+// fragmentID returns a new ID for a fragmented message.
+func (w *Composer) fragmentID() UVWI {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	id := w.nextFragmentID
+	w.nextFragmentID++
+	return id
+}
+
+// partialMessage is a fragmented message being reassembled, or a whole message
+// received while streaming a fragmented message to its handler.
+type partialMessage struct {
+	messageType UVWI
+	buffered    bytes.Buffer // received bytes not yet read by the handler
+	size        int64        // total bytes received
+	last        bool         // true once every byte has been received
+	offset      int64        // stream offset of the frame that began the message
+}
+
+// fragmentReader streams the body of the Scanner's current partial message,
+// receiving its remaining fragments from the stream as they are needed.
+type fragmentReader struct {
+	s *Scanner
+}
+
+func (fr fragmentReader) Read(buf []byte) (int, error) {
+	s := fr.s
+	if s.err != nil {
+		return 0, s.err
+	}
+	m := s.current
+	for {
+		if m.buffered.Len() > 0 {
+			return m.buffered.Read(buf)
+		}
+		if s.chunk != nil {
+			n, err := s.chunk.Read(buf)
+			if n > 0 {
+				return n, nil
+			}
+			if err != io.EOF {
+				s.err = err
+				return 0, err
+			}
+			if s.chunk.N > 0 {
+				s.err = io.ErrUnexpectedEOF
+				return 0, s.err
+			}
+			s.chunk = nil
+		}
+		if m.last {
+			return 0, io.EOF
+		}
+		if s.err = s.receiveFragment(); s.err != nil {
+			return 0, s.err
+		}
+	}
+}
+
+// startFragment reads the fragment header from the current MTFragment message,
+// returning the message it begins. Scan calls it when no message is being
+// streamed, so the fragment must begin a message.
+func (s *Scanner) startFragment() (*partialMessage, error) {
+	lr := &io.LimitedReader{R: s.source(), N: int64(s.messageSize)}
+	var id UVWI
+	var flags Uint8
+	if err := s.readFragmentHeader(lr, &id, &flags); err != nil {
+		return nil, err
+	}
+	if flags&fragmentFirst == 0 {
+		return nil, ErrUnexpectedFragment(id)
+	}
+	m := &partialMessage{offset: s.headerOffset}
+	if err := m.messageType.UnmarshalBinaryFrom(lr); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if err := s.receive(m, id, flags, lr); err != nil {
+		return nil, err
+	}
+	s.current, s.chunk = m, lr
+	return m, nil
+}
+
+// receiveFragment reads the next message from the stream while streaming the
+// current partial message. A fragment of the current message becomes the next
+// chunk to read, while anything else is buffered to be returned by a later
+// Scan.
+func (s *Scanner) receiveFragment() error {
+	messageType, messageSize, err := s.readHeader()
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+	lr := &io.LimitedReader{R: s.source(), N: int64(messageSize)}
+	if MessageType(messageType) != MTFragment {
+		m := &partialMessage{messageType: messageType, size: lr.N, last: true, offset: s.headerOffset}
+		if m.size > s.maxReassembledSize {
+			return ErrMessageTooLarge(m.messageType)
+		}
+		s.enqueuePartial(m)
+		return s.buffer(m, lr)
+	}
+
+	var id UVWI
+	var flags Uint8
+	if err := s.readFragmentHeader(lr, &id, &flags); err != nil {
+		return err
+	}
+	m := s.partials[id]
+	if flags&fragmentFirst != 0 {
+		if m != nil {
+			return ErrUnexpectedFragment(id)
+		}
+		m = &partialMessage{offset: s.headerOffset}
+		if err := m.messageType.UnmarshalBinaryFrom(lr); err != nil {
+			return unexpectedEOF(err)
+		}
+		s.enqueuePartial(m)
+	} else if m == nil {
+		return ErrUnexpectedFragment(id)
+	}
+	if err := s.receive(m, id, flags, lr); err != nil {
+		return err
+	}
+	if m == s.current {
+		s.chunk = lr
+		return nil
+	}
+	return s.buffer(m, lr)
+}
+
+// readFragmentHeader reads the message ID and flags of a fragment.
+func (s *Scanner) readFragmentHeader(lr io.Reader, id *UVWI, flags *Uint8) error {
+	if err := id.UnmarshalBinaryFrom(lr); err != nil {
+		return unexpectedEOF(err)
+	}
+	return unexpectedEOF(flags.UnmarshalBinaryFrom(lr))
+}
+
+// receive accounts for a fragment of the specified message, whose remaining
+// bytes are to be read from lr.
+func (s *Scanner) receive(m *partialMessage, id UVWI, flags Uint8, lr *io.LimitedReader) error {
+	if m.size += lr.N; m.size > s.maxReassembledSize {
+		return ErrMessageTooLarge(m.messageType)
+	}
+	if flags&fragmentLast != 0 {
+		m.last = true
+		delete(s.partials, id)
+	} else if flags&fragmentFirst != 0 {
+		if len(s.partials) >= s.maxPartialMessages {
+			return ErrTooManyPartialMessages{}
+		}
+		if s.partials == nil {
+			s.partials = make(map[UVWI]*partialMessage)
+		}
+		s.partials[id] = m
+	}
+	return nil
+}
+
+// enqueuePartial queues a message received while streaming the current
+// message, so it is returned by a later Scan.
+func (s *Scanner) enqueuePartial(m *partialMessage) {
+	s.ready = append(s.ready, m)
+}
+
+// buffer reads the remainder of a message's fragment from lr into the
+// message's buffer.
+func (s *Scanner) buffer(m *partialMessage, lr *io.LimitedReader) error {
+	if s.buffered += lr.N; s.buffered > s.maxReassembledSize {
+		return ErrReassemblyBufferFull{}
+	}
+	if _, err := m.buffered.ReadFrom(lr); err != nil {
+		return err
+	}
+	if lr.N > 0 {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
+// finishPartial discards whatever the handler did not read of the current
+// partial message.
+func (s *Scanner) finishPartial() {
+	if err := DiscardAll(fragmentReader{s}); err != nil && s.err == nil {
+		s.err = err
+	}
+	s.current, s.chunk = nil, nil
+}
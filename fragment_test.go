@@ -0,0 +1,206 @@
+package gobsp
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+// testFragment returns an MTFragment frame having the specified fields. The
+// message type is only encoded when flags marks the first fragment.
+func testFragment(id UVWI, flags Uint8, messageType MessageType, chunk string) []byte {
+	bb := new(bytes.Buffer)
+	_ = id.MarshalBinaryTo(bb)
+	_ = flags.MarshalBinaryTo(bb)
+	if flags&fragmentFirst != 0 {
+		_ = UVWI(messageType).MarshalBinaryTo(bb)
+	}
+	bb.WriteString(chunk)
+	return frame(MTFragment, bb.Bytes())
+}
+
+func TestFragmentRoundTrip(t *testing.T) {
+	bb := new(bytes.Buffer)
+	w, err := NewComposerWithOptions(bb, MaxFrameSize(32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	large := string(bytes.Repeat([]byte("0123456789"), 100))
+	ensure(t, w.Compose(1, []byte("small")), nil)
+	ensure(t, w.Compose(2, []byte(large)), nil)
+	ensure(t, w.Compose(3, bytes.Repeat([]byte("x"), 32)), nil)
+	ensure(t, w.Close(), nil)
+
+	// Every frame on the wire fits within the maximum frame size.
+	br := bytes.NewReader(bb.Bytes())
+	var frames int
+	for br.Len() > 0 {
+		var messageType, messageSize UVWI
+		ensure(t, messageType.UnmarshalBinaryFrom(br), nil)
+		ensure(t, messageSize.UnmarshalBinaryFrom(br), nil)
+		if messageSize > 32 {
+			t.Errorf("Actual: %d; Expected: frame size at most 32", messageSize)
+		}
+		_, _ = br.Seek(int64(messageSize), io.SeekCurrent)
+		frames++
+	}
+	if frames < 30 {
+		t.Errorf("Actual: %d frames; Expected: large message fragmented", frames)
+	}
+
+	received := testScanAll(t, bb)
+	ensure(t, len(received), 3)
+	ensure(t, received[0], "1:small")
+	ensure(t, received[1], "2:"+large)
+	ensure(t, received[2], "3:"+string(bytes.Repeat([]byte("x"), 32)))
+}
+
+func TestFragmentInterleaved(t *testing.T) {
+	bb := new(bytes.Buffer)
+	bb.Write(testFragment(0, fragmentFirst, 1, "ab"))
+	bb.Write(testFragment(1, fragmentFirst, 2, "xy"))
+	bb.Write(frame(3, []byte("z")))
+	bb.Write(testFragment(0, fragmentLast, 0, "cd"))
+	bb.Write(testFragment(1, 0, 0, "w"))
+	bb.Write(testFragment(1, fragmentLast, 0, "v"))
+	bb.Write(frame(4, nil))
+
+	received := testScanAll(t, bb)
+	ensure(t, len(received), 4)
+	ensure(t, received[0], "1:abcd")
+	ensure(t, received[1], "2:xywv")
+	ensure(t, received[2], "3:z")
+	ensure(t, received[3], "4:")
+}
+
+func TestFragmentStreamsToHandler(t *testing.T) {
+	pr, pw := io.Pipe()
+	started := make(chan struct{})
+	go func() {
+		_, _ = pw.Write(testFragment(0, fragmentFirst, 1, "abc"))
+		<-started // handler has read the first fragment before the rest is sent
+		_, _ = pw.Write(testFragment(0, fragmentLast, 0, "def"))
+		_ = pw.Close()
+	}()
+
+	var body []byte
+	scanner, err := NewScanner(pr, DefaultHandler(func(ior io.Reader) error {
+		buf := make([]byte, 3)
+		if _, err := io.ReadFull(ior, buf); err != nil {
+			return err
+		}
+		close(started)
+		rest, err := io.ReadAll(ior)
+		body = append(buf, rest...)
+		return err
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ensure(t, scanner.Scan(), true)
+		ensure(t, scanner.Handle(), nil)
+		ensure(t, scanner.Scan(), false)
+		ensure(t, scanner.Err(), nil)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("scanner waited for the whole message before calling the handler")
+	}
+	ensure(t, string(body), "abcdef")
+}
+
+func TestFragmentUnhandledMessageSkipped(t *testing.T) {
+	bb := new(bytes.Buffer)
+	bb.Write(testFragment(0, fragmentFirst, 1, "ab"))
+	bb.Write(testFragment(0, fragmentLast, 0, "cd"))
+	bb.Write(frame(2, []byte("e")))
+
+	scanner, err := NewScanner(bb, DefaultHandler(DiscardAll))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ensure(t, scanner.Scan(), true)
+	ensure(t, scanner.Scan(), true) // skips the rest of the fragmented message
+	ensure(t, scanner.messageType, UVWI(2))
+	ensure(t, scanner.Handle(), nil)
+	ensure(t, scanner.Scan(), false)
+	ensure(t, scanner.Err(), nil)
+}
+
+func TestFragmentMaxReassembledSize(t *testing.T) {
+	bb := new(bytes.Buffer)
+	bb.Write(testFragment(0, fragmentFirst, 1, "abc"))
+	bb.Write(testFragment(0, fragmentLast, 0, "def"))
+
+	scanner, err := NewScanner(bb, DefaultHandler(DiscardAll), MaxReassembledSize(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ensure(t, scanner.Scan(), true)
+	ensure(t, scanner.Handle(), error(ErrMessageTooLarge(1)))
+	ensure(t, scanner.Scan(), false)
+	ensure(t, scanner.Err(), error(ErrMessageTooLarge(1)))
+}
+
+func TestFragmentMaxPartialMessages(t *testing.T) {
+	bb := new(bytes.Buffer)
+	bb.Write(testFragment(0, fragmentFirst, 1, "a"))
+	bb.Write(testFragment(1, fragmentFirst, 2, "b"))
+	bb.Write(testFragment(2, fragmentFirst, 3, "c"))
+
+	scanner, err := NewScanner(bb, DefaultHandler(DiscardAll), MaxPartialMessages(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ensure(t, scanner.Scan(), true)
+	ensure(t, scanner.Handle(), error(ErrTooManyPartialMessages{}))
+}
+
+func TestFragmentUnexpected(t *testing.T) {
+	scanner, err := NewScanner(bytes.NewReader(testFragment(5, fragmentLast, 0, "a")), DefaultHandler(DiscardAll))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ensure(t, scanner.Scan(), false)
+	ensure(t, scanner.Err(), error(ErrUnexpectedFragment(5)))
+}
+
+func TestFragmentTruncated(t *testing.T) {
+	buf := testFragment(0, fragmentFirst, 1, "abcdef")
+	scanner, err := NewScanner(bytes.NewReader(buf[:len(buf)-2]), DefaultHandler(DiscardAll))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ensure(t, scanner.Scan(), true)
+	ensure(t, scanner.Handle(), io.ErrUnexpectedEOF)
+}
+
+func TestMaxFrameSizeTooSmall(t *testing.T) {
+	_, err := NewComposerWithOptions(new(bytes.Buffer), MaxFrameSize(8))
+	ensure(t, err, error(ErrFrameSizeTooSmall(8)))
+}
+
+func TestComposerWriteQueueFragmentsConcurrent(t *testing.T) {
+	testConcurrentCompose(t, WriteQueue(64), MaxFrameSize(64))
+}
+
+func TestFragmentReassemblyBufferFull(t *testing.T) {
+	bb := new(bytes.Buffer)
+	bb.Write(testFragment(0, fragmentFirst, 1, "a"))
+	bb.Write(frame(2, []byte("bcd")))
+	bb.Write(frame(3, []byte("efg")))
+	bb.Write(testFragment(0, fragmentLast, 0, "h"))
+
+	scanner, err := NewScanner(bb, DefaultHandler(DiscardAll), MaxReassembledSize(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ensure(t, scanner.Scan(), true)
+	ensure(t, scanner.Handle(), error(ErrReassemblyBufferFull{}))
+}
@@ -0,0 +1,312 @@
+package gobsp
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+// Decoders read untrusted input, so the fuzz targets below check that they
+// neither panic nor allocate much more memory than their input could justify,
+// and that whatever they decode encodes back to an equal value. That is why
+// String and StringSlice preallocate at most maxPreallocate bytes, growing
+// larger values as their bytes arrive, rather than trusting a size that may be
+// corrupt or hostile. Run one with, for example:
+//
+//	go test -run '^$' -fuzz FuzzScanner
+
+// Bounds on the memory a decoder may allocate: fuzzAllocPerByte for each byte of
+// input, plus fuzzAllocFixed for buffers and decompressors of a fixed size.
+const (
+	fuzzAllocPerByte = 256
+	fuzzAllocFixed   = 1 << 20
+)
+
+// allocated returns the number of bytes allocated while running f.
+func allocated(f func()) uint64 {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	f()
+	runtime.ReadMemStats(&after)
+	return after.TotalAlloc - before.TotalAlloc
+}
+
+// ensureBounded fails the test when running f allocates more memory than its
+// input of the specified size could justify.
+func ensureBounded(t *testing.T, size int, f func()) {
+	t.Helper()
+	if n, limit := allocated(f), uint64(size)*fuzzAllocPerByte+fuzzAllocFixed; n > limit {
+		t.Fatalf("decoding %d bytes allocated %d bytes; limit %d", size, n, limit)
+	}
+}
+
+// fuzzSeeds adds the encodings from the conformance corpus to the seed corpus
+// of a fuzz target, by calling add with the primitive type, which is empty for
+// streams, and bytes of each.
+func fuzzSeeds(f *testing.F, add func(typ string, buf []byte)) {
+	f.Helper()
+	c := loadConformance(f)
+	for _, p := range c.Primitives {
+		if buf, err := hex.DecodeString(p.Bytes); err == nil {
+			add(p.Type, buf)
+		}
+	}
+	for _, s := range c.Streams {
+		if buf, err := hex.DecodeString(s.Bytes); err == nil {
+			add("", buf)
+		}
+	}
+}
+
+// primitiveTypes are the names of the primitives FuzzDecode selects among.
+var primitiveTypes = []string{
+	"Int8", "Uint8", "Int16", "Uint16", "Int32", "Uint32", "Int64", "Uint64",
+	"VWI", "UVWI", "Float32", "Float64", "String", "StringSlice",
+}
+
+func FuzzDecode(f *testing.F) {
+	fuzzSeeds(f, func(typ string, buf []byte) {
+		for i, name := range primitiveTypes {
+			if name == typ {
+				f.Add(uint8(i), buf)
+			}
+		}
+	})
+	f.Add(uint8(12), []byte{0xFF, 0xFF, 0xFF, 0xFF, 0x0F, 'a'})        // String longer than its input
+	f.Add(uint8(13), []byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0x0F, 0x00}) // StringSlice longer than its input
+
+	f.Fuzz(func(t *testing.T, kind uint8, buf []byte) {
+		typ := primitiveTypes[int(kind)%len(primitiveTypes)]
+		v, err := newPrimitive(typ)
+		if err != nil {
+			t.Fatal(err)
+		}
+		br := bytes.NewReader(buf)
+		ensureBounded(t, len(buf), func() { err = v.UnmarshalBinaryFrom(br) })
+		if err != nil {
+			return
+		}
+
+		// Fixed width primitives have a single encoding. Variable width integers
+		// may be padded, so only their values must survive re-encoding.
+		consumed := buf[:len(buf)-br.Len()]
+		bb := new(bytes.Buffer)
+		if err = v.MarshalBinaryTo(bb); err != nil {
+			t.Fatal(err)
+		}
+		switch typ {
+		case "VWI", "UVWI", "String", "StringSlice":
+			if bb.Len() > len(consumed) {
+				t.Fatalf("%s: re-encoded %x from %x", typ, bb.Bytes(), consumed)
+			}
+		default:
+			if !bytes.Equal(bb.Bytes(), consumed) {
+				t.Fatalf("%s: re-encoded %x from %x", typ, bb.Bytes(), consumed)
+			}
+		}
+		w, _ := newPrimitive(typ)
+		if err = w.UnmarshalBinaryFrom(bb); err != nil {
+			t.Fatal(err)
+		}
+		if !samePrimitive(v, w) {
+			t.Fatalf("%s: decoded %v, then %v", typ, v, w)
+		}
+	})
+}
+
+// roundTrip encodes v, then decodes it into w, and fails the test unless they
+// are equal.
+func roundTrip(t *testing.T, v, w Binary) {
+	t.Helper()
+	bb := new(bytes.Buffer)
+	if err := v.MarshalBinaryTo(bb); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.UnmarshalBinaryFrom(bb); err != nil {
+		t.Fatalf("%T: %s", v, err)
+	}
+	if bb.Len() != 0 {
+		t.Fatalf("%T: %d bytes left", v, bb.Len())
+	}
+	if !samePrimitive(v, w) {
+		t.Fatalf("%T: Actual: %v; Expected: %v", v, w, v)
+	}
+}
+
+func FuzzRoundTripIntegers(f *testing.F) {
+	for _, v := range []int64{0, 1, -1, 63, -64, 127, 128, 1 << 32, math.MaxInt64, math.MinInt64} {
+		f.Add(v)
+	}
+	f.Fuzz(func(t *testing.T, v int64) {
+		i8, u8, i16, u16 := Int8(v), Uint8(v), Int16(v), Uint16(v)
+		i32, u32, i64, u64 := Int32(v), Uint32(v), Int64(v), Uint64(v)
+		vwi, uvwi := VWI(v), UVWI(v)
+		roundTrip(t, &i8, new(Int8))
+		roundTrip(t, &u8, new(Uint8))
+		roundTrip(t, &i16, new(Int16))
+		roundTrip(t, &u16, new(Uint16))
+		roundTrip(t, &i32, new(Int32))
+		roundTrip(t, &u32, new(Uint32))
+		roundTrip(t, &i64, new(Int64))
+		roundTrip(t, &u64, new(Uint64))
+		roundTrip(t, &vwi, new(VWI))
+		roundTrip(t, &uvwi, new(UVWI))
+	})
+}
+
+func FuzzRoundTripFloats(f *testing.F) {
+	for _, v := range []float64{0, math.Copysign(0, -1), 1.5, math.MaxFloat64, math.SmallestNonzeroFloat64, math.Inf(-1), math.NaN()} {
+		f.Add(v)
+	}
+	f.Fuzz(func(t *testing.T, v float64) {
+		f32, f64 := Float32(v), Float64(v)
+		roundTrip(t, &f32, new(Float32))
+		roundTrip(t, &f64, new(Float64))
+		bb := new(bytes.Buffer)
+		_ = f64.MarshalBinaryTo(bb)
+		var g Float64
+		_ = g.UnmarshalBinaryFrom(bb)
+		if math.Float64bits(float64(g)) != math.Float64bits(v) {
+			t.Fatalf("Float64 bits: Actual: %x; Expected: %x", math.Float64bits(float64(g)), math.Float64bits(v))
+		}
+	})
+}
+
+func FuzzRoundTripStrings(f *testing.F) {
+	f.Add("", "")
+	f.Add("hello", "héllo 世界")
+	f.Add(string(bytes.Repeat([]byte{0xFF}, 300)), "\x00")
+	f.Fuzz(func(t *testing.T, a, b string) {
+		s := String(a)
+		roundTrip(t, &s, new(String))
+		ss := StringSlice{String(a), String(b)}
+		roundTrip(t, &ss, new(StringSlice))
+	})
+}
+
+func FuzzStructured(f *testing.F) {
+	fuzzSeeds(f, func(typ string, buf []byte) {
+		for kind := uint8(0); kind < 7; kind++ {
+			f.Add(kind, buf)
+		}
+	})
+	f.Fuzz(func(t *testing.T, kind uint8, buf []byte) {
+		var v, w Binary
+		switch kind % 7 {
+		case 0:
+			v, w = new(Preamble), new(Preamble)
+		case 1:
+			v, w = new(Capabilities), new(Capabilities)
+		case 2:
+			v, w = new(HelloMessage), new(HelloMessage)
+		case 3:
+			v, w = new(ErrorMessage), new(ErrorMessage)
+		case 4:
+			v, w = new(CloseMessage), new(CloseMessage)
+		case 5:
+			v, w = new(PingMessage), new(PingMessage)
+		case 6:
+			v, w = new(PongMessage), new(PongMessage)
+		}
+		var err error
+		ensureBounded(t, len(buf), func() { err = v.UnmarshalBinaryFrom(bytes.NewReader(buf)) })
+		if err != nil {
+			return
+		}
+		bb := new(bytes.Buffer)
+		if err = v.MarshalBinaryTo(bb); err != nil {
+			t.Fatal(err)
+		}
+		if err = w.UnmarshalBinaryFrom(bb); err != nil {
+			t.Fatalf("%T: %s", v, err)
+		}
+		if !reflect.DeepEqual(v, w) {
+			t.Fatalf("%T: decoded %#v, then %#v", v, v, w)
+		}
+	})
+}
+
+// errFuzzHandler is returned by a handler FuzzScanner configures to fail.
+var errFuzzHandler = errors.New("handler failed")
+
+// FuzzScanner scans arbitrary streams, selecting the scanner's configuration,
+// and how its handler behaves, from the bits of behavior.
+func FuzzScanner(f *testing.F) {
+	fuzzSeeds(f, func(typ string, buf []byte) {
+		if typ == "" {
+			for _, behavior := range []uint8{0x00, 0x01, 0x02, 0x04, 0x06, 0x08, 0x10} {
+				f.Add(behavior, buf)
+			}
+		}
+	})
+	bb := new(bytes.Buffer)
+	w := NewComposer(bb)
+	for i := 0; i < 100; i++ { // each batch must not cost a new decompressor
+		b := w.CompressedBatch()
+		if err := b.Add(1, nil); err != nil {
+			f.Fatal(err)
+		}
+		if err := b.Send(); err != nil {
+			f.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		f.Fatal(err)
+	}
+	f.Add(uint8(0), bb.Bytes())
+
+	f.Fuzz(func(t *testing.T, behavior uint8, buf []byte) {
+		var scratch [16]byte
+		handler := func(ior io.Reader) error {
+			switch behavior >> 1 & 3 {
+			case 0:
+				return DiscardAll(ior)
+			case 1:
+				return nil // leaves the body for the scanner to discard
+			case 2:
+				_, err := ior.Read(scratch[:1])
+				if err == io.EOF {
+					err = nil
+				}
+				return err
+			}
+			return errFuzzHandler
+		}
+		configurators := []ScannerConfig{DefaultHandler(handler), MaxReassembledSize(1 << 16), MaxPartialMessages(4)}
+		if behavior&0x01 != 0 {
+			configurators = append(configurators, Raw())
+		}
+		if behavior&0x10 != 0 {
+			configurators = append(configurators, CheckPreamble(func(Preamble) error { return nil }))
+		}
+		if behavior&0x20 != 0 {
+			// A handler for a reserved type overrides the scanner's own.
+			configurators = append(configurators, Handlers(map[uint32]MessageHandler{uint32(MTError): handler}))
+		}
+
+		s, err := NewScanner(bytes.NewReader(buf), configurators...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var offset int64
+		ensureBounded(t, len(buf), func() {
+			for s.Scan() {
+				if s.Offset() < offset || s.Offset() > int64(len(buf)) {
+					t.Fatalf("offset %d after %d in %d bytes", s.Offset(), offset, len(buf))
+				}
+				offset = s.Offset()
+				if behavior&0x08 != 0 {
+					continue // does not handle the message
+				}
+				if err := s.Handle(); err != nil {
+					break
+				}
+			}
+		})
+	})
+}
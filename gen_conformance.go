@@ -0,0 +1,361 @@
+//go:build ignore
+// +build ignore
+
+// gen_conformance writes testdata/conformance.json, the corpus of encodings that
+// every gobsp implementation must agree on. Its expected bytes are produced by
+// this library, so extend the corpus by adding cases below, then run:
+//
+//	go generate
+//
+// and review the change to testdata/conformance.json before committing it.
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/karrick/gobsp"
+)
+
+type corpus struct {
+	Description []string        `json:"description"`
+	Primitives  []primitiveCase `json:"primitives"`
+	Streams     []streamCase    `json:"streams"`
+}
+
+type primitiveCase struct {
+	Type       string          `json:"type"`
+	Value      json.RawMessage `json:"value,omitempty"`
+	Bytes      string          `json:"bytes"`
+	DecodeOnly bool            `json:"decode_only,omitempty"`
+	Error      string          `json:"error,omitempty"`
+}
+
+type preambleCase struct {
+	Version     uint8  `json:"version"`
+	Features    string `json:"features"`
+	Application string `json:"application"`
+}
+
+type messageCase struct {
+	Type string `json:"type"`
+	Body string `json:"body"`
+}
+
+type streamCase struct {
+	Name           string        `json:"name"`
+	Preamble       *preambleCase `json:"preamble,omitempty"`
+	AcceptFeatures string        `json:"accept_features,omitempty"`
+	Bytes          string        `json:"bytes"`
+	Messages       []messageCase `json:"messages"`
+	Compose        bool          `json:"compose,omitempty"`
+	Error          string        `json:"error,omitempty"`
+}
+
+var description = []string{
+	"Conformance vectors for the gobsp encodings, generated by gen_conformance.go.",
+	"Bytes and bodies are hexadecimal. Integer values, message types, and features are decimal strings,",
+	"because not every JSON parser preserves 64-bit integers. Float values are decimal strings, or NaN, +Inf, or -Inf.",
+	"String values are JSON strings, and StringSlice values are arrays of JSON strings.",
+	"primitives: encoding value must yield bytes, and decoding bytes must yield value, consuming every byte.",
+	"When decode_only is true, only decoding is checked. When error is set, decoding bytes must fail with that error.",
+	"streams: reading bytes must deliver messages to the application, in order, then fail with error when set.",
+	"Heartbeats are skipped, batches unpacked, fragments reassembled, and a Close message ends the stream.",
+	"When preamble is set, the reader expects a preamble naming the application, and supporting accept_features,",
+	"which defaults to the preamble's features. When compose is true, writing the preamble, if any, and then each",
+	"message as a single frame must yield bytes.",
+	"Errors: truncated (the input ends part way through a value, frame, or preamble), not-gobsp (bad preamble magic),",
+	"overflow (a variable width integer holds more than 64 bits), unsupported-version (preamble version greater than 1),",
+	"unsupported-features (preamble declares features not accepted).",
+}
+
+func main() {
+	c := corpus{Description: description, Primitives: primitives(), Streams: streams()}
+	buf, err := json.MarshalIndent(c, "", "\t")
+	if err == nil {
+		err = ioutil.WriteFile("testdata/conformance.json", append(buf, '\n'), 0o644)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gen_conformance: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// marshaler is implemented by the values of every primitive.
+type marshaler interface {
+	MarshalBinaryTo(io.Writer) error
+}
+
+// encode returns the hexadecimal encoding of a primitive.
+func encode(v marshaler) string {
+	bb := new(bytes.Buffer)
+	if err := v.MarshalBinaryTo(bb); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(bb.Bytes())
+}
+
+// value returns a JSON value, encoding strings as JSON strings.
+func value(v interface{}) json.RawMessage {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return buf
+}
+
+// float formats a floating point number as the corpus does.
+func float(f float64, bitSize int) json.RawMessage {
+	if math.Signbit(f) && f == 0 {
+		return value("-0")
+	}
+	return value(strconv.FormatFloat(f, 'g', -1, bitSize))
+}
+
+func primitives() []primitiveCase {
+	var cases []primitiveCase
+	add := func(typ string, v json.RawMessage, b marshaler) {
+		cases = append(cases, primitiveCase{Type: typ, Value: v, Bytes: encode(b)})
+	}
+	signed := func(typ string, b func(int64) marshaler, values ...int64) {
+		for _, v := range values {
+			add(typ, value(strconv.FormatInt(v, 10)), b(v))
+		}
+	}
+	unsigned := func(typ string, b func(uint64) marshaler, values ...uint64) {
+		for _, v := range values {
+			add(typ, value(strconv.FormatUint(v, 10)), b(v))
+		}
+	}
+
+	signed("Int8", func(v int64) marshaler { return gobsp.Int8(v) }, 0, 1, -1, math.MaxInt8, math.MinInt8)
+	unsigned("Uint8", func(v uint64) marshaler { return gobsp.Uint8(v) }, 0, 1, math.MaxUint8)
+	signed("Int16", func(v int64) marshaler { return gobsp.Int16(v) }, 0, -1, 0x0102, math.MaxInt16, math.MinInt16)
+	unsigned("Uint16", func(v uint64) marshaler { return gobsp.Uint16(v) }, 0, 0x0102, math.MaxUint16)
+	signed("Int32", func(v int64) marshaler { return gobsp.Int32(v) }, 0, -1, 0x01020304, math.MaxInt32, math.MinInt32)
+	unsigned("Uint32", func(v uint64) marshaler { return gobsp.Uint32(v) }, 0, 0x01020304, math.MaxUint32)
+	signed("Int64", func(v int64) marshaler { return gobsp.Int64(v) }, 0, -1, 0x0102030405060708, math.MaxInt64, math.MinInt64)
+	unsigned("Uint64", func(v uint64) marshaler { return gobsp.Uint64(v) }, 0, 0x0102030405060708, math.MaxUint64)
+	signed("VWI", func(v int64) marshaler { return gobsp.VWI(v) }, 0, 1, -1, 63, -64, 64, -65, 8191, 8192, math.MaxInt64, math.MinInt64)
+	unsigned("UVWI", func(v uint64) marshaler { return gobsp.UVWI(v) }, 0, 1, 127, 128, 300, 16383, 16384, uint64(gobsp.MinReservedMessageType), math.MaxUint64)
+
+	for _, v := range []float64{0, math.Copysign(0, -1), 1, -1.5, 0.1, math.MaxFloat32, math.SmallestNonzeroFloat32, math.Inf(1), math.Inf(-1)} {
+		add("Float32", float(v, 32), gobsp.Float32(v))
+	}
+	for _, v := range []float64{0, math.Copysign(0, -1), 1, -1.5, 0.1, math.MaxFloat64, math.SmallestNonzeroFloat64, math.Inf(1), math.Inf(-1)} {
+		add("Float64", float(v, 64), gobsp.Float64(v))
+	}
+	// NaN has many encodings, so only decoding the canonical quiet NaN is checked.
+	cases = append(cases,
+		primitiveCase{Type: "Float32", Value: value("NaN"), Bytes: "7fc00000", DecodeOnly: true},
+		primitiveCase{Type: "Float64", Value: value("NaN"), Bytes: "7ff8000000000000", DecodeOnly: true},
+	)
+
+	for _, v := range []string{"", "a", "hello, world", "héllo 世界", strings.Repeat("x", 130)} {
+		add("String", value(v), gobsp.String(v))
+	}
+	for _, v := range [][]string{{}, {""}, {"a", "bc"}} {
+		ss := make(gobsp.StringSlice, len(v))
+		for i, s := range v {
+			ss[i] = gobsp.String(s)
+		}
+		add("StringSlice", value(v), ss)
+	}
+
+	for _, c := range []struct{ typ, bytes string }{
+		{"Int8", ""},
+		{"Uint16", "01"},
+		{"Int32", "010203"},
+		{"Uint64", "01020304050607"},
+		{"Float32", "3f80"},
+		{"Float64", "3ff00000"},
+		{"VWI", "ff"},
+		{"UVWI", ""},
+		{"UVWI", "8080"},
+		{"String", "05616263"},
+		{"String", "80"},
+		{"StringSlice", "020161"},
+	} {
+		cases = append(cases, primitiveCase{Type: c.typ, Bytes: c.bytes, Error: "truncated"})
+	}
+	for _, c := range []struct{ typ, bytes string }{
+		{"UVWI", "ffffffffffffffffff02"},
+		{"UVWI", "8080808080808080808000"},
+		{"VWI", "ffffffffffffffffff7f"},
+	} {
+		cases = append(cases, primitiveCase{Type: c.typ, Bytes: c.bytes, Error: "overflow"})
+	}
+	return cases
+}
+
+// stream returns the hexadecimal encoding of the stream written by the
+// specified function.
+func stream(write func(w *gobsp.Composer) error, configurators ...gobsp.ComposerConfig) string {
+	bb := new(bytes.Buffer)
+	w, err := gobsp.NewComposerWithOptions(bb, configurators...)
+	if err != nil {
+		panic(err)
+	}
+	if err = write(w); err != nil {
+		panic(err)
+	}
+	if err = w.Close(); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(bb.Bytes())
+}
+
+// composed returns a stream case whose bytes are its messages, each composed as
+// a single frame, following the preamble, if any.
+func composed(name string, p *preambleCase, messages ...messageCase) streamCase {
+	var configurators []gobsp.ComposerConfig
+	if p != nil {
+		features, err := strconv.ParseUint(p.Features, 10, 64)
+		if err != nil {
+			panic(err)
+		}
+		configurators = append(configurators, gobsp.WritePreamble(p.Application, gobsp.Feature(features)))
+	}
+	b := stream(func(w *gobsp.Composer) error {
+		for _, m := range messages {
+			mt, body := m.decode()
+			if err := w.Compose(mt, body); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, configurators...)
+	if messages == nil {
+		messages = []messageCase{}
+	}
+	return streamCase{Name: name, Preamble: p, Bytes: b, Messages: messages, Compose: true}
+}
+
+// message returns a message case.
+func message(mt gobsp.MessageType, body string) messageCase {
+	return messageCase{Type: strconv.FormatUint(uint64(mt), 10), Body: hex.EncodeToString([]byte(body))}
+}
+
+func (m messageCase) decode() (gobsp.MessageType, []byte) {
+	mt, err := strconv.ParseUint(m.Type, 10, 64)
+	if err != nil {
+		panic(err)
+	}
+	body, err := hex.DecodeString(m.Body)
+	if err != nil {
+		panic(err)
+	}
+	return gobsp.MessageType(mt), body
+}
+
+func streams() []streamCase {
+	long := strings.Repeat("0123456789", 20)
+	chat := &preambleCase{Version: gobsp.FramingVersion, Features: "0", Application: "chat"}
+	featured := &preambleCase{Version: gobsp.FramingVersion, Features: "3", Application: "chat"}
+
+	cases := []streamCase{
+		composed("empty stream", nil),
+		composed("single message", nil, message(1, "abc")),
+		composed("empty body", nil, message(2, "")),
+		composed("several messages", nil, message(1, "a"), message(2, "bc"), message(1, "")),
+		composed("multiple byte type and size", nil, message(300, long)),
+		composed("large message type", nil, message(1<<40, "x")),
+		composed("preamble", chat, message(1, "hi")),
+		composed("preamble only", chat),
+		composed("preamble with features", featured, message(1, "hi")),
+	}
+
+	cases = append(cases, streamCase{
+		Name: "heartbeats are skipped",
+		Bytes: stream(func(w *gobsp.Composer) error {
+			if err := w.Compose(gobsp.MTHeartbeat, nil); err != nil {
+				return err
+			}
+			return w.Compose(1, []byte("abc"))
+		}),
+		Messages: []messageCase{message(1, "abc")},
+	})
+
+	for _, compressed := range []bool{false, true} {
+		name := "batch"
+		if compressed {
+			name = "compressed batch"
+		}
+		cases = append(cases, streamCase{
+			Name: name,
+			Bytes: stream(func(w *gobsp.Composer) error {
+				b := w.Batch()
+				if compressed {
+					b = w.CompressedBatch()
+				}
+				if err := b.Add(1, []byte("a")); err != nil {
+					return err
+				}
+				if err := b.Add(2, []byte(long)); err != nil {
+					return err
+				}
+				if err := b.Send(); err != nil {
+					return err
+				}
+				return w.Compose(3, []byte("c"))
+			}),
+			Messages: []messageCase{message(1, "a"), message(2, long), message(3, "c")},
+		})
+	}
+
+	cases = append(cases, streamCase{
+		Name: "fragmented message",
+		Bytes: stream(func(w *gobsp.Composer) error {
+			if err := w.Compose(5, []byte(long[:80])); err != nil {
+				return err
+			}
+			return w.Compose(6, []byte("small"))
+		}, gobsp.MaxFrameSize(32)),
+		Messages: []messageCase{message(5, long[:80]), message(6, "small")},
+	})
+
+	cases = append(cases, streamCase{
+		Name: "close ends the stream",
+		Bytes: stream(func(w *gobsp.Composer) error {
+			if err := w.Compose(1, []byte("a")); err != nil {
+				return err
+			}
+			if err := w.ComposeBinary(gobsp.MTClose, &gobsp.CloseMessage{Reason: "bye"}); err != nil {
+				return err
+			}
+			return w.Compose(2, []byte("b"))
+		}),
+		Messages: []messageCase{message(1, "a")},
+	})
+
+	batch := stream(func(w *gobsp.Composer) error {
+		return w.Compose(gobsp.MTBatch, []byte{0x01, 0x05, 'a'})
+	})
+	for _, c := range []streamCase{
+		{Name: "truncated frame header", Bytes: "0201", Messages: []messageCase{}},
+		{Name: "truncated message type", Bytes: "0100" + "80", Messages: []messageCase{message(1, "")}},
+		{Name: "truncated body", Bytes: "0105616263", Messages: []messageCase{}},
+		{Name: "message type overflows", Bytes: "ffffffffffffffffff0200", Messages: []messageCase{}, Error: "overflow"},
+		{Name: "message overruns batch", Bytes: batch, Messages: []messageCase{}},
+		{Name: "truncated preamble", Preamble: chat, Bytes: "4742535001", Messages: []messageCase{}},
+		{Name: "bad preamble magic", Preamble: chat, Bytes: "474253580100" + "0463686174", Messages: []messageCase{}, Error: "not-gobsp"},
+		{Name: "unsupported framing version", Preamble: &preambleCase{Version: 2, Features: "0", Application: "chat"},
+			Bytes: "474253500200" + "0463686174", Messages: []messageCase{}, Error: "unsupported-version"},
+		{Name: "unsupported features", Preamble: &preambleCase{Version: gobsp.FramingVersion, Features: "4", Application: "chat"},
+			AcceptFeatures: "3", Bytes: "474253500104" + "0463686174", Messages: []messageCase{}, Error: "unsupported-features"},
+	} {
+		if c.Error == "" {
+			c.Error = "truncated"
+		}
+		cases = append(cases, c)
+	}
+	return cases
+}
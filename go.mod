@@ -1,3 +1,5 @@
 module github.com/karrick/gobsp
 
+go 1.18
+
 require github.com/karrick/buffer v1.1.1
@@ -0,0 +1,139 @@
+// Package gobsptest provides utilities for testing programs that use gobsp:
+// checks that a Binary type encodes to the expected bytes and decodes back to
+// the same value, whichever kind of stream it is given, and a Harness that
+// connects a Composer to a Scanner in memory, for testing message handlers.
+package gobsptest
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+	"testing/iotest"
+
+	"github.com/karrick/gobsp"
+)
+
+// The gobsp primitives take a faster path when their stream implements
+// io.ByteReader or io.ByteWriter, so each check is made both with streams that
+// do, and with streams that implement only io.Reader or io.Writer.
+
+// writerOnly hides all methods of an io.Writer other than Write.
+type writerOnly struct {
+	io.Writer
+}
+
+// readerOnly hides all methods of an io.Reader other than Read.
+type readerOnly struct {
+	io.Reader
+}
+
+// reader describes one of the streams from which values are decoded.
+type reader struct {
+	name string
+	wrap func(io.Reader) io.Reader
+}
+
+// readers are the streams from which RoundTrip decodes values: one that
+// implements io.ByteReader, one that does not, and ones that return fewer bytes
+// than requested, or the error with the final bytes.
+var readers = []reader{
+	{"io.ByteReader", func(ior io.Reader) io.Reader { return ior }},
+	{"io.Reader", func(ior io.Reader) io.Reader { return readerOnly{ior} }},
+	{"one byte io.Reader", iotest.OneByteReader},
+	{"half io.Reader", iotest.HalfReader},
+	{"data and EOF io.Reader", iotest.DataErrReader},
+}
+
+// ensurePointer fails the test unless v is a pointer, into whose type values
+// may be decoded.
+func ensurePointer(t testing.TB, v gobsp.Binary) {
+	t.Helper()
+	if reflect.TypeOf(v).Kind() != reflect.Ptr {
+		t.Fatalf("%T: Binary value must be a pointer", v)
+	}
+}
+
+// newValue returns a pointer to a new zero value of the type to which v points.
+func newValue(v gobsp.Binary) gobsp.Binary {
+	return reflect.New(reflect.TypeOf(v).Elem()).Interface().(gobsp.Binary)
+}
+
+// Encode returns the encoding of v, after checking that v encodes to the same
+// bytes whether or not its stream implements io.ByteWriter.
+func Encode(t testing.TB, v gobsp.Binary) []byte {
+	t.Helper()
+	bb := new(bytes.Buffer)
+	if err := v.MarshalBinaryTo(bb); err != nil {
+		t.Fatalf("%T: encode to io.ByteWriter: %s", v, err)
+	}
+	plain := new(bytes.Buffer)
+	if err := v.MarshalBinaryTo(writerOnly{plain}); err != nil {
+		t.Fatalf("%T: encode to io.Writer: %s", v, err)
+	}
+	if actual, expected := plain.Bytes(), bb.Bytes(); !bytes.Equal(actual, expected) {
+		t.Errorf("%T: encode to io.Writer: Actual: %#v; Expected: %#v", v, actual, expected)
+	}
+	return bb.Bytes()
+}
+
+// RoundTrip checks that v encodes to the expected bytes, and that those bytes
+// decode to a value equal to v, consuming all of them, from every kind of stream.
+// It also checks that every truncation of the expected bytes fails to decode,
+// as Truncated does. When expected is nil, RoundTrip checks the bytes v encodes
+// to rather than specific bytes.
+//
+// v must be a pointer, such as a *gobsp.Uint32, because UnmarshalBinaryFrom
+// modifies its receiver, and each value is decoded into a new zero value of the
+// type to which v points. Decoded values equal v when reflect.DeepEqual reports
+// them equal, or when they encode to the same bytes as v, which accepts values
+// that do not survive decoding exactly, such as NaN, and nil slices decoded as
+// empty slices.
+func RoundTrip(t testing.TB, v gobsp.Binary, expected []byte) {
+	t.Helper()
+	ensurePointer(t, v)
+	encoded := Encode(t, v)
+	if expected == nil {
+		expected = encoded
+	} else if !bytes.Equal(encoded, expected) {
+		t.Errorf("%T: encode: Actual: %#v; Expected: %#v", v, encoded, expected)
+	}
+
+	for _, r := range readers {
+		w := newValue(v)
+		br := bytes.NewReader(expected)
+		if err := w.UnmarshalBinaryFrom(r.wrap(br)); err != nil {
+			t.Errorf("%T: decode from %s: %s", v, r.name, err)
+			continue
+		}
+		if br.Len() != 0 {
+			t.Errorf("%T: decode from %s: %d bytes left", v, r.name, br.Len())
+		}
+		if !reflect.DeepEqual(w, v) {
+			if again := Encode(t, w); !bytes.Equal(again, encoded) {
+				t.Errorf("%T: decode from %s: Actual: %#v; Expected: %#v", v, r.name, w, v)
+			}
+		}
+	}
+
+	Truncated(t, v, expected)
+}
+
+// Truncated checks that decoding each proper prefix of buf into a new zero value
+// of the type to which v points returns an error, rather than decoding a value
+// from a message cut short, whether or not the stream implements io.ByteReader,
+// and however few bytes each read returns. Any error will do, although the
+// gobsp primitives return io.EOF for an empty prefix, and io.ErrUnexpectedEOF
+// for others.
+func Truncated(t testing.TB, v gobsp.Binary, buf []byte) {
+	t.Helper()
+	ensurePointer(t, v)
+	for i := 0; i < len(buf); i++ {
+		for _, r := range readers {
+			w := newValue(v)
+			if err := w.UnmarshalBinaryFrom(r.wrap(bytes.NewReader(buf[:i]))); err == nil {
+				t.Errorf("%T: decode %d of %d bytes from %s: Actual: %#v; Expected: error", v, i, len(buf), r.name, w)
+			}
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package gobsptest
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/karrick/gobsp"
+)
+
+func ensure(t *testing.T, actual, expected interface{}) {
+	t.Helper()
+	if fmt.Sprintf("%#v", actual) != fmt.Sprintf("%#v", expected) {
+		t.Errorf("Actual: %#v; Expected: %#v", actual, expected)
+	}
+}
+
+// recorder is a testing.TB that records the failures it is told of.
+type recorder struct {
+	testing.TB
+	failures []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.failures = append(r.failures, fmt.Sprintf(format, args...))
+}
+
+func (r *recorder) Fatal(args ...interface{}) {
+	r.failures = append(r.failures, fmt.Sprint(args...))
+	runtime.Goexit()
+}
+
+func (r *recorder) Fatalf(format string, args ...interface{}) {
+	r.failures = append(r.failures, fmt.Sprintf(format, args...))
+	runtime.Goexit()
+}
+
+// failures returns the failures f reports to a testing.TB.
+func failures(f func(testing.TB)) []string {
+	r := new(recorder)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f(r)
+	}()
+	<-done
+	return r.failures
+}
+
+func TestRoundTripPrimitives(t *testing.T) {
+	i8, u16, vwi, uvwi := gobsp.Int8(-1), gobsp.Uint16(0x0102), gobsp.VWI(-65), gobsp.UVWI(300)
+	f32, f64 := gobsp.Float32(math.NaN()), gobsp.Float64(1.5)
+	s, ss, empty := gobsp.String("hi"), gobsp.StringSlice{"a", ""}, gobsp.StringSlice(nil)
+
+	RoundTrip(t, &i8, []byte{0xFF})
+	RoundTrip(t, &u16, []byte{0x01, 0x02})
+	RoundTrip(t, &vwi, nil)
+	RoundTrip(t, &uvwi, []byte{0xAC, 0x02})
+	RoundTrip(t, &f32, nil)
+	RoundTrip(t, &f64, []byte{0x3F, 0xF8, 0, 0, 0, 0, 0, 0})
+	RoundTrip(t, &s, []byte("\x02hi"))
+	RoundTrip(t, &ss, []byte("\x02\x01a\x00"))
+	RoundTrip(t, &empty, []byte{0x00})
+	RoundTrip(t, &gobsp.ErrorMessage{Code: gobsp.ErrorCodeProtocolViolation, Text: "bad"}, []byte("\x01\x03bad"))
+	RoundTrip(t, &gobsp.Preamble{Version: 1, Features: 2, Application: "app"}, nil)
+}
+
+func TestRoundTripEncoding(t *testing.T) {
+	u16 := gobsp.Uint16(1)
+	actual := failures(func(t testing.TB) { RoundTrip(t, &u16, []byte{0x01, 0x00}) })
+	ensure(t, len(actual), 1+5) // the encoding, then decoding 0x0100 from each stream
+	ensure(t, actual[0], "*gobsp.Uint16: encode: Actual: []byte{0x0, 0x1}; Expected: []byte{0x1, 0x0}")
+}
+
+func TestRoundTripRequiresPointer(t *testing.T) {
+	actual := failures(func(t testing.TB) { RoundTrip(t, byteWriterOnly(1), nil) })
+	ensure(t, actual, []string{"gobsptest.byteWriterOnly: Binary value must be a pointer"})
+}
+
+// byteWriterOnly encodes itself only to streams that implement io.ByteWriter.
+type byteWriterOnly uint8
+
+func (v byteWriterOnly) MarshalBinaryTo(iow io.Writer) error {
+	bw, ok := iow.(io.ByteWriter)
+	if !ok {
+		return fmt.Errorf("not an io.ByteWriter")
+	}
+	return bw.WriteByte(byte(v))
+}
+
+func (v byteWriterOnly) UnmarshalBinaryFrom(ior io.Reader) error { return nil }
+
+func TestRoundTripPlainWriter(t *testing.T) {
+	v := byteWriterOnly(1)
+	actual := failures(func(t testing.TB) { RoundTrip(t, &v, nil) })
+	ensure(t, actual, []string{"*gobsptest.byteWriterOnly: encode to io.Writer: not an io.ByteWriter"})
+}
+
+// singleRead decodes itself with a single Read, as though it always filled
+// the buffer.
+type singleRead [4]byte
+
+func (v *singleRead) MarshalBinaryTo(iow io.Writer) error {
+	_, err := iow.Write(v[:])
+	return err
+}
+
+func (v *singleRead) UnmarshalBinaryFrom(ior io.Reader) error {
+	_, err := ior.Read(v[:])
+	return err
+}
+
+func TestRoundTripShortReads(t *testing.T) {
+	v := singleRead{1, 2, 3, 4}
+	actual := failures(func(t testing.TB) { RoundTrip(t, &v, nil) })
+	for _, name := range []string{"one byte io.Reader", "half io.Reader"} {
+		var found bool
+		for _, failure := range actual {
+			found = found || strings.HasPrefix(failure, "*gobsptest.singleRead: decode from "+name+": Actual:")
+		}
+		if !found {
+			t.Errorf("Actual: %q; Expected: failure decoding from %s", actual, name)
+		}
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	s := gobsp.String("hello")
+	ensure(t, failures(func(t testing.TB) { Truncated(t, &s, []byte("\x05hello")) }), []string(nil))
+
+	// Only the stream that returns its final bytes with io.EOF reports an error
+	// for each non-empty prefix.
+	v := singleRead{1, 2, 3, 4}
+	actual := failures(func(t testing.TB) { Truncated(t, &v, v[:]) })
+	ensure(t, len(actual), 3*4)
+	ensure(t, actual[0], "*gobsptest.singleRead: decode 1 of 4 bytes from io.ByteReader: Actual: &gobsptest.singleRead{0x1, 0x0, 0x0, 0x0}; Expected: error")
+}
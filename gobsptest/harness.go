@@ -0,0 +1,143 @@
+package gobsptest
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/karrick/gobsp"
+)
+
+// Message is a message read from a stream, as returned by Harness.Replies.
+type Message struct {
+	Type gobsp.MessageType
+	Body []byte
+}
+
+// Harness connects a Composer to a Scanner in memory, so that a test may
+// compose messages, then have the Scanner dispatch them to the handlers being
+// tested, without a network connection or a goroutine. Send, SendBinary, and
+// Replies fail the test when they cannot write or read messages.
+//
+//	var h *gobsptest.Harness
+//	h = gobsptest.NewHarness(t, gobsp.Handlers(map[uint32]gobsp.MessageHandler{
+//		MTGreeting: func(ior io.Reader) error {
+//			var name gobsp.String
+//			if err := name.UnmarshalBinaryFrom(ior); err != nil {
+//				return err
+//			}
+//			return h.Reply.Compose(MTFarewell, []byte(name))
+//		},
+//	}))
+//	name := gobsp.String("world")
+//	h.SendBinary(MTGreeting, &name)
+//	if err := h.Handle(); err != nil {
+//		t.Fatal(err)
+//	}
+//	replies := h.Replies()
+type Harness struct {
+	// Composer writes the messages the Scanner reads.
+	Composer *gobsp.Composer
+
+	// Scanner reads the messages written by the Composer, and dispatches them
+	// to the handlers it was configured with.
+	Scanner *gobsp.Scanner
+
+	// Reply is a Composer for handlers that reply to the messages they handle.
+	// The Scanner also replies with it to control messages that require a
+	// reply, such as MTPing. Replies returns the messages written to it.
+	Reply *gobsp.Composer
+
+	t       testing.TB
+	stream  bytes.Buffer
+	replies bytes.Buffer
+	reader  *gobsp.Scanner // reads replies
+	read    []Message
+}
+
+// NewHarness returns a new Harness whose Scanner is modified by the specified
+// configuration functions. The Scanner is configured to reply using the
+// Harness's Reply Composer, unless the configuration functions specify
+// otherwise with gobsp.ReplyTo.
+func NewHarness(t testing.TB, configurators ...gobsp.ScannerConfig) *Harness {
+	t.Helper()
+	h := &Harness{t: t}
+	h.Composer = gobsp.NewComposer(&h.stream)
+	h.Reply = gobsp.NewComposer(&h.replies)
+	var err error
+	configurators = append([]gobsp.ScannerConfig{gobsp.ReplyTo(h.Reply)}, configurators...)
+	if h.Scanner, err = gobsp.NewScanner(&h.stream, configurators...); err != nil {
+		t.Fatal(err)
+	}
+	h.reader, err = gobsp.NewScanner(&h.replies, gobsp.Raw(), gobsp.DefaultHandler(func(ior io.Reader) error {
+		body, err := ioutil.ReadAll(ior)
+		if err != nil {
+			return err
+		}
+		h.read = append(h.read, Message{Type: h.reader.MessageType(), Body: body})
+		return nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+// Send composes a message having the specified type and body, and flushes it
+// to the Scanner.
+func (h *Harness) Send(messageType gobsp.MessageType, messageBody []byte) {
+	h.t.Helper()
+	if err := h.Composer.Compose(messageType, messageBody); err != nil {
+		h.t.Fatal(err)
+	}
+	if err := h.Composer.Flush(); err != nil {
+		h.t.Fatal(err)
+	}
+}
+
+// SendBinary composes a message whose body is the encoded form of the
+// specified value, and flushes it to the Scanner.
+func (h *Harness) SendBinary(messageType gobsp.MessageType, v gobsp.Binary) {
+	h.t.Helper()
+	if err := h.Composer.ComposeBinary(messageType, v); err != nil {
+		h.t.Fatal(err)
+	}
+	if err := h.Composer.Flush(); err != nil {
+		h.t.Fatal(err)
+	}
+}
+
+// Handle scans each message sent since it was last called, and dispatches it
+// to its handler. It returns the first error a handler returns, leaving any
+// messages after that one for the next call, and returns the Scanner's error
+// when the stream is malformed. A Handle after the Scanner handled an MTClose
+// message handles nothing.
+func (h *Harness) Handle() error {
+	for h.Scanner.Scan() {
+		if err := h.Scanner.Handle(); err != nil {
+			return err
+		}
+	}
+	return h.Scanner.Err()
+}
+
+// Replies returns the messages written to the Reply Composer since Replies was
+// last called, in the order they were written.
+func (h *Harness) Replies() []Message {
+	h.t.Helper()
+	if err := h.Reply.Flush(); err != nil {
+		h.t.Fatal(err)
+	}
+	for h.reader.Scan() {
+		if err := h.reader.Handle(); err != nil {
+			h.t.Fatal(err)
+		}
+	}
+	if err := h.reader.Err(); err != nil {
+		h.t.Fatal(err)
+	}
+	read := h.read
+	h.read = nil
+	return read
+}
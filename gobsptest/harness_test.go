@@ -0,0 +1,92 @@
+package gobsptest
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/karrick/gobsp"
+)
+
+const (
+	mtGreeting gobsp.MessageType = iota
+	mtFarewell
+)
+
+func TestHarnessReplies(t *testing.T) {
+	var h *Harness
+	h = NewHarness(t, gobsp.Handlers(map[uint32]gobsp.MessageHandler{
+		uint32(mtGreeting): func(ior io.Reader) error {
+			var name gobsp.String
+			if err := name.UnmarshalBinaryFrom(ior); err != nil {
+				return err
+			}
+			return h.Reply.Compose(mtFarewell, []byte(name))
+		},
+	}))
+	for _, name := range []string{"world", "moon"} {
+		s := gobsp.String(name)
+		h.SendBinary(mtGreeting, &s)
+	}
+	if err := h.Handle(); err != nil {
+		t.Fatal(err)
+	}
+	ensure(t, h.Replies(), []Message{{mtFarewell, []byte("world")}, {mtFarewell, []byte("moon")}})
+	ensure(t, h.Replies(), []Message(nil))
+
+	h.Send(mtGreeting, []byte("\x03sun"))
+	if err := h.Handle(); err != nil {
+		t.Fatal(err)
+	}
+	ensure(t, h.Replies(), []Message{{mtFarewell, []byte("sun")}})
+}
+
+func TestHarnessPing(t *testing.T) {
+	h := NewHarness(t, gobsp.DefaultHandler(gobsp.DiscardAll))
+	h.SendBinary(gobsp.MTPing, &gobsp.PingMessage{Token: 7})
+	if err := h.Handle(); err != nil {
+		t.Fatal(err)
+	}
+	ensure(t, h.Replies(), []Message{{gobsp.MTPong, []byte{0, 0, 0, 0, 0, 0, 0, 7}}})
+}
+
+func TestHarnessHandlerError(t *testing.T) {
+	errBad := errors.New("bad")
+	var handled []string
+	h := NewHarness(t, gobsp.DefaultHandler(func(ior io.Reader) error {
+		body, err := ioutil.ReadAll(ior)
+		if err != nil {
+			return err
+		}
+		if string(body) == "bad" {
+			return errBad
+		}
+		handled = append(handled, string(body))
+		return nil
+	}))
+	h.Send(1, []byte("a"))
+	h.Send(1, []byte("bad"))
+	h.Send(1, []byte("b"))
+	ensure(t, h.Handle(), errBad)
+	ensure(t, handled, []string{"a"})
+	if err := h.Handle(); err != nil {
+		t.Fatal(err)
+	}
+	ensure(t, handled, []string{"a", "b"})
+}
+
+func TestHarnessClose(t *testing.T) {
+	var handled int
+	h := NewHarness(t, gobsp.DefaultHandler(func(ior io.Reader) error {
+		handled++
+		return nil
+	}))
+	h.Send(1, nil)
+	h.SendBinary(gobsp.MTClose, &gobsp.CloseMessage{Reason: "done"})
+	h.Send(1, nil)
+	if err := h.Handle(); err != nil {
+		t.Fatal(err)
+	}
+	ensure(t, handled, 1)
+}
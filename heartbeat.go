@@ -0,0 +1,98 @@
+package gobsp
+
+import (
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+// ErrIdleTimeout is an error that is returned by Scanner when nothing is
+// received from the stream within the window specified by IdleTimeout.
+type ErrIdleTimeout struct{}
+
+func (e ErrIdleTimeout) Error() string {
+	return "idle timeout: nothing received from stream"
+}
+
+// ErrDeadlineUnsupported is an error that is returned by NewScanner when
+// IdleTimeout is specified for an io.Reader that has no SetReadDeadline
+// method, such as a net.Conn has.
+type ErrDeadlineUnsupported struct{}
+
+func (e ErrDeadlineUnsupported) Error() string {
+	return "idle timeout requires a reader with SetReadDeadline"
+}
+
+// Heartbeat causes a Composer to write and flush a heartbeat message whenever
+// no other message has been written for the specified interval, so that its
+// peer can distinguish an idle connection from a dead one. Heartbeats stop
+// when the Composer is closed.
+func Heartbeat(interval time.Duration) ComposerConfig {
+	return func(w *Composer) error {
+		w.heartbeatInterval = interval
+		return nil
+	}
+}
+
+// heartbeat writes heartbeat messages until the Composer is closed.
+func (w *Composer) heartbeat() {
+	defer close(w.stopped)
+	timer := time.NewTimer(w.heartbeatInterval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-timer.C:
+		}
+		w.mu.Lock()
+		idle := time.Since(w.lastWrite)
+		w.mu.Unlock()
+		if idle >= w.heartbeatInterval {
+			_ = w.write(MTHeartbeat, nil, true) // a failed write is reported by the next Compose
+			idle = 0
+		}
+		timer.Reset(w.heartbeatInterval - idle)
+	}
+}
+
+// IdleTimeout causes a Scanner to fail with ErrIdleTimeout when nothing,
+// including heartbeats, is received from the stream for the specified
+// duration. The io.Reader given to NewScanner must have a SetReadDeadline
+// method, as net.Conn does. Because the timeout also applies while a handler
+// reads the message payload, it ought to be comfortably longer than the peer's
+// Heartbeat interval.
+func IdleTimeout(timeout time.Duration) ScannerConfig {
+	return func(s *Scanner) error {
+		d, ok := s.ior.(deadliner)
+		if !ok {
+			return ErrDeadlineUnsupported{}
+		}
+		s.ior = &idleReader{ior: s.ior, d: d, timeout: timeout}
+		return nil
+	}
+}
+
+type deadliner interface {
+	SetReadDeadline(time.Time) error
+}
+
+// idleReader extends the read deadline of the underlying io.Reader before each
+// read.
+type idleReader struct {
+	ior     io.Reader
+	d       deadliner
+	timeout time.Duration
+}
+
+func (r *idleReader) Read(buf []byte) (int, error) {
+	if err := r.d.SetReadDeadline(time.Now().Add(r.timeout)); err != nil {
+		return 0, err
+	}
+	n, err := r.ior.Read(buf)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		err = ErrIdleTimeout{}
+	}
+	return n, err
+}
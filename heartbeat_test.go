@@ -0,0 +1,77 @@
+package gobsp
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestScannerSkipsHeartbeats(t *testing.T) {
+	bb := bytes.NewBuffer([]byte{
+		0x82, 0xFE, 0xFF, 0xFF, 0x0F, 0x00, // heartbeat
+		0x01, 0x01, 0x2A, // message
+		0x82, 0xFE, 0xFF, 0xFF, 0x0F, 0x00, // heartbeat
+	})
+
+	var handled int
+	scanner, err := NewScanner(bb, DefaultHandler(func(ior io.Reader) error {
+		handled++
+		return DiscardAll(ior)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ensure(t, scanner.Scan(), true)
+	ensure(t, scanner.Handle(), nil)
+	ensure(t, scanner.Scan(), false)
+	ensure(t, scanner.Err(), nil)
+	ensure(t, handled, 1)
+}
+
+func TestIdleTimeoutRequiresDeadline(t *testing.T) {
+	_, err := NewScanner(new(bytes.Buffer), DefaultHandler(DiscardAll), IdleTimeout(time.Second))
+	ensure(t, err, error(ErrDeadlineUnsupported{}))
+}
+
+func TestHeartbeatPreventsIdleTimeout(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	w, err := NewComposerWithOptions(a, Heartbeat(5*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bodies := make(chan string, 1)
+	scanner, err := NewScanner(b, IdleTimeout(50*time.Millisecond), DefaultHandler(func(ior io.Reader) error {
+		buf, err := io.ReadAll(ior)
+		bodies <- string(buf)
+		return err
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		// Stay quiet for several idle timeouts before sending a message.
+		time.Sleep(150 * time.Millisecond)
+		if err := w.Compose(1, []byte("still here")); err != nil {
+			t.Error(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	ensure(t, scanner.Scan(), true)
+	ensure(t, scanner.Handle(), nil)
+	ensure(t, <-bodies, "still here")
+
+	// After the composer is closed, no heartbeats arrive.
+	ensure(t, scanner.Scan(), false)
+	ensure(t, scanner.Err(), error(ErrIdleTimeout{}))
+}
@@ -0,0 +1,122 @@
+// Package logfile stores gobsp messages in an append-only file, such as an
+// event log, in a form that survives the writing process crashing part way
+// through a write.
+//
+// A log file begins with a gobsp Preamble declaring gobsp.FeatureChecksums.
+// Each record that follows is a gobsp frame, its UVWI message type, UVWI
+// message size, and message body, followed by the big-endian CRC-32C checksum
+// of the frame. When a process dies while appending, the file may end with a
+// torn record. Open finds the last intact record, truncates anything after it,
+// and reports what it discarded, so appending may resume. Reader strips the
+// checksums, verifying them as it goes, so the log may be processed by an
+// ordinary gobsp.Scanner.
+package logfile
+
+import (
+	"bufio"
+	"bytes"
+	"hash/crc32"
+	"io"
+
+	"github.com/karrick/gobsp"
+)
+
+// castagnoli is the CRC-32C table used for record checksums.
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrChecksum is an error that is returned when a record's checksum does not
+// match its frame.
+type ErrChecksum struct{}
+
+func (e ErrChecksum) Error() string {
+	return "record checksum mismatch"
+}
+
+// ErrNotLogFile is an error that is returned when a file begins with a gobsp
+// preamble that does not declare gobsp.FeatureChecksums, so its records do not
+// carry checksums.
+type ErrNotLogFile struct{}
+
+func (e ErrNotLogFile) Error() string {
+	return "not a log file: preamble does not declare checksums"
+}
+
+// preamble returns the preamble written at the start of a log file for the
+// specified application.
+func preamble(application string) gobsp.Preamble {
+	return gobsp.Preamble{
+		Version:     gobsp.FramingVersion,
+		Features:    gobsp.FeatureChecksums,
+		Application: gobsp.String(application),
+	}
+}
+
+// checkPreamble returns an error when the preamble read from a log file does
+// not describe a log file this package can read, or application is not empty
+// and does not match the preamble.
+func checkPreamble(p gobsp.Preamble, application string) error {
+	if p.Version > gobsp.FramingVersion {
+		return gobsp.ErrUnsupportedVersion(p.Version)
+	}
+	if p.Features&gobsp.FeatureChecksums == 0 {
+		return ErrNotLogFile{}
+	}
+	if unknown := p.Features &^ gobsp.FeatureChecksums; unknown != 0 {
+		return gobsp.ErrUnsupportedFeatures(unknown)
+	}
+	if application != "" && string(p.Application) != application {
+		return gobsp.ErrApplicationMismatch{Expected: application, Actual: string(p.Application)}
+	}
+	return nil
+}
+
+// appendRecord appends the record for a message having the specified type and
+// body to buf, returning the extended buffer.
+func appendRecord(buf []byte, messageType gobsp.MessageType, messageBody []byte) []byte {
+	bb := bytes.NewBuffer(buf)
+	start := bb.Len()
+	_ = gobsp.UVWI(messageType).MarshalBinaryTo(bb)
+	_ = gobsp.UVWI(len(messageBody)).MarshalBinaryTo(bb)
+	bb.Write(messageBody)
+	_ = gobsp.Uint32(crc32.Checksum(bb.Bytes()[start:], castagnoli)).MarshalBinaryTo(bb)
+	return bb.Bytes()
+}
+
+// readRecord reads the next record from br, returning its frame after
+// verifying the frame's checksum. It returns io.EOF only when br ends cleanly
+// between records, io.ErrUnexpectedEOF when it ends part way through a record,
+// and ErrChecksum when the record is corrupt.
+func readRecord(br *bufio.Reader) ([]byte, error) {
+	bb := new(bytes.Buffer)
+	var messageType, messageSize gobsp.UVWI
+	if err := messageType.UnmarshalBinaryFrom(br); err != nil {
+		return nil, err
+	}
+	_ = messageType.MarshalBinaryTo(bb)
+	if err := messageSize.UnmarshalBinaryFrom(br); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	_ = messageSize.MarshalBinaryTo(bb)
+	// Copy rather than allocating messageSize bytes up front, so a corrupt size
+	// cannot exhaust memory.
+	if n, err := io.CopyN(bb, br, int64(messageSize)); err != nil || uint64(n) != uint64(messageSize) {
+		return nil, unexpectedEOF(err)
+	}
+	var checksum gobsp.Uint32
+	if err := checksum.UnmarshalBinaryFrom(br); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if uint32(checksum) != crc32.Checksum(bb.Bytes(), castagnoli) {
+		return nil, ErrChecksum{}
+	}
+	return bb.Bytes(), nil
+}
+
+// unexpectedEOF converts io.EOF, and a nil error from a short read, into
+// io.ErrUnexpectedEOF.
+func unexpectedEOF(err error) error {
+	if err == nil || err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
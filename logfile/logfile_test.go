@@ -0,0 +1,218 @@
+package logfile
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/karrick/gobsp"
+)
+
+func ensure(t *testing.T, actual, expected interface{}) {
+	t.Helper()
+	if actual != expected {
+		t.Errorf("Actual: %#v; Expected: %#v", actual, expected)
+	}
+}
+
+// testAppend opens the named log file and appends messages of types 1, 2, and
+// so on, having the specified bodies.
+func testAppend(t *testing.T, name string, bodies ...string) {
+	t.Helper()
+	w, _, err := Open(name, Application("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, body := range bodies {
+		if err := w.Append(gobsp.MessageType(i+1), []byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testRead returns the bodies of the messages in the named log file, and the
+// error that ended the scan.
+func testRead(t *testing.T, name string) ([]string, error) {
+	t.Helper()
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := NewReader(f, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bodies []string
+	scanner, err := gobsp.NewScanner(r, gobsp.DefaultHandler(func(ior io.Reader) error {
+		buf, err := ioutil.ReadAll(ior)
+		bodies = append(bodies, string(buf))
+		return err
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for scanner.Scan() {
+		if err := scanner.Handle(); err != nil {
+			return bodies, err
+		}
+	}
+	return bodies, scanner.Err()
+}
+
+// testTruncate removes the specified number of bytes from the end of the named
+// file.
+func testTruncate(t *testing.T, name string, n int64) {
+	t.Helper()
+	fi, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Truncate(name, fi.Size()-n); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "log")
+	testAppend(t, name, "one", "", "three")
+
+	bodies, err := testRead(t, name)
+	ensure(t, err, nil)
+	ensure(t, len(bodies), 3)
+	ensure(t, bodies[0], "one")
+	ensure(t, bodies[1], "")
+	ensure(t, bodies[2], "three")
+}
+
+func TestOpenNewFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "log")
+	w, r, err := Open(name, Application("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ensure(t, w.Close(), nil)
+	ensure(t, r.Records, 0)
+	ensure(t, r.Discarded, int64(0))
+	ensure(t, r.Err, nil)
+
+	fi, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ensure(t, r.Offset, fi.Size())
+}
+
+func TestOpenTruncatesTornRecord(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "log")
+	testAppend(t, name, "one", "two", "three")
+	testTruncate(t, name, 3)
+
+	_, err := testRead(t, name)
+	ensure(t, err, io.ErrUnexpectedEOF)
+
+	w, r, err := Open(name, Application("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ensure(t, r.Records, 2)
+	ensure(t, r.Discarded, int64(1+1+5+4-3))
+	ensure(t, r.Err, io.ErrUnexpectedEOF)
+	ensure(t, w.Append(3, []byte("four")), nil)
+	ensure(t, w.Close(), nil)
+
+	bodies, err := testRead(t, name)
+	ensure(t, err, nil)
+	ensure(t, len(bodies), 3)
+	ensure(t, bodies[2], "four")
+}
+
+func TestOpenTruncatesCorruptRecord(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "log")
+	testAppend(t, name, "one", "two")
+
+	f, err := os.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.WriteAt([]byte("X"), fi.Size()-5); err != nil { // last byte of "two"
+		t.Fatal(err)
+	}
+	ensure(t, f.Close(), nil)
+
+	_, err = testRead(t, name)
+	ensure(t, err, error(ErrChecksum{}))
+
+	w, r, err := Open(name, Application("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ensure(t, w.Close(), nil)
+	ensure(t, r.Records, 1)
+	ensure(t, r.Discarded, int64(1+1+3+4))
+	ensure(t, r.Err, error(ErrChecksum{}))
+}
+
+func TestOpenRecoversTornPreamble(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "log")
+	testAppend(t, name)
+	testTruncate(t, name, 2)
+
+	w, r, err := Open(name, Application("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ensure(t, r.Err, io.ErrUnexpectedEOF)
+	ensure(t, w.Append(1, []byte("one")), nil)
+	ensure(t, w.Close(), nil)
+
+	bodies, err := testRead(t, name)
+	ensure(t, err, nil)
+	ensure(t, len(bodies), 1)
+}
+
+func TestOpenApplicationMismatch(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "log")
+	testAppend(t, name, "one")
+
+	_, _, err := Open(name, Application("other"))
+	ensure(t, err, error(gobsp.ErrApplicationMismatch{Expected: "other", Actual: "test"}))
+}
+
+func TestOpenNotLogFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "log")
+	if err := ioutil.WriteFile(name, []byte("not a log file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err := Open(name)
+	ensure(t, err, error(gobsp.ErrNotGobspStream{}))
+}
+
+func TestSyncPolicies(t *testing.T) {
+	for _, c := range []Config{SyncEvery(1), SyncEvery(2), SyncInterval(time.Millisecond)} {
+		name := filepath.Join(t.TempDir(), "log")
+		w, _, err := Open(name, Application("test"), c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 3; i++ {
+			ensure(t, w.Append(1, []byte("body")), nil)
+			time.Sleep(2 * time.Millisecond)
+		}
+		ensure(t, w.Close(), nil)
+
+		bodies, err := testRead(t, name)
+		ensure(t, err, nil)
+		ensure(t, len(bodies), 3)
+	}
+}
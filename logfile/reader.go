@@ -0,0 +1,52 @@
+package logfile
+
+import (
+	"bufio"
+	"io"
+
+	"github.com/karrick/gobsp"
+)
+
+// Reader reads the records of a log file, verifying their checksums, and
+// returns their frames as an ordinary gobsp stream, without a preamble, that
+// may be processed by a gobsp.Scanner. A torn final record is reported as
+// io.ErrUnexpectedEOF, and a corrupt record as ErrChecksum.
+type Reader struct {
+	br       *bufio.Reader
+	preamble gobsp.Preamble
+	frame    []byte // unread bytes of the current record's frame
+	err      error
+}
+
+// NewReader reads and checks the preamble of the log file read from the
+// specified io.Reader, and returns a Reader for its records. When application
+// is not empty, NewReader returns gobsp.ErrApplicationMismatch unless the
+// preamble names the same application.
+func NewReader(ior io.Reader, application string) (*Reader, error) {
+	r := &Reader{br: bufio.NewReader(ior)}
+	if err := r.preamble.UnmarshalBinaryFrom(r.br); err != nil {
+		return nil, err
+	}
+	if err := checkPreamble(r.preamble, application); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Preamble returns the preamble read from the log file.
+func (r *Reader) Preamble() gobsp.Preamble {
+	return r.preamble
+}
+
+// Read reads the frames of the log file's records.
+func (r *Reader) Read(buf []byte) (int, error) {
+	for len(r.frame) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.frame, r.err = readRecord(r.br)
+	}
+	n := copy(buf, r.frame)
+	r.frame = r.frame[n:]
+	return n, nil
+}
@@ -0,0 +1,244 @@
+package logfile
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/karrick/gobsp"
+)
+
+// Recovery reports what Open found at the end of an existing log file.
+type Recovery struct {
+	// Records is the number of intact records in the file.
+	Records int
+
+	// Offset is the size of the file after recovery, where the next record
+	// will be appended.
+	Offset int64
+
+	// Discarded is the number of bytes truncated from the end of the file
+	// because they did not form intact records.
+	Discarded int64
+
+	// Err explains why bytes were discarded, and is nil when none were. It is
+	// io.ErrUnexpectedEOF for a torn record, and ErrChecksum for a corrupt
+	// one.
+	Err error
+}
+
+// Config is a function that modifies a newly opened Writer instance.
+type Config func(*Writer) error
+
+// Application names the application protocol written in the preamble of a new
+// log file. When opening an existing log file, Open returns
+// gobsp.ErrApplicationMismatch unless its preamble names the same application.
+func Application(name string) Config {
+	return func(w *Writer) error {
+		w.application = name
+		return nil
+	}
+}
+
+// SyncEvery causes a Writer to sync the file to stable storage after every
+// specified number of appended records. SyncEvery(1) syncs each record before
+// Append returns, trading throughput for durability.
+func SyncEvery(records int) Config {
+	return func(w *Writer) error {
+		w.syncRecords = records
+		return nil
+	}
+}
+
+// SyncInterval causes a Writer to sync the file to stable storage no later
+// than the specified duration after a record is appended, bounding how many
+// records a power failure may lose without syncing each one.
+func SyncInterval(interval time.Duration) Config {
+	return func(w *Writer) error {
+		w.syncInterval = interval
+		return nil
+	}
+}
+
+// Writer appends records to a log file. Each record is written to the file
+// with a single write, so a record survives the process crashing once Append
+// returns. Surviving a power failure also requires the file be synced, which a
+// Writer does only as often as its sync policy demands: by default only when
+// Sync or Close is called. It is safe for concurrent use by multiple
+// goroutines.
+type Writer struct {
+	mu           sync.Mutex
+	f            *os.File
+	application  string
+	syncRecords  int
+	syncInterval time.Duration
+	unsynced     int         // records appended since the last sync
+	syncTimer    *time.Timer // nil unless an interval sync is pending
+	buf          []byte      // reused to encode records
+	err          error       // first error from a write or an interval sync
+}
+
+// Open opens the named log file for appending, creating it when it does not
+// exist. When the file exists, Open reads every record to find the end of the
+// intact records, truncates the file there, and reports what it found.
+//
+// A corrupt record cannot be skipped, because its size may be corrupt, so
+// Open discards everything from the first record that is torn or fails its
+// checksum to the end of the file.
+func Open(name string, configurators ...Config) (*Writer, Recovery, error) {
+	w := new(Writer)
+	for _, c := range configurators {
+		if err := c(w); err != nil {
+			return nil, Recovery{}, err
+		}
+	}
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, Recovery{}, err
+	}
+	w.f = f
+	r, err := w.recover()
+	if err != nil {
+		_ = f.Close()
+		return nil, r, err
+	}
+	return w, r, nil
+}
+
+// recover validates the file, truncates anything after its intact records, and
+// positions the file for appending.
+func (w *Writer) recover() (Recovery, error) {
+	var r Recovery
+	fi, err := w.f.Stat()
+	if err != nil {
+		return r, err
+	}
+	size := fi.Size()
+	br := bufio.NewReader(w.f)
+
+	var p gobsp.Preamble
+	switch err = p.UnmarshalBinaryFrom(br); err {
+	case nil:
+		if err = checkPreamble(p, w.application); err != nil {
+			return r, err
+		}
+		r.Offset = preambleSize(p)
+		for {
+			frame, err := readRecord(br)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				if _, ok := err.(ErrChecksum); !ok && err != io.ErrUnexpectedEOF {
+					return r, err
+				}
+				r.Err = err
+				break
+			}
+			r.Records++
+			r.Offset += int64(len(frame)) + 4
+		}
+	case io.EOF:
+		// empty file
+	case io.ErrUnexpectedEOF:
+		// The process died while writing the preamble, unless the file is too
+		// short to hold the magic bytes and does not begin with them.
+		if size < 4 {
+			buf := make([]byte, size)
+			if _, err = w.f.ReadAt(buf, 0); err != nil {
+				return r, err
+			}
+			if !bytes.HasPrefix([]byte("GBSP"), buf) {
+				return r, gobsp.ErrNotGobspStream{}
+			}
+		}
+		r.Err = err
+	default:
+		return r, err
+	}
+
+	if r.Discarded = size - r.Offset; r.Discarded > 0 {
+		if err = w.f.Truncate(r.Offset); err != nil {
+			return r, err
+		}
+	}
+	if r.Offset == 0 {
+		bb := new(bytes.Buffer)
+		_ = preamble(w.application).MarshalBinaryTo(bb)
+		if _, err = w.f.WriteAt(bb.Bytes(), 0); err != nil {
+			return r, err
+		}
+		r.Offset = int64(bb.Len())
+	}
+	_, err = w.f.Seek(r.Offset, io.SeekStart)
+	return r, err
+}
+
+// preambleSize returns the number of bytes the preamble occupies.
+func preambleSize(p gobsp.Preamble) int64 {
+	bb := new(bytes.Buffer)
+	_ = p.MarshalBinaryTo(bb)
+	return int64(bb.Len())
+}
+
+// Append appends a record for a message having the specified type and body,
+// then syncs the file when the sync policy requires it.
+func (w *Writer) Append(messageType gobsp.MessageType, messageBody []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+	w.buf = appendRecord(w.buf[:0], messageType, messageBody)
+	if _, err := w.f.Write(w.buf); err != nil {
+		w.err = err // a partial record must not be followed by others
+		return err
+	}
+	w.unsynced++
+	if w.syncRecords > 0 && w.unsynced >= w.syncRecords {
+		return w.sync()
+	}
+	if w.syncInterval > 0 && w.syncTimer == nil {
+		w.syncTimer = time.AfterFunc(w.syncInterval, func() {
+			w.mu.Lock()
+			defer w.mu.Unlock()
+			w.syncTimer = nil
+			if err := w.sync(); err != nil && w.err == nil {
+				w.err = err // reported by the next Append
+			}
+		})
+	}
+	return nil
+}
+
+// Sync syncs the file to stable storage.
+func (w *Writer) Sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.sync()
+}
+
+// sync syncs the file and resets the sync policy state. The caller must hold
+// w.mu.
+func (w *Writer) sync() error {
+	w.unsynced = 0
+	if w.syncTimer != nil {
+		w.syncTimer.Stop()
+		w.syncTimer = nil
+	}
+	return w.f.Sync()
+}
+
+// Close syncs and closes the file.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	err := w.sync()
+	if cerr := w.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
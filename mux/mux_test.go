@@ -0,0 +1,234 @@
+package mux
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/karrick/gobsp"
+)
+
+// gobspHandler returns a configuration for a Scanner that stores the body of
+// messages of the specified type.
+func gobspHandler(messageType uint32, body *[]byte) gobsp.ScannerConfig {
+	return gobsp.Handlers(map[uint32]gobsp.MessageHandler{
+		messageType: func(ior io.Reader) error {
+			var err error
+			*body, err = io.ReadAll(ior)
+			return err
+		},
+	})
+}
+
+func testSessions(t *testing.T, configurators ...SessionConfig) (*Session, *Session) {
+	a, b := net.Pipe()
+	client, err := NewSession(a, true, configurators...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := NewSession(b, false, configurators...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client, server
+}
+
+func TestStreamMessages(t *testing.T) {
+	client, server := testSessions(t)
+
+	st, err := client.OpenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	composer, err := st.Composer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := composer.Compose(7, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := composer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	peer, err := server.AcceptStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual, expected := peer.ID(), st.ID(); actual != expected {
+		t.Errorf("Actual: %#v; Expected: %#v", actual, expected)
+	}
+
+	var body []byte
+	scanner, err := peer.Scanner(gobspHandler(7, &body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !scanner.Scan() {
+		t.Fatal(scanner.Err())
+	}
+	if err := scanner.Handle(); err != nil {
+		t.Fatal(err)
+	}
+	if actual, expected := string(body), "hello"; actual != expected {
+		t.Errorf("Actual: %#v; Expected: %#v", actual, expected)
+	}
+	if scanner.Scan() {
+		t.Errorf("Actual: %#v; Expected: %#v", true, false)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestStreamFlowControl(t *testing.T) {
+	const window = 1024
+	client, server := testSessions(t, Window(window), MaxFrameSize(100))
+
+	bulk, err := client.OpenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	control, err := client.OpenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	peerBulk, err := server.AcceptStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	peerControl, err := server.AcceptStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nobody reads the bulk stream yet, so its writer blocks once the window
+	// is exhausted.
+	payload := bytes.Repeat([]byte("0123456789"), 1000)
+	written := make(chan int)
+	go func() {
+		n, _ := bulk.Write(payload)
+		written <- n
+	}()
+
+	select {
+	case n := <-written:
+		t.Fatalf("bulk write of %d bytes completed without flow control", n)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// The control stream is not starved by the blocked bulk stream.
+	if _, err := control.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(peerControl, buf); err != nil {
+		t.Fatal(err)
+	}
+	if actual, expected := string(buf), "ping"; actual != expected {
+		t.Errorf("Actual: %#v; Expected: %#v", actual, expected)
+	}
+
+	// Reading the bulk stream opens the window and lets the writer finish.
+	received := make([]byte, len(payload))
+	if _, err := io.ReadFull(peerBulk, received); err != nil {
+		t.Fatal(err)
+	}
+	if actual, expected := <-written, len(payload); actual != expected {
+		t.Errorf("Actual: %#v; Expected: %#v", actual, expected)
+	}
+	if !bytes.Equal(received, payload) {
+		t.Errorf("received payload does not match")
+	}
+}
+
+func TestSessionClose(t *testing.T) {
+	client, server := testSessions(t)
+
+	st, err := client.OpenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer, err := server.AcceptStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client.Close()
+
+	if _, err := peer.Read(make([]byte, 1)); err == nil {
+		t.Errorf("Actual: %#v; Expected: non-nil error", err)
+	}
+	if _, err := st.Write([]byte("x")); err == nil {
+		t.Errorf("Actual: %#v; Expected: non-nil error", err)
+	}
+	if _, err := server.AcceptStream(); err == nil {
+		t.Errorf("Actual: %#v; Expected: non-nil error", err)
+	}
+}
+
+func TestSessionInvalidSize(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	_, err := NewSession(a, true, Window(0))
+	if actual, expected := err, error(ErrInvalidSize(0)); actual != expected {
+		t.Errorf("Actual: %#v; Expected: %#v", actual, expected)
+	}
+	_, err = NewSession(a, true, MaxFrameSize(-1))
+	if actual, expected := err, error(ErrInvalidSize(-1)); actual != expected {
+		t.Errorf("Actual: %#v; Expected: %#v", actual, expected)
+	}
+}
+
+// testOpen sends a request to open each of the specified stream IDs to a
+// session that is not the initiator, and returns the error that ends the
+// session.
+func testOpen(t *testing.T, ids ...uint64) error {
+	a, b := net.Pipe()
+	s, err := NewSession(b, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		s.Close()
+		a.Close()
+	})
+	w := gobsp.NewComposer(a)
+	for _, id := range ids {
+		bb := new(bytes.Buffer)
+		_ = gobsp.UVWI(id).MarshalBinaryTo(bb)
+		if err = w.Compose(mtOpen, bb.Bytes()); err == nil {
+			err = w.Flush()
+		}
+		if err != nil {
+			break // session ended
+		}
+	}
+	select {
+	case <-s.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("session did not end")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func TestSessionOpenInvalidStreamID(t *testing.T) {
+	if actual, expected := testOpen(t, 3), error(ErrInvalidStreamID(3)); actual != expected {
+		t.Errorf("Actual: %#v; Expected: %#v", actual, expected)
+	}
+	if actual, expected := testOpen(t, 2, 2), error(ErrInvalidStreamID(2)); actual != expected {
+		t.Errorf("Actual: %#v; Expected: %#v", actual, expected)
+	}
+}
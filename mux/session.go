@@ -0,0 +1,363 @@
+// Package mux multiplexes several independent gobsp message streams over a
+// single connection.
+//
+// The connection itself carries a gobsp stream whose messages each name a
+// logical stream ID. Each logical Stream is an io.ReadWriteCloser, over which
+// the program may run its own Scanner and Composer. Every stream has its own
+// flow control window: a sender may not have more than the window's bytes
+// outstanding to the receiver until the receiving program reads them, so a
+// bulk transfer on one stream can neither exhaust the receiver's memory nor
+// starve the other streams sharing the connection.
+package mux
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"sync"
+
+	"github.com/karrick/gobsp"
+)
+
+// Message types used on the underlying connection. They are allocated from the
+// range of message types gobsp reserves for its subpackages.
+const (
+	mtOpen   = gobsp.MinReservedMessageType + 0xA0 + iota // stream ID
+	mtData                                                // stream ID, data
+	mtWindow                                              // stream ID, window increment
+	mtClose                                               // stream ID
+)
+
+const (
+	defaultWindow       = 256 << 10
+	defaultMaxFrameSize = 16 << 10
+	defaultBacklog      = 16
+)
+
+// ErrSessionClosed is an error that is returned by operations on a Session,
+// or on any of its streams, after the session or its connection has closed.
+type ErrSessionClosed struct{}
+
+func (e ErrSessionClosed) Error() string {
+	return "mux session closed"
+}
+
+// ErrStreamClosed is an error that is returned by Stream.Write after the
+// stream has been closed.
+type ErrStreamClosed struct{}
+
+func (e ErrStreamClosed) Error() string {
+	return "mux stream closed"
+}
+
+// ErrWindowExceeded is an error that is returned when the peer sends more data
+// on a stream than its flow control window permits. It ends the session.
+type ErrWindowExceeded uint64
+
+func (e ErrWindowExceeded) Error() string {
+	return "mux stream window exceeded: " + gobsp.UVWI(e).String()
+}
+
+// ErrInvalidStreamID is an error that is returned when the peer opens a stream
+// whose ID only this end may allocate, or that is already open. It ends the
+// session.
+type ErrInvalidStreamID uint64
+
+func (e ErrInvalidStreamID) Error() string {
+	return "mux stream ID invalid: " + gobsp.UVWI(e).String()
+}
+
+// ErrInvalidSize is an error that is returned by NewSession when Window or
+// MaxFrameSize specifies a size less than 1.
+type ErrInvalidSize int
+
+func (e ErrInvalidSize) Error() string {
+	return "mux size invalid: " + strconv.Itoa(int(e)) + " < 1"
+}
+
+// SessionConfig is a function that modifies a newly created Session instance.
+type SessionConfig func(*Session) error
+
+// Window specifies the number of bytes each stream may receive before the
+// program reads them. Both peers must use the same window.
+func Window(size int) SessionConfig {
+	return func(s *Session) error {
+		if size < 1 {
+			return ErrInvalidSize(size)
+		}
+		s.window = size
+		return nil
+	}
+}
+
+// MaxFrameSize specifies the largest number of data bytes sent in a single
+// frame. Smaller frames allow frames from other streams to be interleaved
+// more often.
+func MaxFrameSize(size int) SessionConfig {
+	return func(s *Session) error {
+		if size < 1 {
+			return ErrInvalidSize(size)
+		}
+		s.maxFrameSize = size
+		return nil
+	}
+}
+
+// Backlog specifies the number of streams opened by the peer that may await
+// AcceptStream before further streams are refused.
+func Backlog(size int) SessionConfig {
+	return func(s *Session) error {
+		s.backlog = size
+		return nil
+	}
+}
+
+// Session multiplexes streams over a single connection.
+type Session struct {
+	conn         io.ReadWriteCloser
+	window       int
+	maxFrameSize int
+	backlog      int
+
+	wmu      sync.Mutex // guards composer
+	composer *gobsp.Composer
+
+	mu      sync.Mutex // guards nextID, streams, and err
+	nextID  uint64
+	streams map[uint64]*Stream
+	err     error
+
+	accept chan *Stream
+	done   chan struct{}
+}
+
+// NewSession returns a new Session that multiplexes streams over the specified
+// connection. One peer must be the initiator and the other must not, so that
+// the stream IDs each allocates never collide.
+func NewSession(conn io.ReadWriteCloser, initiator bool, configurators ...SessionConfig) (*Session, error) {
+	s := &Session{
+		conn:         conn,
+		window:       defaultWindow,
+		maxFrameSize: defaultMaxFrameSize,
+		backlog:      defaultBacklog,
+		streams:      make(map[uint64]*Stream),
+		done:         make(chan struct{}),
+	}
+	if !initiator {
+		s.nextID = 1
+	}
+	for _, c := range configurators {
+		if err := c(s); err != nil {
+			return nil, err
+		}
+	}
+	s.accept = make(chan *Stream, s.backlog)
+
+	s.composer = gobsp.NewComposer(conn)
+	scanner, err := gobsp.NewScanner(conn,
+		gobsp.DefaultHandler(gobsp.DiscardAll),
+		gobsp.Handlers(map[uint32]gobsp.MessageHandler{
+			uint32(mtOpen):   s.handleOpen,
+			uint32(mtData):   s.handleData,
+			uint32(mtWindow): s.handleWindow,
+			uint32(mtClose):  s.handleClose,
+		}))
+	if err != nil {
+		return nil, err
+	}
+	go s.receive(scanner)
+	return s, nil
+}
+
+// receive dispatches frames to their streams until the connection ends.
+func (s *Session) receive(scanner *gobsp.Scanner) {
+	var err error
+	for scanner.Scan() {
+		if err = scanner.Handle(); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = scanner.Err()
+	}
+	s.fail(err)
+}
+
+// fail ends the session, failing every stream.
+func (s *Session) fail(err error) {
+	if err == nil {
+		err = ErrSessionClosed{}
+	}
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return
+	}
+	s.err = err
+	streams := s.streams
+	s.streams = make(map[uint64]*Stream)
+	s.mu.Unlock()
+
+	s.conn.Close()
+	for _, st := range streams {
+		st.fail(err)
+	}
+	close(s.done)
+}
+
+// Close closes the session and its connection, failing every stream.
+func (s *Session) Close() error {
+	s.fail(ErrSessionClosed{})
+	return nil
+}
+
+// writeFrame writes a single frame for the specified stream, followed by
+// optional data, and flushes it to the connection.
+func (s *Session) writeFrame(messageType gobsp.MessageType, id uint64, value uint64, data []byte) error {
+	bb := new(bytes.Buffer)
+	_ = gobsp.UVWI(id).MarshalBinaryTo(bb)
+	if messageType == mtWindow {
+		_ = gobsp.UVWI(value).MarshalBinaryTo(bb)
+	}
+	bb.Write(data)
+
+	s.wmu.Lock()
+	err := s.composer.Compose(messageType, bb.Bytes())
+	if err == nil {
+		err = s.composer.Flush()
+	}
+	s.wmu.Unlock()
+	if err != nil {
+		s.fail(err)
+	}
+	return err
+}
+
+// newStream creates and registers a stream with the specified ID.
+func (s *Session) newStream(id uint64) (*Stream, error) {
+	st := &Stream{
+		id:         id,
+		session:    s,
+		sendWindow: s.window,
+	}
+	st.cond = sync.NewCond(&st.mu)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	s.streams[id] = st
+	return st, nil
+}
+
+// stream returns the registered stream with the specified ID, or nil.
+func (s *Session) stream(id uint64) *Stream {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.streams[id]
+}
+
+// forget removes the stream with the specified ID once neither peer will use
+// it again.
+func (s *Session) forget(id uint64) {
+	s.mu.Lock()
+	delete(s.streams, id)
+	s.mu.Unlock()
+}
+
+// OpenStream opens a new stream to the peer, which receives it from
+// AcceptStream.
+func (s *Session) OpenStream() (*Stream, error) {
+	s.mu.Lock()
+	s.nextID += 2
+	id := s.nextID
+	s.mu.Unlock()
+
+	st, err := s.newStream(id)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.writeFrame(mtOpen, id, 0, nil); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// AcceptStream waits for and returns the next stream opened by the peer.
+func (s *Session) AcceptStream() (*Stream, error) {
+	select {
+	case st := <-s.accept:
+		return st, nil
+	case <-s.done:
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return nil, s.err
+	}
+}
+
+func (s *Session) handleOpen(ior io.Reader) error {
+	var id gobsp.UVWI
+	if err := id.UnmarshalBinaryFrom(ior); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	_, open := s.streams[uint64(id)]
+	ours := uint64(id)%2 == s.nextID%2 // each end allocates IDs of one parity
+	s.mu.Unlock()
+	if open || ours {
+		return ErrInvalidStreamID(id)
+	}
+	st, err := s.newStream(uint64(id))
+	if err != nil {
+		return err
+	}
+	select {
+	case s.accept <- st:
+		return nil
+	default:
+		// Backlog is full: refuse the stream.
+		s.forget(uint64(id))
+		go s.writeFrame(mtClose, uint64(id), 0, nil)
+		return nil
+	}
+}
+
+func (s *Session) handleData(ior io.Reader) error {
+	var id gobsp.UVWI
+	if err := id.UnmarshalBinaryFrom(ior); err != nil {
+		return err
+	}
+	st := s.stream(uint64(id))
+	if st == nil {
+		return gobsp.DiscardAll(ior) // stream already closed locally
+	}
+	return st.receive(ior, s.window)
+}
+
+func (s *Session) handleWindow(ior io.Reader) error {
+	var id, increment gobsp.UVWI
+	if err := id.UnmarshalBinaryFrom(ior); err != nil {
+		return err
+	}
+	if err := increment.UnmarshalBinaryFrom(ior); err != nil {
+		return err
+	}
+	if st := s.stream(uint64(id)); st != nil {
+		st.mu.Lock()
+		st.sendWindow += int(increment)
+		st.cond.Broadcast()
+		st.mu.Unlock()
+	}
+	return nil
+}
+
+func (s *Session) handleClose(ior io.Reader) error {
+	var id gobsp.UVWI
+	if err := id.UnmarshalBinaryFrom(ior); err != nil {
+		return err
+	}
+	if st := s.stream(uint64(id)); st != nil {
+		st.remoteClose()
+	}
+	return nil
+}
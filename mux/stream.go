@@ -0,0 +1,168 @@
+package mux
+
+import (
+	"bytes"
+	"io"
+	"sync"
+
+	"github.com/karrick/gobsp"
+)
+
+// Stream is a single logical stream multiplexed over a Session. It is an
+// io.ReadWriteCloser, normally used with its own Scanner and Composer.
+type Stream struct {
+	id      uint64
+	session *Session
+
+	mu           sync.Mutex // guards all fields below
+	cond         *sync.Cond
+	recv         bytes.Buffer
+	consumed     int  // bytes read since the last window update
+	remoteClosed bool // peer will send no more data
+	localClosed  bool // program will send no more data
+	sendWindow   int
+	err          error
+}
+
+// ID returns the stream's identifier, which is unique within its session.
+func (st *Stream) ID() uint64 {
+	return st.id
+}
+
+// Scanner returns a new gobsp.Scanner that reads messages from the stream.
+func (st *Stream) Scanner(configurators ...gobsp.ScannerConfig) (*gobsp.Scanner, error) {
+	return gobsp.NewScanner(st, configurators...)
+}
+
+// Composer returns a new gobsp.Composer that writes messages to the stream.
+// Messages are not sent until the Composer is flushed.
+func (st *Stream) Composer(configurators ...gobsp.ComposerConfig) (*gobsp.Composer, error) {
+	return gobsp.NewComposerWithOptions(st, configurators...)
+}
+
+// Read reads data received on the stream, blocking until some is available.
+// It returns io.EOF after the peer has closed the stream and all of its data
+// has been read.
+func (st *Stream) Read(p []byte) (int, error) {
+	st.mu.Lock()
+	for st.recv.Len() == 0 && !st.remoteClosed && st.err == nil {
+		st.cond.Wait()
+	}
+	if st.recv.Len() == 0 {
+		err := st.err
+		if err == nil {
+			err = io.EOF
+		}
+		st.mu.Unlock()
+		return 0, err
+	}
+	n, _ := st.recv.Read(p)
+	st.consumed += n
+	var increment int
+	if st.consumed >= st.session.window/2 && !st.remoteClosed {
+		increment, st.consumed = st.consumed, 0
+	}
+	st.mu.Unlock()
+
+	if increment > 0 {
+		// Let the peer send as many bytes as have been consumed.
+		_ = st.session.writeFrame(mtWindow, st.id, uint64(increment), nil)
+	}
+	return n, nil
+}
+
+// Write sends data on the stream, blocking while the peer's flow control
+// window for the stream is exhausted.
+func (st *Stream) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		st.mu.Lock()
+		for st.sendWindow == 0 && !st.localClosed && st.err == nil {
+			st.cond.Wait()
+		}
+		if st.err != nil {
+			err := st.err
+			st.mu.Unlock()
+			return written, err
+		}
+		if st.localClosed {
+			st.mu.Unlock()
+			return written, ErrStreamClosed{}
+		}
+		n := len(p)
+		if n > st.sendWindow {
+			n = st.sendWindow
+		}
+		if n > st.session.maxFrameSize {
+			n = st.session.maxFrameSize
+		}
+		st.sendWindow -= n
+		st.mu.Unlock()
+
+		if err := st.session.writeFrame(mtData, st.id, 0, p[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Close tells the peer no more data will be sent on the stream. Data already
+// received, and data the peer continues to send, may still be read.
+func (st *Stream) Close() error {
+	st.mu.Lock()
+	if st.localClosed || st.err != nil {
+		st.mu.Unlock()
+		return nil
+	}
+	st.localClosed = true
+	remoteClosed := st.remoteClosed
+	st.cond.Broadcast()
+	st.mu.Unlock()
+
+	if remoteClosed {
+		st.session.forget(st.id)
+	}
+	return st.session.writeFrame(mtClose, st.id, 0, nil)
+}
+
+// receive appends the data frame payload from the specified io.Reader to the
+// stream's receive buffer.
+func (st *Stream) receive(ior io.Reader, window int) error {
+	data, err := io.ReadAll(ior)
+	if err != nil {
+		return err
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.recv.Write(data)
+	if st.recv.Len()+st.consumed > window {
+		return ErrWindowExceeded(st.id)
+	}
+	st.cond.Broadcast()
+	return nil
+}
+
+// remoteClose records that the peer will send no more data on the stream.
+func (st *Stream) remoteClose() {
+	st.mu.Lock()
+	st.remoteClosed = true
+	localClosed := st.localClosed
+	st.cond.Broadcast()
+	st.mu.Unlock()
+
+	if localClosed {
+		st.session.forget(st.id)
+	}
+}
+
+// fail records the error that ended the session.
+func (st *Stream) fail(err error) {
+	st.mu.Lock()
+	if st.err == nil {
+		st.err = err
+	}
+	st.cond.Broadcast()
+	st.mu.Unlock()
+}
@@ -0,0 +1,137 @@
+package gobsp
+
+import (
+	"io"
+	"sort"
+)
+
+// Capabilities describes the message types a peer understands and the optional
+// features it supports.
+type Capabilities struct {
+	Types    []MessageType
+	Features Feature
+}
+
+// MarshalBinaryTo writes the feature flags, followed by the number of message
+// types and each message type, to the specified io.Writer.
+func (c Capabilities) MarshalBinaryTo(iow io.Writer) error {
+	if err := UVWI(c.Features).MarshalBinaryTo(iow); err != nil {
+		return err
+	}
+	if err := UVWI(len(c.Types)).MarshalBinaryTo(iow); err != nil {
+		return err
+	}
+	for _, mt := range c.Types {
+		if err := UVWI(mt).MarshalBinaryTo(iow); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UnmarshalBinaryFrom reads capabilities from the specified io.Reader.
+func (c *Capabilities) UnmarshalBinaryFrom(ior io.Reader) error {
+	var features, size UVWI
+	if err := features.UnmarshalBinaryFrom(ior); err != nil {
+		return err
+	}
+	if err := size.UnmarshalBinaryFrom(ior); err != nil {
+		return err
+	}
+	var types []MessageType
+	for i := UVWI(0); i < size; i++ {
+		var mt UVWI
+		if err := mt.UnmarshalBinaryFrom(ior); err != nil {
+			return err
+		}
+		types = append(types, MessageType(mt))
+	}
+	c.Features = Feature(features)
+	c.Types = types
+	return nil
+}
+
+// intersect returns the capabilities common to both c and other, with the
+// message types in ascending order.
+func (c Capabilities) intersect(other Capabilities) Capabilities {
+	theirs := make(map[MessageType]struct{}, len(other.Types))
+	for _, mt := range other.Types {
+		theirs[mt] = struct{}{}
+	}
+	agreed := Capabilities{Features: c.Features & other.Features, Types: []MessageType{}}
+	for _, mt := range c.Types {
+		if _, ok := theirs[mt]; ok {
+			agreed.Types = append(agreed.Types, mt)
+			delete(theirs, mt) // ignore duplicates
+		}
+	}
+	sort.Slice(agreed.Types, func(i, j int) bool { return agreed.Types[i] < agreed.Types[j] })
+	return agreed
+}
+
+// ErrUnexpectedMessageType is an error that is returned when a particular
+// reserved message type is required, but the stream provided a different
+// message type.
+type ErrUnexpectedMessageType MessageType
+
+func (e ErrUnexpectedMessageType) Error() string {
+	return "unexpected message type: " + UVWI(e).String()
+}
+
+// ErrPeerUnsupportedMessageType is an error that is returned by
+// Composer.Compose when asked to send a message type the peer did not
+// advertise during Negotiate.
+type ErrPeerUnsupportedMessageType MessageType
+
+func (e ErrPeerUnsupportedMessageType) Error() string {
+	return "message type not supported by peer: " + UVWI(e).String()
+}
+
+// Negotiate performs a capability handshake with a peer. It sends the local
+// capabilities using the specified Composer, reads the peer's capabilities
+// using the specified Scanner, and returns the capabilities both peers have in
+// common. Both peers must call Negotiate before sending any other messages.
+//
+// Afterwards, the Composer refuses to send any message type not in the agreed
+// set, returning ErrPeerUnsupportedMessageType. Reserved control message types
+// are always permitted.
+//
+// Because both peers write before they read, the underlying transport must be
+// able to buffer a capabilities message, as network sockets do.
+func Negotiate(s *Scanner, w *Composer, local Capabilities) (Capabilities, error) {
+	if err := w.ComposeBinary(MTCapabilities, &local); err != nil {
+		return Capabilities{}, err
+	}
+	if err := w.Flush(); err != nil {
+		return Capabilities{}, err
+	}
+
+	if !s.Scan() {
+		if err := s.Err(); err != nil {
+			return Capabilities{}, err
+		}
+		return Capabilities{}, io.ErrUnexpectedEOF
+	}
+	if MessageType(s.messageType) != MTCapabilities {
+		return Capabilities{}, ErrUnexpectedMessageType(s.messageType)
+	}
+	body, finish := s.body()
+	defer finish()
+	var remote Capabilities
+	if err := remote.UnmarshalBinaryFrom(body); err != nil {
+		return Capabilities{}, unexpectedEOF(err)
+	}
+	if err := DiscardAll(body); err != nil {
+		return Capabilities{}, err
+	}
+
+	agreed := local.intersect(remote)
+	allowed := make(map[MessageType]struct{}, len(agreed.Types))
+	for _, mt := range agreed.Types {
+		allowed[mt] = struct{}{}
+	}
+	w.amu.Lock()
+	w.allowed = allowed
+	w.amu.Unlock()
+	return agreed, nil
+}
@@ -0,0 +1,190 @@
+package gobsp
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+)
+
+// testPeers returns a Scanner and Composer for each end of a pair of connected
+// pipes.
+func testPeers(t *testing.T) (*Scanner, *Composer, *Scanner, *Composer) {
+	aToBReader, aToBWriter, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bToAReader, bToAWriter, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		aToBReader.Close()
+		aToBWriter.Close()
+		bToAReader.Close()
+		bToAWriter.Close()
+	})
+
+	peer := func(ior io.Reader, iow io.Writer) (*Scanner, *Composer) {
+		s, err := NewScanner(ior, DefaultHandler(DiscardAll))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := NewComposer(iow)
+		return s, w
+	}
+
+	as, aw := peer(bToAReader, aToBWriter)
+	bs, bw := peer(aToBReader, bToAWriter)
+	return as, aw, bs, bw
+}
+
+func TestCapabilitiesRoundTrip(t *testing.T) {
+	bb := new(bytes.Buffer)
+	vin := Capabilities{Types: []MessageType{1, 300}, Features: FeatureCompression}
+	if err := vin.MarshalBinaryTo(bb); err != nil {
+		t.Fatal(err)
+	}
+	if actual, expected := bb.Bytes(), []byte("\x02\x02\x01\xac\x02"); !bytes.Equal(actual, expected) {
+		t.Errorf("Actual: %#v; Expected: %#v", actual, expected)
+	}
+	var vout Capabilities
+	if err := vout.UnmarshalBinaryFrom(bb); err != nil {
+		t.Fatal(err)
+	}
+	ensure(t, vout.Features, vin.Features)
+	ensure(t, len(vout.Types), 2)
+	ensure(t, vout.Types[1], MessageType(300))
+}
+
+func TestNegotiate(t *testing.T) {
+	as, aw, bs, bw := testPeers(t)
+
+	done := make(chan Capabilities)
+	go func() {
+		agreed, err := Negotiate(bs, bw, Capabilities{
+			Types:    []MessageType{2, 3, 4},
+			Features: FeatureChecksums,
+		})
+		if err != nil {
+			t.Error(err)
+		}
+		done <- agreed
+	}()
+
+	agreed, err := Negotiate(as, aw, Capabilities{
+		Types:    []MessageType{4, 1, 2},
+		Features: FeatureChecksums | FeatureCompression,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	peerAgreed := <-done
+
+	ensure(t, agreed.Features, FeatureChecksums)
+	ensure(t, len(agreed.Types), 2)
+	ensure(t, agreed.Types[0], MessageType(2))
+	ensure(t, agreed.Types[1], MessageType(4))
+	ensure(t, len(peerAgreed.Types), 2)
+
+	ensure(t, aw.Compose(2, nil), nil)
+	ensure(t, aw.Compose(1, nil), error(ErrPeerUnsupportedMessageType(1)))
+	ensure(t, aw.Compose(3, nil), error(ErrPeerUnsupportedMessageType(3)))
+}
+
+func TestNegotiateUnexpectedMessageType(t *testing.T) {
+	bb := bytes.NewBuffer([]byte{0x05, 0x00})
+	s, err := NewScanner(bb, DefaultHandler(DiscardAll))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := NewComposer(io.Discard)
+	_, err = Negotiate(s, w, Capabilities{})
+	ensure(t, err, error(ErrUnexpectedMessageType(5)))
+}
+
+func TestNegotiateFragmented(t *testing.T) {
+	remote := new(bytes.Buffer)
+	rw, err := NewComposerWithOptions(remote, MaxFrameSize(32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []MessageType
+	for i := 1; i <= 40; i++ {
+		types = append(types, MessageType(i))
+	}
+	ensure(t, rw.ComposeBinary(MTCapabilities, &Capabilities{Types: types}), nil)
+	ensure(t, rw.Compose(41, []byte("after")), nil)
+	ensure(t, rw.Close(), nil)
+
+	var received []string
+	s, err := NewScanner(remote, DefaultHandler(func(ior io.Reader) error {
+		buf, err := io.ReadAll(ior)
+		received = append(received, string(buf))
+		return err
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	agreed, err := Negotiate(s, NewComposer(io.Discard), Capabilities{Types: []MessageType{40, 41}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ensure(t, len(agreed.Types), 1)
+	ensure(t, agreed.Types[0], MessageType(40))
+
+	// the Scanner resumes with the message after the capabilities
+	for s.Scan() {
+		ensure(t, s.Handle(), nil)
+	}
+	ensure(t, s.Err(), nil)
+	ensure(t, len(received), 1)
+	ensure(t, received[0], "after")
+}
+
+// TestNegotiateWhileComposing composes messages from another goroutine while
+// Negotiate records the agreed message types, so that `go test -race` reports
+// any unguarded access to them.
+func TestNegotiateWhileComposing(t *testing.T) {
+	remote := new(bytes.Buffer)
+	rw := NewComposer(remote)
+	if err := rw.ComposeBinary(MTCapabilities, &Capabilities{Types: []MessageType{1}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := rw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewScanner(remote, DefaultHandler(DiscardAll))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := NewComposer(io.Discard)
+
+	started := make(chan struct{})
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+				_ = w.Compose(1, nil)
+				_ = w.Compose(2, nil)
+			}
+			if i == 0 {
+				close(started)
+			}
+		}
+	}()
+	<-started
+	_, err = Negotiate(s, w, Capabilities{Types: []MessageType{1, 2}})
+	close(stop)
+	<-done
+	if err != nil {
+		t.Fatal(err)
+	}
+	ensure(t, w.Compose(1, nil), nil)
+	ensure(t, w.Compose(2, nil), error(ErrPeerUnsupportedMessageType(2)))
+}
@@ -0,0 +1,181 @@
+package gobsp
+
+import (
+	"io"
+	"strconv"
+)
+
+// preambleMagic are the bytes that begin every gobsp stream that has a
+// preamble.
+var preambleMagic = [4]byte{'G', 'B', 'S', 'P'}
+
+// FramingVersion is the version of the stream framing this library writes in
+// its preambles, and the greatest version it is able to read.
+const FramingVersion = 1
+
+// Feature is a set of flags declaring which optional protocol features are
+// enabled for a particular stream.
+type Feature UVWI
+
+const (
+	// FeatureChecksums declares that the stream carries checksums.
+	FeatureChecksums Feature = 1 << iota
+
+	// FeatureCompression declares that the stream carries compressed data.
+	FeatureCompression
+)
+
+func (f Feature) String() string {
+	return UVWI(f).String()
+}
+
+// Preamble is the optional header written once at the start of a stream,
+// before any messages. It identifies the stream as gobsp, declares the framing
+// version and enabled features, and names the application protocol carried by
+// the stream, so that a peer connected to the wrong service, or running an
+// incompatible version, can be detected before any messages are decoded.
+type Preamble struct {
+	Version     Uint8
+	Features    Feature
+	Application String
+}
+
+// MarshalBinaryTo writes the preamble magic bytes followed by the preamble
+// fields to the specified io.Writer.
+func (p Preamble) MarshalBinaryTo(iow io.Writer) error {
+	if _, err := iow.Write(preambleMagic[:]); err != nil {
+		return err
+	}
+	if err := p.Version.MarshalBinaryTo(iow); err != nil {
+		return err
+	}
+	if err := UVWI(p.Features).MarshalBinaryTo(iow); err != nil {
+		return err
+	}
+	return p.Application.MarshalBinaryTo(iow)
+}
+
+// UnmarshalBinaryFrom reads a preamble from the specified io.Reader. It returns
+// io.EOF when the stream ends before the first byte of the preamble, and
+// ErrNotGobspStream when the stream does not begin with the preamble magic
+// bytes.
+func (p *Preamble) UnmarshalBinaryFrom(ior io.Reader) error {
+	var magic [len(preambleMagic)]byte
+	if _, err := io.ReadFull(ior, magic[:]); err != nil {
+		return err
+	}
+	if magic != preambleMagic {
+		return ErrNotGobspStream{}
+	}
+	if err := p.Version.UnmarshalBinaryFrom(ior); err != nil {
+		return unexpectedEOF(err)
+	}
+	var features UVWI
+	if err := features.UnmarshalBinaryFrom(ior); err != nil {
+		return unexpectedEOF(err)
+	}
+	p.Features = Feature(features)
+	return unexpectedEOF(p.Application.UnmarshalBinaryFrom(ior))
+}
+
+// unexpectedEOF converts io.EOF to io.ErrUnexpectedEOF, for use when the
+// stream ends part way through a structure.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// ErrNotGobspStream is an error that is returned when a preamble is expected
+// but the stream does not begin with the preamble magic bytes.
+type ErrNotGobspStream struct{}
+
+func (e ErrNotGobspStream) Error() string {
+	return "not a gobsp stream: bad preamble magic"
+}
+
+// ErrUnsupportedVersion is an error that is returned when a preamble declares a
+// framing version newer than FramingVersion.
+type ErrUnsupportedVersion Uint8
+
+func (e ErrUnsupportedVersion) Error() string {
+	return "unsupported framing version: " + Uint8(e).String()
+}
+
+// ErrUnsupportedFeatures is an error that is returned when a preamble declares
+// features the recipient does not support. Its value is the set of features
+// that are not supported.
+type ErrUnsupportedFeatures Feature
+
+func (e ErrUnsupportedFeatures) Error() string {
+	return "unsupported features: " + Feature(e).String()
+}
+
+// ErrApplicationMismatch is an error that is returned when a preamble names a
+// different application than the one expected.
+type ErrApplicationMismatch struct {
+	Expected, Actual string
+}
+
+func (e ErrApplicationMismatch) Error() string {
+	return "application mismatch: expected " + strconv.Quote(e.Expected) + "; received " + strconv.Quote(e.Actual)
+}
+
+// WritePreamble causes a newly created Composer to write a preamble naming the
+// specified application and features before any messages.
+func WritePreamble(application string, features Feature) ComposerConfig {
+	return func(w *Composer) error {
+		p := Preamble{
+			Version:     FramingVersion,
+			Features:    features,
+			Application: String(application),
+		}
+		return p.MarshalBinaryTo(w.bw)
+	}
+}
+
+// CheckPreamble causes a Scanner to read a preamble from the stream before the
+// first message, and to invoke the specified callback with it. When the
+// callback returns an error, the scanner stops with that error. The callback
+// may also be used to adapt the program's behavior to what the peer declared.
+func CheckPreamble(callback func(Preamble) error) ScannerConfig {
+	return func(s *Scanner) error {
+		s.preambleCheck = callback
+		s.preambleOptional = false
+		return nil
+	}
+}
+
+// OptionalPreamble is like CheckPreamble, except that the stream need not begin
+// with a preamble: the Scanner reads one, and invokes the callback with it, only
+// when the stream begins with the preamble magic bytes. It suits tools that
+// read streams which may or may not have been written with a preamble. Because
+// the Scanner waits for the first four bytes of the stream to decide, it should
+// not be used on a connection whose peer may send fewer and then await a reply.
+func OptionalPreamble(callback func(Preamble) error) ScannerConfig {
+	return func(s *Scanner) error {
+		s.preambleCheck = callback
+		s.preambleOptional = true
+		return nil
+	}
+}
+
+// ExpectPreamble causes a Scanner to read a preamble from the stream before the
+// first message, and to refuse the stream when the preamble declares a newer
+// framing version, any features not in the specified set, or, when application
+// is not empty, a different application.
+func ExpectPreamble(application string, features Feature) ScannerConfig {
+	return CheckPreamble(func(p Preamble) error {
+		if p.Version > FramingVersion {
+			return ErrUnsupportedVersion(p.Version)
+		}
+		if unsupported := p.Features &^ features; unsupported != 0 {
+			return ErrUnsupportedFeatures(unsupported)
+		}
+		if application != "" && string(p.Application) != application {
+			return ErrApplicationMismatch{Expected: application, Actual: string(p.Application)}
+		}
+		return nil
+	})
+}
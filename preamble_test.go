@@ -0,0 +1,128 @@
+package gobsp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+)
+
+func TestPreambleRoundTrip(t *testing.T) {
+	bb := new(bytes.Buffer)
+	vin := Preamble{Version: FramingVersion, Features: FeatureChecksums, Application: "app"}
+	if err := vin.MarshalBinaryTo(bb); err != nil {
+		t.Fatal(err)
+	}
+	if actual, expected := bb.Bytes(), []byte("GBSP\x01\x01\x03app"); !bytes.Equal(actual, expected) {
+		t.Errorf("Actual: %#v; Expected: %#v", actual, expected)
+	}
+	var vout Preamble
+	if err := vout.UnmarshalBinaryFrom(bb); err != nil {
+		t.Fatal(err)
+	}
+	ensure(t, vout, vin)
+}
+
+func TestPreambleBadMagic(t *testing.T) {
+	var p Preamble
+	ensure(t, p.UnmarshalBinaryFrom(bytes.NewReader([]byte("HTTP/1.1 200 OK"))), error(ErrNotGobspStream{}))
+}
+
+func TestPreambleTruncated(t *testing.T) {
+	var p Preamble
+	ensure(t, p.UnmarshalBinaryFrom(bytes.NewReader([]byte("GBSP\x01"))), io.ErrUnexpectedEOF)
+}
+
+func TestScannerExpectPreamble(t *testing.T) {
+	bb := new(bytes.Buffer)
+	composer, err := NewComposerWithOptions(bb, WritePreamble("app", FeatureChecksums))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := composer.Compose(1, []byte("hi")); err != nil {
+		t.Fatal(err)
+	}
+	if err := composer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var body []byte
+	handlers := map[uint32]MessageHandler{
+		1: func(ior io.Reader) error {
+			var err error
+			body, err = io.ReadAll(ior)
+			return err
+		},
+	}
+
+	scanner, err := NewScanner(bb, Handlers(handlers), ExpectPreamble("app", FeatureChecksums|FeatureCompression))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ensure(t, scanner.Scan(), true)
+	ensure(t, scanner.Handle(), nil)
+	ensure(t, string(body), "hi")
+	ensure(t, scanner.Preamble().Application, String("app"))
+	ensure(t, scanner.Scan(), false)
+	ensure(t, scanner.Err(), nil)
+}
+
+func TestScannerExpectPreambleRefuses(t *testing.T) {
+	test := func(t *testing.T, p Preamble, expected error) {
+		bb := new(bytes.Buffer)
+		if err := p.MarshalBinaryTo(bb); err != nil {
+			t.Fatal(err)
+		}
+		scanner, err := NewScanner(bb, DefaultHandler(DiscardAll), ExpectPreamble("app", FeatureChecksums))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ensure(t, scanner.Scan(), false)
+		ensure(t, scanner.Err(), expected)
+	}
+
+	test(t, Preamble{Version: FramingVersion + 1, Application: "app"}, ErrUnsupportedVersion(FramingVersion+1))
+	test(t, Preamble{Version: FramingVersion, Features: FeatureCompression, Application: "app"}, ErrUnsupportedFeatures(FeatureCompression))
+	test(t, Preamble{Version: FramingVersion, Application: "other"}, ErrApplicationMismatch{Expected: "app", Actual: "other"})
+}
+
+func TestScannerExpectPreambleWithoutPreamble(t *testing.T) {
+	bb := bytes.NewBuffer([]byte{0x00, 0x00})
+	scanner, err := NewScanner(bb, DefaultHandler(DiscardAll), ExpectPreamble("", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ensure(t, scanner.Scan(), false)
+	ensure(t, scanner.Err(), io.ErrUnexpectedEOF)
+}
+
+func TestScannerOptionalPreamble(t *testing.T) {
+	test := func(t *testing.T, stream []byte, expected []string) {
+		t.Helper()
+		var actual []string
+		scanner, err := NewScanner(bytes.NewReader(stream),
+			OptionalPreamble(func(p Preamble) error {
+				actual = append(actual, "preamble "+string(p.Application))
+				return nil
+			}),
+			DefaultHandler(func(ior io.Reader) error {
+				body, err := io.ReadAll(ior)
+				actual = append(actual, string(body))
+				return err
+			}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for scanner.Scan() {
+			ensure(t, scanner.Handle(), nil)
+		}
+		ensure(t, scanner.Err(), nil)
+		ensure(t, fmt.Sprint(actual), fmt.Sprint(expected))
+	}
+
+	test(t, []byte("GBSP\x01\x00\x03app\x01\x02hi"), []string{"preamble app", "hi"})
+	test(t, []byte("\x01\x02hi"), []string{"hi"})
+	test(t, []byte("\x01\x00"), []string{""}) // shorter than the magic bytes
+	test(t, nil, nil)
+}
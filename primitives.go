@@ -1,7 +1,9 @@
 package gobsp
 
 import (
+	"bytes"
 	"io"
+	"math"
 	"strconv"
 	"unsafe"
 )
@@ -278,6 +280,17 @@ func encodeVWI(iow io.Writer, value uint64) error {
 	return nil
 }
 
+// ErrVWIOverflow is an error that is returned when a variable width integer
+// holds more than 64 bits.
+type ErrVWIOverflow struct{}
+
+func (e ErrVWIOverflow) Error() string {
+	return "variable width integer overflows 64 bits"
+}
+
+// decodeVWI reads a variable width integer from ior. It returns io.EOF when the
+// stream ends before the first byte of the number, and io.ErrUnexpectedEOF when
+// it ends part way through the number.
 func decodeVWI(ior io.Reader) (uint64, error) {
 	const mask = byte(127)
 	const flag = byte(128)
@@ -288,8 +301,14 @@ func decodeVWI(ior io.Reader) (uint64, error) {
 		for shift := uint(0); ; shift += 7 {
 			b, err := iobr.ReadByte()
 			if err != nil {
+				if err == io.EOF && shift > 0 {
+					err = io.ErrUnexpectedEOF // stream ends part way through the number
+				}
 				return 0, err
 			}
+			if shift == 63 && b > 1 {
+				return 0, ErrVWIOverflow{} // only the lowest bit remains
+			}
 			value |= uint64(b&mask) << shift
 			if b&flag == 0 {
 				break
@@ -302,9 +321,15 @@ func decodeVWI(ior io.Reader) (uint64, error) {
 	var buf [1]byte
 	for shift := uint(0); ; shift += 7 {
 		if _, err := io.ReadFull(ior, buf[:]); err != nil {
+			if err == io.EOF && shift > 0 {
+				err = io.ErrUnexpectedEOF
+			}
 			return 0, err
 		}
 		b := buf[0]
+		if shift == 63 && b > 1 {
+			return 0, ErrVWIOverflow{}
+		}
 		value |= uint64(b&mask) << shift
 		if b&flag == 0 {
 			break
@@ -422,6 +447,10 @@ func (v Float64) String() string {
 	return strconv.FormatFloat(float64(v), 'g', -1, 64)
 }
 
+// maxPreallocate is the most bytes String and StringSlice allocate for their
+// contents before reading them.
+const maxPreallocate = 4096
+
 type String string
 
 func (v String) MarshalBinaryTo(iow io.Writer) error {
@@ -437,12 +466,29 @@ func (v *String) UnmarshalBinaryFrom(ior io.Reader) error {
 	if err := size.UnmarshalBinaryFrom(ior); err != nil {
 		return err
 	}
-	buf := make([]byte, size)
-	_, err := io.ReadFull(ior, buf)
-	if err == nil {
-		*v = String(buf)
+	if size <= maxPreallocate {
+		buf := make([]byte, size)
+		_, err := io.ReadFull(ior, buf)
+		if err == nil {
+			*v = String(buf)
+		}
+		return err
 	}
-	return err
+	// larger strings grow as their bytes arrive
+	want := int64(math.MaxInt64)
+	if size < math.MaxInt64 {
+		want = int64(size)
+	}
+	var bb bytes.Buffer
+	n, err := io.CopyN(&bb, ior, want)
+	if err != nil {
+		if err == io.EOF && n > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	*v = String(bb.String())
+	return nil
 }
 
 func (v String) String() string {
@@ -469,13 +515,17 @@ func (v *StringSlice) UnmarshalBinaryFrom(ior io.Reader) error {
 	if err := size.UnmarshalBinaryFrom(ior); err != nil {
 		return err
 	}
-	ss := make([]String, size)
+	capacity := uint64(size)
+	if capacity > maxPreallocate/16 {
+		capacity = maxPreallocate / 16 // a String is 16 bytes
+	}
+	ss := make([]String, 0, capacity)
 	for i := uint64(0); i < uint64(size); i++ {
 		var s String
 		if err := s.UnmarshalBinaryFrom(ior); err != nil {
 			return err
 		}
-		ss[i] = s
+		ss = append(ss, s)
 	}
 	*v = ss
 	return nil
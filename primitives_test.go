@@ -2,6 +2,7 @@ package gobsp
 
 import (
 	"bytes"
+	"io"
 	"math"
 	"testing"
 
@@ -531,6 +532,45 @@ func TestBinaryUVWIMultipleBytes(t *testing.T) {
 	testBinaryUVWI(t, 0x10000001, []byte("\x81\x80\x80\x80\x01"))
 }
 
+////////////////////////////////////////
+// VWI and UVWI -- truncated streams
+////////////////////////////////////////
+
+// readerOnly hides all methods of an io.Reader other than Read.
+type readerOnly struct {
+	io.Reader
+}
+
+// truncatedReaders returns streams holding buf, both ones that implement
+// io.ByteReader and one that does not.
+func truncatedReaders(buf []byte) []io.Reader {
+	bb := new(buffer.Buffer)
+	bb.Write(buf)
+	return []io.Reader{bb, bytes.NewBuffer(buf), readerOnly{bytes.NewReader(buf)}}
+}
+
+func testTruncatedVWI(t *testing.T, buf []byte, expected error) {
+	for _, ior := range truncatedReaders(buf) {
+		var v VWI
+		if actual := v.UnmarshalBinaryFrom(ior); actual != expected {
+			t.Errorf("VWI from %T: Actual: %#v; Expected: %#v", ior, actual, expected)
+		}
+	}
+	for _, ior := range truncatedReaders(buf) {
+		var v UVWI
+		if actual := v.UnmarshalBinaryFrom(ior); actual != expected {
+			t.Errorf("UVWI from %T: Actual: %#v; Expected: %#v", ior, actual, expected)
+		}
+	}
+}
+
+func TestBinaryVWITruncated(t *testing.T) {
+	testTruncatedVWI(t, nil, io.EOF)
+	testTruncatedVWI(t, []byte("\x80"), io.ErrUnexpectedEOF)
+	testTruncatedVWI(t, []byte("\xFF\xFF"), io.ErrUnexpectedEOF)
+	testTruncatedVWI(t, []byte("\x88\x88\x88\x88\x88\x88\x88\x88\x88"), io.ErrUnexpectedEOF)
+}
+
 ////////////////////////////////////////
 
 func benchmarkCodec(b *testing.B, scratch testBuffer, vin, vout Binary) {
@@ -0,0 +1,182 @@
+package gobsp
+
+import (
+	"strconv"
+	"sync"
+)
+
+// DefaultPriorityFrameSize is the largest frame a PrioritySender writes when
+// its Composer was not configured with MaxFrameSize.
+const DefaultPriorityFrameSize = 16 << 10
+
+// ErrInvalidPriority is an error that is returned when a message is sent with a
+// priority the PrioritySender does not have.
+type ErrInvalidPriority int
+
+func (e ErrInvalidPriority) Error() string {
+	return "invalid priority: " + strconv.Itoa(int(e))
+}
+
+// ErrPrioritySenderClosed is an error that is returned when a message is sent
+// after the PrioritySender has been closed.
+type ErrPrioritySenderClosed struct{}
+
+func (e ErrPrioritySenderClosed) Error() string {
+	return "priority sender closed"
+}
+
+// PrioritySender sends messages through a Composer in order of priority. It
+// splits each message larger than a single frame into fragments, and writes
+// them one frame at a time, always choosing the next frame from the most urgent
+// message waiting. An urgent message sent while a large message is being
+// written therefore waits for at most one frame of the large message, rather
+// than the whole of it. The receiving Scanner reassembles each message.
+//
+// Messages having the same priority are written in the order they were sent.
+// PrioritySender is safe for concurrent use by multiple goroutines.
+type PrioritySender struct {
+	w         *Composer
+	frameSize int
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queues  [][]*prioritizedMessage // index 0 holds the most urgent messages
+	pending int                     // messages in all queues
+	closed  bool
+	err     error // first write error
+	done    chan struct{}
+}
+
+// prioritizedMessage is a message waiting in a PrioritySender queue, split
+// into the bodies of the frames that carry it.
+type prioritizedMessage struct {
+	messageType MessageType // MTFragment when the message is fragmented
+	frames      [][]byte
+	next        int        // index of the next frame to write
+	sent        chan error // receives the result once the message is written
+}
+
+// PrioritySender returns a new PrioritySender that sends messages having
+// priorities from 0, the most urgent, to levels-1, the least urgent, using the
+// Composer. Each frame it writes is no larger than the Composer's
+// MaxFrameSize, or DefaultPriorityFrameSize when the Composer has none.
+func (w *Composer) PrioritySender(levels int) *PrioritySender {
+	if levels < 1 {
+		levels = 1
+	}
+	p := &PrioritySender{
+		w:         w,
+		frameSize: w.maxFrameSize,
+		queues:    make([][]*prioritizedMessage, levels),
+		done:      make(chan struct{}),
+	}
+	if p.frameSize == 0 {
+		p.frameSize = DefaultPriorityFrameSize
+	}
+	p.cond = sync.NewCond(&p.mu)
+	go p.run()
+	return p
+}
+
+// Send queues a message having the specified priority, type, and body, then
+// waits until the message has been written and flushed.
+func (p *PrioritySender) Send(priority int, messageType MessageType, messageBody []byte) error {
+	if priority < 0 || priority >= len(p.queues) {
+		return ErrInvalidPriority(priority)
+	}
+	if err := p.w.permitted(messageType); err != nil {
+		return err
+	}
+	m := &prioritizedMessage{messageType: messageType, sent: make(chan error, 1)}
+	if len(messageBody) > p.frameSize {
+		m.messageType = MTFragment
+		m.frames = fragments(p.w.fragmentID(), messageType, messageBody, p.frameSize)
+	} else {
+		m.frames = [][]byte{messageBody}
+	}
+
+	p.mu.Lock()
+	if p.err != nil {
+		p.mu.Unlock()
+		return p.err
+	}
+	if p.closed {
+		p.mu.Unlock()
+		return ErrPrioritySenderClosed{}
+	}
+	p.queues[priority] = append(p.queues[priority], m)
+	p.pending++
+	p.cond.Signal()
+	p.mu.Unlock()
+
+	return <-m.sent
+}
+
+// run writes frames from the queues, most urgent first, until the
+// PrioritySender is closed and every queued message has been written.
+func (p *PrioritySender) run() {
+	defer close(p.done)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for {
+		for p.pending == 0 && !p.closed {
+			p.cond.Wait()
+		}
+		if p.pending == 0 {
+			return
+		}
+
+		var level int
+		for len(p.queues[level]) == 0 {
+			level++
+		}
+		m := p.queues[level][0]
+		body := m.frames[m.next]
+		m.next++
+		last := m.next == len(m.frames)
+		if last {
+			p.queues[level] = p.queues[level][1:]
+			p.pending--
+		}
+
+		p.mu.Unlock()
+		err := p.w.write(m.messageType, body, last) // flush each completed message
+		p.mu.Lock()
+
+		if last {
+			m.sent <- err
+		}
+		if err != nil {
+			p.fail(err)
+		}
+	}
+}
+
+// fail records a write error, and returns it to the sender of every queued
+// message. The caller must hold p.mu.
+func (p *PrioritySender) fail(err error) {
+	if p.err == nil {
+		p.err = err
+	}
+	for level, queue := range p.queues {
+		for _, m := range queue {
+			m.sent <- err
+		}
+		p.queues[level] = nil
+	}
+	p.pending = 0
+}
+
+// Close waits for every queued message to be written, then stops the
+// PrioritySender. It does not close the Composer.
+func (p *PrioritySender) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	p.cond.Signal()
+	p.mu.Unlock()
+	<-p.done
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
@@ -0,0 +1,122 @@
+package gobsp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gateWriter blocks every write until its gate is opened, and closes blocked
+// when the first write arrives.
+type gateWriter struct {
+	gate    chan struct{}
+	blocked chan struct{}
+	once    sync.Once
+	cw      countingWriter
+}
+
+func (g *gateWriter) Write(buf []byte) (int, error) {
+	g.once.Do(func() { close(g.blocked) })
+	<-g.gate
+	return g.cw.Write(buf)
+}
+
+// testQueued waits until the PrioritySender has the specified number of
+// messages queued having the specified priority.
+func testQueued(t *testing.T, p *PrioritySender, priority, count int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		p.mu.Lock()
+		n := len(p.queues[priority])
+		p.mu.Unlock()
+		if n == count {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Actual: %d queued; Expected: %d", n, count)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPrioritySenderPreemptsBulk(t *testing.T) {
+	g := &gateWriter{gate: make(chan struct{}), blocked: make(chan struct{})}
+	w, err := NewComposerWithOptions(g, MaxFrameSize(64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := w.PrioritySender(2)
+
+	bulk := bytes.Repeat([]byte("0123456789"), 10000)
+	bulkSent := make(chan error, 1)
+	go func() { bulkSent <- p.Send(1, 1, bulk) }()
+	testQueued(t, p, 1, 1)
+	<-g.blocked // the bulk message is part way through being written
+
+	urgentSent := make(chan error, 1)
+	go func() { urgentSent <- p.Send(0, 2, []byte("urgent")) }()
+	testQueued(t, p, 0, 1)
+
+	close(g.gate) // let the writes proceed
+	ensure(t, <-urgentSent, nil)
+	ensure(t, <-bulkSent, nil)
+	ensure(t, p.Close(), nil)
+	ensure(t, w.Close(), nil)
+
+	// The urgent message was written between fragments of the bulk message.
+	br := bytes.NewReader(g.cw.bb.Bytes())
+	var fragmentsBefore, fragmentsAfter int
+	var urgent bool
+	for br.Len() > 0 {
+		var messageType, messageSize UVWI
+		ensure(t, messageType.UnmarshalBinaryFrom(br), nil)
+		ensure(t, messageSize.UnmarshalBinaryFrom(br), nil)
+		_, _ = br.Seek(int64(messageSize), io.SeekCurrent)
+		switch {
+		case MessageType(messageType) == 2:
+			urgent = true
+		case urgent:
+			fragmentsAfter++
+		default:
+			fragmentsBefore++
+		}
+	}
+	ensure(t, urgent, true)
+	if fragmentsBefore == 0 || fragmentsAfter == 0 {
+		t.Errorf("Actual: %d fragments before and %d after; Expected: urgent message between fragments", fragmentsBefore, fragmentsAfter)
+	}
+
+	received := testScanAll(t, bytes.NewReader(g.cw.bb.Bytes()))
+	ensure(t, len(received), 2)
+	ensure(t, received[0], "1:"+string(bulk))
+	ensure(t, received[1], "2:urgent")
+}
+
+func TestPrioritySenderInvalidPriority(t *testing.T) {
+	w := NewComposer(new(bytes.Buffer))
+	p := w.PrioritySender(2)
+	ensure(t, p.Send(2, 1, nil), error(ErrInvalidPriority(2)))
+	ensure(t, p.Send(-1, 1, nil), error(ErrInvalidPriority(-1)))
+	ensure(t, p.Close(), nil)
+	ensure(t, p.Send(0, 1, nil), error(ErrPrioritySenderClosed{}))
+}
+
+var errWriteFailed = errors.New("write failed")
+
+// failingWriter fails every write.
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errWriteFailed
+}
+
+func TestPrioritySenderWriteError(t *testing.T) {
+	w := NewComposer(failingWriter{})
+	p := w.PrioritySender(1)
+	ensure(t, p.Send(0, 1, []byte("a")), errWriteFailed)
+	ensure(t, p.Send(0, 1, []byte("b")), errWriteFailed)
+	ensure(t, p.Close(), errWriteFailed)
+}
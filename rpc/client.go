@@ -0,0 +1,193 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+
+	"github.com/karrick/gobsp"
+)
+
+// Client sends requests to a Server over a single connection. It is safe for
+// concurrent use by multiple goroutines, each of which may have a call in
+// flight at the same time.
+type Client struct {
+	wmu      sync.Mutex // guards composer
+	composer *gobsp.Composer
+	mu       sync.Mutex // guards nextID, pending, and err
+	nextID   uint64
+	pending  map[uint64]*call
+	err      error // set when the connection fails
+	done     chan struct{}
+}
+
+// call is a request awaiting its response.
+type call struct {
+	resp gobsp.Binary
+	done chan error
+}
+
+// NewClient returns a new Client that sends requests to, and receives
+// responses from, the specified connection. The client reads responses in a
+// background goroutine until the connection ends.
+func NewClient(rw io.ReadWriter) (*Client, error) {
+	composer := gobsp.NewComposer(rw)
+	c := &Client{
+		composer: composer,
+		pending:  make(map[uint64]*call),
+		done:     make(chan struct{}),
+	}
+	scanner, err := gobsp.NewScanner(rw,
+		gobsp.DefaultHandler(gobsp.DiscardAll),
+		gobsp.Handlers(map[uint32]gobsp.MessageHandler{
+			uint32(MTResponse):      c.handleResponse,
+			uint32(MTErrorResponse): c.handleErrorResponse,
+		}))
+	if err != nil {
+		return nil, err
+	}
+	go c.receive(scanner)
+	return c, nil
+}
+
+// receive dispatches responses until the connection ends, then fails every
+// pending call.
+func (c *Client) receive(scanner *gobsp.Scanner) {
+	var err error
+	for scanner.Scan() {
+		if err = scanner.Handle(); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = scanner.Err()
+	}
+	if err == nil {
+		err = ErrClosed{}
+	}
+
+	c.mu.Lock()
+	c.err = err
+	pending := c.pending
+	c.pending = make(map[uint64]*call)
+	c.mu.Unlock()
+
+	for _, cl := range pending {
+		cl.done <- err
+	}
+	close(c.done)
+}
+
+// claim removes and returns the pending call with the request ID read from the
+// specified io.Reader, or nil when no call is waiting for that ID, as happens
+// after the call was abandoned.
+func (c *Client) claim(ior io.Reader) (*call, error) {
+	id, err := readRequestID(ior)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	cl := c.pending[id]
+	delete(c.pending, id)
+	c.mu.Unlock()
+	return cl, nil
+}
+
+func (c *Client) handleResponse(ior io.Reader) error {
+	cl, err := c.claim(ior)
+	if err != nil || cl == nil {
+		return err
+	}
+	if cl.resp != nil {
+		err = cl.resp.UnmarshalBinaryFrom(ior)
+	}
+	cl.done <- err
+	return nil
+}
+
+func (c *Client) handleErrorResponse(ior io.Reader) error {
+	cl, err := c.claim(ior)
+	if err != nil || cl == nil {
+		return err
+	}
+	var m gobsp.ErrorMessage
+	if err = m.UnmarshalBinaryFrom(ior); err == nil {
+		err = m
+	}
+	cl.done <- err
+	return nil
+}
+
+// send writes a single message and flushes it to the connection.
+func (c *Client) send(messageType gobsp.MessageType, body []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if err := c.composer.Compose(messageType, body); err != nil {
+		return err
+	}
+	return c.composer.Flush()
+}
+
+// Call sends a request of the specified type to the server, and waits for the
+// server to respond, decoding the response into resp. When resp is nil, the
+// response payload is ignored. When the server's handler fails, Call returns
+// the gobsp.ErrorMessage the server sent.
+//
+// When the context is done before the response arrives, Call sends a
+// cancellation message to the server and returns the context's error. Use
+// context.WithTimeout to limit how long Call waits.
+func (c *Client) Call(ctx context.Context, reqType gobsp.MessageType, req gobsp.Binary, resp gobsp.Binary) error {
+	cl := &call{resp: resp, done: make(chan error, 1)}
+
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return c.err
+	}
+	c.nextID++
+	id := c.nextID
+	c.pending[id] = cl
+	c.mu.Unlock()
+
+	bb := new(bytes.Buffer)
+	err := gobsp.UVWI(id).MarshalBinaryTo(bb)
+	if err == nil && req != nil {
+		err = req.MarshalBinaryTo(bb)
+	}
+	if err == nil {
+		err = c.send(reqType, bb.Bytes())
+	}
+	if err != nil {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return err
+	}
+
+	select {
+	case err = <-cl.done:
+		return err
+	case <-ctx.Done():
+	}
+
+	c.mu.Lock()
+	if _, ok := c.pending[id]; !ok {
+		// The response is already being delivered, so wait for it.
+		c.mu.Unlock()
+		return <-cl.done
+	}
+	delete(c.pending, id)
+	c.mu.Unlock()
+
+	bb.Reset()
+	_ = gobsp.UVWI(id).MarshalBinaryTo(bb)
+	_ = c.send(MTCancel, bb.Bytes()) // best effort; the server may have finished
+	return ctx.Err()
+}
+
+// Done returns a channel that is closed once the connection has ended and
+// every pending call has failed.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
@@ -0,0 +1,43 @@
+// Package rpc provides a request and response layer on top of the gobsp
+// Scanner and Composer, allowing many concurrent calls to be in flight over a
+// single connection.
+//
+// Each request is sent as a message of an application defined request type,
+// whose payload is a UVWI request ID followed by the encoded request. The
+// server replies with a message of type MTResponse or MTErrorResponse, whose
+// payload begins with the same request ID. The payload of an MTErrorResponse
+// continues with a gobsp.ErrorMessage, whose code lets clients tell kinds of
+// errors apart. A client that abandons a call sends
+// a message of type MTCancel carrying the request ID, which cancels the
+// context passed to the server's handler.
+package rpc
+
+import (
+	"io"
+
+	"github.com/karrick/gobsp"
+)
+
+// Message types used by the rpc layer. They are allocated from the range of
+// message types gobsp reserves for its subpackages, and must not be used as
+// request types.
+const (
+	MTResponse = gobsp.MinReservedMessageType + 0x80 + iota
+	MTErrorResponse
+	MTCancel
+)
+
+// ErrClosed is an error that is returned by Client.Call when the connection
+// has been closed, or failed, before a response was received.
+type ErrClosed struct{}
+
+func (e ErrClosed) Error() string {
+	return "rpc connection closed"
+}
+
+// readRequestID reads the request ID that begins every rpc message payload.
+func readRequestID(ior io.Reader) (uint64, error) {
+	var id gobsp.UVWI
+	err := id.UnmarshalBinaryFrom(ior)
+	return uint64(id), err
+}
@@ -0,0 +1,149 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/karrick/gobsp"
+)
+
+const (
+	mtDouble gobsp.MessageType = iota + 1
+	mtFail
+	mtBlock
+	mtUnknown
+	mtRefuse
+)
+
+func testServer(t *testing.T, blocked chan<- error) *Client {
+	server := NewServer()
+	server.Handle(mtDouble, func(_ context.Context, req io.Reader) (gobsp.Binary, error) {
+		var v gobsp.VWI
+		if err := v.UnmarshalBinaryFrom(req); err != nil {
+			return nil, err
+		}
+		v *= 2
+		return &v, nil
+	})
+	server.Handle(mtFail, func(context.Context, io.Reader) (gobsp.Binary, error) {
+		return nil, errors.New("failed on purpose")
+	})
+	server.Handle(mtRefuse, func(context.Context, io.Reader) (gobsp.Binary, error) {
+		return nil, gobsp.ErrorMessage{Code: gobsp.ErrorCodeApplication + 1, Text: "refused"}
+	})
+	server.Handle(mtBlock, func(ctx context.Context, _ io.Reader) (gobsp.Binary, error) {
+		<-ctx.Done()
+		blocked <- ctx.Err()
+		return nil, ctx.Err()
+	})
+
+	clientConn, serverConn := net.Pipe()
+	served := make(chan error, 1)
+	go func() { served <- server.ServeConn(serverConn) }()
+
+	client, err := NewClient(clientConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		clientConn.Close()
+		if err := <-served; err != nil {
+			t.Error(err)
+		}
+	})
+	return client
+}
+
+func TestCall(t *testing.T) {
+	client := testServer(t, nil)
+
+	req, resp := gobsp.VWI(21), gobsp.VWI(0)
+	if err := client.Call(context.Background(), mtDouble, &req, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if actual, expected := resp, gobsp.VWI(42); actual != expected {
+		t.Errorf("Actual: %#v; Expected: %#v", actual, expected)
+	}
+}
+
+func TestCallConcurrent(t *testing.T) {
+	client := testServer(t, nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req, resp := gobsp.VWI(i), gobsp.VWI(0)
+			if err := client.Call(context.Background(), mtDouble, &req, &resp); err != nil {
+				t.Error(err)
+				return
+			}
+			if actual, expected := resp, gobsp.VWI(2*i); actual != expected {
+				t.Errorf("Actual: %#v; Expected: %#v", actual, expected)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestCallErrorResponse(t *testing.T) {
+	client := testServer(t, nil)
+
+	err := client.Call(context.Background(), mtFail, nil, nil)
+	if actual, expected := err, error(gobsp.ErrorMessage{Code: gobsp.ErrorCodeUnknown, Text: "failed on purpose"}); actual != expected {
+		t.Errorf("Actual: %#v; Expected: %#v", actual, expected)
+	}
+
+	err = client.Call(context.Background(), mtUnknown, nil, nil)
+	if actual, expected := err, error(gobsp.ErrorMessage{Code: gobsp.ErrorCodeUnsupportedMessageType, Text: "unknown request type"}); actual != expected {
+		t.Errorf("Actual: %#v; Expected: %#v", actual, expected)
+	}
+
+	err = client.Call(context.Background(), mtRefuse, nil, nil)
+	if actual, expected := err, error(gobsp.ErrorMessage{Code: gobsp.ErrorCodeApplication + 1, Text: "refused"}); actual != expected {
+		t.Errorf("Actual: %#v; Expected: %#v", actual, expected)
+	}
+}
+
+func TestCallTimeoutCancelsHandler(t *testing.T) {
+	blocked := make(chan error, 1)
+	client := testServer(t, blocked)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	err := client.Call(ctx, mtBlock, nil, nil)
+	if actual, expected := err, context.DeadlineExceeded; actual != expected {
+		t.Errorf("Actual: %#v; Expected: %#v", actual, expected)
+	}
+	if actual, expected := <-blocked, context.Canceled; actual != expected {
+		t.Errorf("Actual: %#v; Expected: %#v", actual, expected)
+	}
+
+	// The connection remains usable after a canceled call.
+	req, resp := gobsp.VWI(1), gobsp.VWI(0)
+	if err := client.Call(context.Background(), mtDouble, &req, &resp); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCallAfterClose(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	client, err := NewClient(clientConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConn.Close()
+	<-client.Done()
+
+	req := gobsp.VWI(1)
+	if err := client.Call(context.Background(), mtDouble, &req, nil); err == nil {
+		t.Errorf("Actual: %#v; Expected: non-nil error", err)
+	}
+}
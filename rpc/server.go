@@ -0,0 +1,180 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+
+	"github.com/karrick/gobsp"
+)
+
+// HandlerFunc handles a single request, reading the encoded request from the
+// specified io.Reader, and returning the response to send to the client. A
+// nil response is sent as an empty payload. When the handler returns an error,
+// it is sent to the client in an error response: as is when it is a
+// gobsp.ErrorMessage, and otherwise as its text with code
+// gobsp.ErrorCodeUnknown.
+//
+// The context is canceled when the client abandons the call or the connection
+// ends.
+type HandlerFunc func(ctx context.Context, req io.Reader) (gobsp.Binary, error)
+
+// Server dispatches requests received over one or more connections to the
+// handlers registered for each request type.
+type Server struct {
+	mu       sync.RWMutex
+	handlers map[gobsp.MessageType]HandlerFunc
+}
+
+// NewServer returns a new Server without any handlers.
+func NewServer() *Server {
+	return &Server{handlers: make(map[gobsp.MessageType]HandlerFunc)}
+}
+
+// Handle registers the handler for the specified request type. Handlers
+// registered after ServeConn is called are not used for that connection.
+func (s *Server) Handle(reqType gobsp.MessageType, handler HandlerFunc) {
+	s.mu.Lock()
+	s.handlers[reqType] = handler
+	s.mu.Unlock()
+}
+
+// ServeConn serves requests received over the specified connection until it
+// ends, invoking each request's handler in its own goroutine. It returns after
+// every handler has returned, and returns nil when the connection ended
+// cleanly.
+func (s *Server) ServeConn(rw io.ReadWriter) error {
+	composer := gobsp.NewComposer(rw)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sc := &serverConn{
+		ctx:      ctx,
+		composer: composer,
+		inflight: make(map[uint64]context.CancelFunc),
+	}
+
+	handlers := map[uint32]gobsp.MessageHandler{
+		uint32(MTCancel): sc.handleCancel,
+	}
+	s.mu.RLock()
+	for reqType, handler := range s.handlers {
+		handlers[uint32(reqType)] = sc.dispatcher(handler)
+	}
+	s.mu.RUnlock()
+
+	scanner, err := gobsp.NewScanner(rw,
+		gobsp.DefaultHandler(sc.dispatcher(unknownRequestType)),
+		gobsp.Handlers(handlers))
+	if err != nil {
+		return err
+	}
+
+	for scanner.Scan() {
+		if err = scanner.Handle(); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = scanner.Err()
+	}
+	cancel()
+	sc.wg.Wait()
+	return err
+}
+
+// unknownRequestType handles requests of types without a registered handler.
+func unknownRequestType(context.Context, io.Reader) (gobsp.Binary, error) {
+	return nil, gobsp.ErrorMessage{Code: gobsp.ErrorCodeUnsupportedMessageType, Text: "unknown request type"}
+}
+
+// serverConn is the state of a single connection being served.
+type serverConn struct {
+	ctx      context.Context
+	wg       sync.WaitGroup
+	wmu      sync.Mutex // guards composer
+	composer *gobsp.Composer
+	mu       sync.Mutex // guards inflight
+	inflight map[uint64]context.CancelFunc
+}
+
+// dispatcher returns a gobsp.MessageHandler that reads a request and invokes
+// the specified handler with it in a new goroutine.
+func (sc *serverConn) dispatcher(handler HandlerFunc) gobsp.MessageHandler {
+	return func(ior io.Reader) error {
+		id, err := readRequestID(ior)
+		if err != nil {
+			return err
+		}
+		payload, err := io.ReadAll(ior)
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithCancel(sc.ctx)
+		sc.mu.Lock()
+		sc.inflight[id] = cancel
+		sc.mu.Unlock()
+
+		sc.wg.Add(1)
+		go func() {
+			defer sc.wg.Done()
+			resp, err := handler(ctx, bytes.NewReader(payload))
+			sc.respond(id, resp, err)
+			cancel()
+		}()
+		return nil
+	}
+}
+
+// respond sends the response or error response for the specified request.
+// Nothing is sent when the client has canceled the request.
+func (sc *serverConn) respond(id uint64, resp gobsp.Binary, err error) {
+	sc.mu.Lock()
+	_, ok := sc.inflight[id]
+	delete(sc.inflight, id)
+	sc.mu.Unlock()
+	if !ok {
+		return // canceled by client
+	}
+
+	bb := new(bytes.Buffer)
+	_ = gobsp.UVWI(id).MarshalBinaryTo(bb)
+	messageType := MTResponse
+	if err == nil && resp != nil {
+		if err = resp.MarshalBinaryTo(bb); err != nil {
+			bb.Reset()
+			_ = gobsp.UVWI(id).MarshalBinaryTo(bb)
+			err = gobsp.ErrorMessage{Code: gobsp.ErrorCodeInternal, Text: gobsp.String(err.Error())}
+		}
+	}
+	if err != nil {
+		messageType = MTErrorResponse
+		m, ok := err.(gobsp.ErrorMessage)
+		if !ok {
+			m = gobsp.ErrorMessage{Code: gobsp.ErrorCodeUnknown, Text: gobsp.String(err.Error())}
+		}
+		_ = m.MarshalBinaryTo(bb)
+	}
+	sc.wmu.Lock()
+	if sc.composer.Compose(messageType, bb.Bytes()) == nil {
+		_ = sc.composer.Flush()
+	}
+	sc.wmu.Unlock()
+}
+
+func (sc *serverConn) handleCancel(ior io.Reader) error {
+	id, err := readRequestID(ior)
+	if err != nil {
+		return err
+	}
+	sc.mu.Lock()
+	cancel, ok := sc.inflight[id]
+	delete(sc.inflight, id)
+	sc.mu.Unlock()
+	if ok {
+		cancel()
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package gobsp
 
 import (
 	"bufio"
+	"bytes"
 	"io"
 	"io/ioutil"
 )
@@ -58,7 +59,9 @@ func Handlers(handlers map[uint32]MessageHandler) ScannerConfig {
 // DefaultHandler and Handlers functions.
 func NewScanner(ior io.Reader, configurators ...ScannerConfig) (*Scanner, error) {
 	s := &Scanner{
-		bufferedReader: bufio.NewReader(ior), // gives us io.ByteReader
+		ior:                ior,
+		maxReassembledSize: DefaultMaxReassembledSize,
+		maxPartialMessages: DefaultMaxPartialMessages,
 	}
 	for _, c := range configurators {
 		if err := c(s); err != nil {
@@ -68,17 +71,65 @@ func NewScanner(ior io.Reader, configurators ...ScannerConfig) (*Scanner, error)
 	if s.defaultHandler == nil && s.handlers == nil {
 		return nil, ErrScannerHasNoHandlers{}
 	}
+	s.counter = &countingReader{ior: s.ior}
+	s.bufferedReader = bufio.NewReader(s.counter) // gives us io.ByteReader
 	return s, nil
 }
 
+// Raw causes a Scanner to return every frame exactly as it appears in the
+// stream: heartbeats are returned rather than consumed, batches are returned
+// without being unpacked, fragments are returned without being reassembled,
+// and Handle dispatches control messages to the handlers rather than
+// processing them itself. It suits tools that inspect or relay streams.
+func Raw() ScannerConfig {
+	return func(s *Scanner) error {
+		s.raw = true
+		return nil
+	}
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	ior io.Reader
+	n   int64
+}
+
+func (cr *countingReader) Read(buf []byte) (int, error) {
+	n, err := cr.ior.Read(buf)
+	cr.n += int64(n)
+	return n, err
+}
+
 // Scanner defines an object used to scan binary messages from a stream of bytes
 // from a particular io.Reader.
 type Scanner struct {
+	ior                      io.Reader // may be wrapped by configuration functions
 	bufferedReader           *bufio.Reader
 	err                      error
 	messageType, messageSize UVWI
 	handlers                 map[uint32]MessageHandler
 	defaultHandler           MessageHandler
+	preambleCheck            func(Preamble) error
+	preamble                 Preamble
+	preambleRead             bool
+	preambleOptional         bool // read a preamble only when the stream begins with one
+	replyTo                  *Composer
+	closed                   *CloseMessage
+	batch                    io.Reader     // nil unless unpacking a batch
+	inflater                 io.ReadCloser // decompresses compressed batches
+	inflated                 *bufio.Reader // buffers inflater
+	maxReassembledSize       int64
+	maxPartialMessages       int
+	partials                 map[UVWI]*partialMessage // fragmented messages not yet complete
+	ready                    []*partialMessage        // messages to return before reading the stream
+	current                  *partialMessage          // nil unless the current message is partial
+	chunk                    *io.LimitedReader        // rest of the current message's latest fragment
+	buffered                 int64                    // bytes buffered in ready messages
+	raw                      bool
+	passControl              bool // dispatch control messages to the default handler
+	counter                  *countingReader
+	offset                   int64 // stream offset of the current message's frame
+	headerOffset             int64 // stream offset of the frame header last read
 }
 
 // Err returns the error object associated with this scanner, or nil
@@ -92,45 +143,191 @@ func (s *Scanner) Reset() {
 	s.err = nil
 }
 
+// MessageType returns the type of the message most recently returned by Scan.
+func (s *Scanner) MessageType() MessageType {
+	return MessageType(s.messageType)
+}
+
+// MessageSize returns the size of the body of the message most recently
+// returned by Scan. For a message being reassembled from fragments, it is the
+// number of bytes received when Scan returned, rather than the size of the
+// whole message.
+func (s *Scanner) MessageSize() uint64 {
+	return uint64(s.messageSize)
+}
+
+// Offset returns the offset from the start of the stream of the frame that
+// carried the message most recently returned by Scan: the batch frame for a
+// message unpacked from a batch, and the first fragment for a message
+// reassembled from fragments. When Scan returns false because of an error,
+// Offset returns the offset of the frame Scan was reading.
+func (s *Scanner) Offset() int64 {
+	return s.offset
+}
+
+// position returns the offset from the start of the stream of the next byte to
+// be read from the buffered reader.
+func (s *Scanner) position() int64 {
+	return s.counter.n - int64(s.bufferedReader.Buffered())
+}
+
+// Preamble returns the preamble read from the stream. It returns the zero value
+// when the scanner was not configured to read a preamble, or has not yet read
+// one.
+func (s *Scanner) Preamble() Preamble {
+	return s.preamble
+}
+
+// readPreamble reads and checks the stream preamble, when the scanner was
+// configured to expect one and has not yet read it.
+func (s *Scanner) readPreamble() error {
+	if s.preambleCheck == nil || s.preambleRead {
+		return nil
+	}
+	if s.preambleOptional {
+		if magic, _ := s.bufferedReader.Peek(len(preambleMagic)); string(magic) != string(preambleMagic[:]) {
+			s.preambleRead = true // stream has no preamble
+			return nil
+		}
+	}
+	if err := s.preamble.UnmarshalBinaryFrom(s.bufferedReader); err != nil {
+		return err
+	}
+	s.preambleRead = true
+	return s.preambleCheck(s.preamble)
+}
+
 // Scan reads enough bytes from the stream to determine the message type and
-// size. Normally returns true, but returns false on error or EOF.
+// size. Normally returns true, but returns false on error or EOF. Heartbeat
+// messages are consumed without returning, batches are unpacked so that each
+// message in the batch is returned in turn, and fragmented messages are
+// returned once, when their first fragment arrives.
 //
 // By forcing message type and size to be together, an recognized message type
 // can be completely skipped over by the recipient, if it so chooses.
 func (s *Scanner) Scan() bool {
-	if s.err != nil {
+	if s.scan() {
+		return true
+	}
+	s.releaseInflater() // scanning has ended
+	return false
+}
+
+func (s *Scanner) scan() bool {
+	if s.current != nil {
+		s.finishPartial() // program did not handle the previous message
+	}
+	if s.err != nil || s.closed != nil {
 		return false
 	}
-	if s.err = s.messageType.UnmarshalBinaryFrom(s.bufferedReader); s.err != nil {
+	if s.err = s.readPreamble(); s.err != nil {
 		if s.err == io.EOF {
 			s.err = nil
 		}
 		return false
 	}
-	// fmt.Fprintf(os.Stderr, "scanner: message type: %#v\n", s.messageType)
-	if s.err = s.messageSize.UnmarshalBinaryFrom(s.bufferedReader); s.err != nil {
-		if s.err == io.EOF {
-			s.err = io.ErrUnexpectedEOF
+	if len(s.ready) > 0 {
+		s.current, s.ready = s.ready[0], s.ready[1:]
+		s.buffered -= int64(s.current.buffered.Len())
+		s.messageType, s.messageSize = s.current.messageType, UVWI(s.current.size)
+		s.offset = s.current.offset
+		return true
+	}
+	messageType, messageSize, err := s.readHeader()
+	s.offset = s.headerOffset
+	if err != nil {
+		if err != io.EOF {
+			s.err = err
 		}
 		return false
 	}
-	// fmt.Fprintf(os.Stderr, "scanner: message size: %#v\n", s.messageSize)
+	s.messageType, s.messageSize = messageType, messageSize
+	if MessageType(s.messageType) == MTFragment && !s.raw {
+		m, err := s.startFragment()
+		if err != nil {
+			s.err = err
+			return false
+		}
+		s.messageType, s.messageSize = m.messageType, UVWI(m.size)
+	}
 	return true
 }
 
+// readHeader reads the type and size of the next message from the stream,
+// consuming heartbeats and starting batches along the way. It returns io.EOF
+// only when the stream ends cleanly between messages.
+func (s *Scanner) readHeader() (UVWI, UVWI, error) {
+	var messageType, messageSize UVWI
+	for {
+		source := s.source()
+		if s.batch == nil {
+			s.headerOffset = s.position()
+		}
+		if err := messageType.UnmarshalBinaryFrom(source); err != nil {
+			if err == io.EOF && s.batch != nil {
+				s.batch = nil // batch exhausted; resume reading the stream
+				continue
+			}
+			return 0, 0, err
+		}
+		// fmt.Fprintf(os.Stderr, "scanner: message type: %#v\n", messageType)
+		if err := messageSize.UnmarshalBinaryFrom(source); err != nil {
+			return 0, 0, unexpectedEOF(err)
+		}
+		// fmt.Fprintf(os.Stderr, "scanner: message size: %#v\n", messageSize)
+		if r, ok := s.batch.(*bytes.Reader); ok && uint64(r.Len()) < uint64(messageSize) {
+			return 0, 0, io.ErrUnexpectedEOF // message extends beyond end of batch
+		}
+		if s.raw {
+			return messageType, messageSize, nil
+		}
+		switch MessageType(messageType) {
+		case MTHeartbeat:
+			// Heartbeats only prove the peer is alive, so are never dispatched.
+			if err := DiscardAll(io.LimitReader(source, int64(messageSize))); err != nil {
+				return 0, 0, err
+			}
+			continue
+		case MTBatch, MTCompressedBatch:
+			if s.batch == nil {
+				if err := s.startBatch(messageType, messageSize); err != nil {
+					return 0, 0, err
+				}
+				continue
+			}
+		}
+		return messageType, messageSize, nil
+	}
+}
+
+// source returns the io.Reader from which the current message is read: the
+// batch being unpacked, if any, otherwise the stream.
+func (s *Scanner) source() io.Reader {
+	if s.batch != nil {
+		return s.batch
+	}
+	return s.bufferedReader
+}
+
 // Handle invokes the message handler for the most recently received message
-// type. If the required message handler is not defined, it invokes the default
-// handler. If there is no default handler, it returns an error.
+// type. If the required message handler is not defined, it processes reserved
+// control messages itself, and otherwise invokes the default handler. If there
+// is no default handler, it returns an error.
 func (s *Scanner) Handle() error {
 	if s.err != nil {
 		return s.err
 	}
 	// fmt.Fprintf(os.Stderr, "handle: message type: %#v\n", s.messageType)
 	// fmt.Fprintf(os.Stderr, "handle: message size: %#v\n", s.messageSize)
-	limitReader := io.LimitReader(s.bufferedReader, int64(s.messageSize))
-	defer DiscardAll(limitReader)
+	limitReader, finish := s.body()
+	defer finish()
 	handler, ok := s.handlers[uint32(s.messageType)]
 	if !ok {
+		if !s.raw && !s.passControl {
+			if handled, err := s.handleControl(limitReader); handled {
+				return err
+			}
+		}
 		// fmt.Fprintf(os.Stderr, "map: %#v\n", s.handlers)
 		if s.defaultHandler == nil {
 			s.err = ErrUnknownMessageType(s.messageType)
@@ -142,32 +339,20 @@ func (s *Scanner) Handle() error {
 	return handler(limitReader)
 }
 
+// body returns a reader of the current message's body, as given to handlers,
+// reassembling a fragmented message, and a function that discards whatever of
+// the body remains unread.
+func (s *Scanner) body() (io.Reader, func()) {
+	if s.current != nil {
+		return fragmentReader{s}, s.finishPartial
+	}
+	limitReader := io.LimitReader(s.source(), int64(s.messageSize))
+	return limitReader, func() { _ = DiscardAll(limitReader) }
+}
+
 // DiscardAll discards the remaining bytes to be read from the specified
 // io.Reader, returning any errors received while reading.
 func DiscardAll(ior io.Reader) error {
 	_, err := io.Copy(ioutil.Discard, ior)
 	return err
 }
-
-type Composer struct {
-	bw *bufio.Writer
-}
-
-func NewComposer(iow io.Writer) *Composer {
-	return &Composer{bw: bufio.NewWriter(iow)}
-}
-
-func (w *Composer) Compose(messageType MessageType, messageBody []byte) error {
-	if err := UVWI(messageType).MarshalBinaryTo(w.bw); err != nil {
-		return err
-	}
-	if err := UVWI(len(messageBody)).MarshalBinaryTo(w.bw); err != nil {
-		return err
-	}
-	_, err := w.bw.Write(messageBody)
-	return err
-}
-
-func (w *Composer) Close() error {
-	return w.bw.Flush()
-}
@@ -175,3 +175,44 @@ func TestBinaryScannerHandleForgetsToConsumeAll(t *testing.T) {
 	ensure(t, scanner.Scan(), false)
 	ensure(t, scanner.Err(), nil)
 }
+
+func TestScannerRawAccessors(t *testing.T) {
+	bb := new(bytes.Buffer)
+	w := NewComposer(bb)
+	ensure(t, w.Compose(1, []byte("abc")), nil)
+	ensure(t, w.Compose(MTHeartbeat, nil), nil)
+	b := w.Batch()
+	ensure(t, b.Add(2, []byte("d")), nil)
+	ensure(t, b.Send(), nil)
+	ensure(t, w.Close(), nil)
+
+	scanner, err := NewScanner(bytes.NewReader(bb.Bytes()), DefaultHandler(DiscardAll), Raw())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ensure(t, scanner.Scan(), true)
+	ensure(t, scanner.Offset(), int64(0))
+	ensure(t, scanner.MessageType(), MessageType(1))
+	ensure(t, scanner.MessageSize(), uint64(3))
+	ensure(t, scanner.Handle(), nil)
+
+	ensure(t, scanner.Scan(), true) // heartbeat is returned in raw mode
+	ensure(t, scanner.Offset(), int64(5))
+	ensure(t, scanner.MessageType(), MTHeartbeat)
+	ensure(t, scanner.Handle(), nil)
+
+	ensure(t, scanner.Scan(), true) // batch is not unpacked in raw mode
+	ensure(t, scanner.Offset(), int64(11))
+	ensure(t, scanner.MessageType(), MTBatch)
+	ensure(t, scanner.MessageSize(), uint64(3))
+	ensure(t, scanner.Handle(), nil)
+
+	ensure(t, scanner.Scan(), false)
+	ensure(t, scanner.Err(), nil)
+}
+
+func TestMessageTypeString(t *testing.T) {
+	ensure(t, MessageType(42).String(), "42")
+	ensure(t, MTHeartbeat.String(), "Heartbeat")
+	ensure(t, MTFragment.String(), "Fragment")
+}
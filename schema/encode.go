@@ -0,0 +1,234 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/karrick/gobsp"
+)
+
+// ErrInvalidValue is an error that is returned when a value cannot be encoded
+// as the required field type.
+type ErrInvalidValue struct {
+	Type  string
+	Value interface{}
+}
+
+func (e ErrInvalidValue) Error() string {
+	return "cannot encode " + toString(e.Value) + " as " + e.Type
+}
+
+// toString formats a value for an error message.
+func toString(v interface{}) string {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return "?"
+	}
+	return string(buf)
+}
+
+// ErrMissingField is an error that is returned when a message's fields omit one
+// the schema describes.
+type ErrMissingField string
+
+func (e ErrMissingField) Error() string {
+	return "missing field: " + strconv.Quote(string(e))
+}
+
+// ErrUnknownField is an error that is returned when a message's fields include
+// one the schema does not describe.
+type ErrUnknownField string
+
+func (e ErrUnknownField) Error() string {
+	return "unknown field: " + strconv.Quote(string(e))
+}
+
+// ErrUnknownMessage is an error that is returned when the schema does not
+// describe a message type or name.
+type ErrUnknownMessage string
+
+func (e ErrUnknownMessage) Error() string {
+	return "unknown message: " + strconv.Quote(string(e))
+}
+
+// FieldType returns the canonical name of a field type, matching the name
+// case-insensitively, so "uvwi" names UVWI. It returns false when the name
+// does not name a field type.
+func FieldType(name string) (string, bool) {
+	for typ := range fieldTypes {
+		if strings.EqualFold(typ, name) {
+			return typ, true
+		}
+	}
+	return "", false
+}
+
+// TypeOf returns the message type the schema describes having the specified
+// name. New ensures that no two message types share a name.
+func (s *Schema) TypeOf(name string) (gobsp.MessageType, error) {
+	for mt, m := range s.Messages {
+		if m.Name == name {
+			return mt, nil
+		}
+	}
+	return 0, ErrUnknownMessage(name)
+}
+
+// Encode encodes the body of a message having the specified type, whose fields
+// are named as the schema describes. Values may be those decoded from JSON: a
+// number, as float64 or json.Number, for numeric fields; a string for String
+// fields, or hexadecimal for Bytes fields; and a slice of strings for
+// StringSlice fields.
+func (s *Schema) Encode(messageType gobsp.MessageType, fields map[string]interface{}) ([]byte, error) {
+	m, ok := s.Messages[messageType]
+	if !ok {
+		return nil, ErrUnknownMessage(gobsp.UVWI(messageType).String())
+	}
+	bb := new(bytes.Buffer)
+	for _, f := range m.Fields {
+		value, ok := fields[f.Name]
+		if !ok {
+			return nil, ErrMissingField(f.Name)
+		}
+		if err := EncodeValue(bb, f.Type, value); err != nil {
+			return nil, fieldError{field: f.Name, err: err}
+		}
+	}
+	if len(fields) > len(m.Fields) {
+		for name := range fields {
+			if !m.has(name) {
+				return nil, ErrUnknownField(name)
+			}
+		}
+	}
+	return bb.Bytes(), nil
+}
+
+// has returns true when the message has a field with the specified name.
+func (m Message) has(name string) bool {
+	for _, f := range m.Fields {
+		if f.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// bitSizes are the sizes, in bits, of the integer field types.
+var bitSizes = map[string]int{
+	"Int8": 8, "Uint8": 8, "Int16": 16, "Uint16": 16,
+	"Int32": 32, "Uint32": 32, "Int64": 64, "Uint64": 64,
+	"VWI": 64, "UVWI": 64,
+}
+
+// EncodeValue writes a value as the specified field type, accepting the same
+// values as Encode. Numbers may also be written as strings, in decimal or 0x
+// hexadecimal, so integers too large for a float64 are encoded exactly, and
+// the names NaN, +Inf, and -Inf, as Export writes them, are accepted for
+// floating point fields.
+func EncodeValue(iow io.Writer, typ string, value interface{}) error {
+	invalid := ErrInvalidValue{Type: typ, Value: value}
+	switch typ {
+	case "Int8", "Int16", "Int32", "Int64", "VWI":
+		i, err := strconv.ParseInt(number(value), 0, bitSizes[typ])
+		if err != nil {
+			return invalid
+		}
+		switch typ {
+		case "Int8":
+			return gobsp.Int8(i).MarshalBinaryTo(iow)
+		case "Int16":
+			return gobsp.Int16(i).MarshalBinaryTo(iow)
+		case "Int32":
+			return gobsp.Int32(i).MarshalBinaryTo(iow)
+		case "Int64":
+			return gobsp.Int64(i).MarshalBinaryTo(iow)
+		}
+		return gobsp.VWI(i).MarshalBinaryTo(iow)
+	case "Uint8", "Uint16", "Uint32", "Uint64", "UVWI":
+		u, err := strconv.ParseUint(number(value), 0, bitSizes[typ])
+		if err != nil {
+			return invalid
+		}
+		switch typ {
+		case "Uint8":
+			return gobsp.Uint8(u).MarshalBinaryTo(iow)
+		case "Uint16":
+			return gobsp.Uint16(u).MarshalBinaryTo(iow)
+		case "Uint32":
+			return gobsp.Uint32(u).MarshalBinaryTo(iow)
+		case "Uint64":
+			return gobsp.Uint64(u).MarshalBinaryTo(iow)
+		}
+		return gobsp.UVWI(u).MarshalBinaryTo(iow)
+	case "Float32", "Float64":
+		f, err := strconv.ParseFloat(number(value), 64)
+		if err != nil {
+			return invalid
+		}
+		if typ == "Float32" {
+			if math.Abs(f) > math.MaxFloat32 && !math.IsInf(f, 0) {
+				return invalid
+			}
+			return gobsp.Float32(f).MarshalBinaryTo(iow)
+		}
+		return gobsp.Float64(f).MarshalBinaryTo(iow)
+	case "String":
+		s, ok := value.(string)
+		if !ok {
+			return invalid
+		}
+		return gobsp.String(s).MarshalBinaryTo(iow)
+	case "StringSlice":
+		var ss gobsp.StringSlice
+		switch v := value.(type) {
+		case []string:
+			for _, s := range v {
+				ss = append(ss, gobsp.String(s))
+			}
+		case []interface{}:
+			for _, e := range v {
+				s, ok := e.(string)
+				if !ok {
+					return invalid
+				}
+				ss = append(ss, gobsp.String(s))
+			}
+		default:
+			return invalid
+		}
+		return ss.MarshalBinaryTo(iow)
+	case "Bytes":
+		s, ok := value.(string)
+		if !ok {
+			return invalid
+		}
+		buf, err := hex.DecodeString(s)
+		if err != nil {
+			return invalid
+		}
+		_, err = iow.Write(buf)
+		return err
+	}
+	return ErrUnknownFieldType{Type: typ}
+}
+
+// number returns the text of a numeric value.
+func number(value interface{}) string {
+	switch v := value.(type) {
+	case json.Number:
+		return string(v)
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case int:
+		return strconv.Itoa(v)
+	}
+	return ""
+}
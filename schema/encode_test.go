@@ -0,0 +1,118 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/karrick/gobsp"
+)
+
+// testFields decodes JSON fields the way gobsp-encode does.
+func testFields(t *testing.T, doc string) map[string]interface{} {
+	var fields map[string]interface{}
+	d := json.NewDecoder(strings.NewReader(doc))
+	d.UseNumber()
+	if err := d.Decode(&fields); err != nil {
+		t.Fatal(err)
+	}
+	return fields
+}
+
+func TestEncode(t *testing.T) {
+	s := testLoad(t)
+	body, err := s.Encode(1, testFields(t, `{"user": "bob", "attempts": 3, "score": 1.5, "tags": ["a", "b"]}`))
+	ensure(t, err, nil)
+	ensure(t, bytes.Equal(body, testLogin(t)), true)
+
+	body, err = s.Encode(2, testFields(t, `{"id": -2, "data": "dead"}`))
+	ensure(t, err, nil)
+	buf, err := json.Marshal(s.Decode(2, body))
+	ensure(t, err, nil)
+	ensure(t, string(buf), `{"offset":0,"type":2,"name":"Blob","fields":{"id":-2,"data":"dead"}}`)
+}
+
+func TestEncodeErrors(t *testing.T) {
+	s := testLoad(t)
+
+	_, err := s.Encode(9, nil)
+	ensure(t, err, ErrUnknownMessage("9"))
+
+	_, err = s.Encode(2, testFields(t, `{"id": 1}`))
+	ensure(t, err, ErrMissingField("data"))
+
+	_, err = s.Encode(2, testFields(t, `{"id": 1, "data": "", "extra": 2}`))
+	ensure(t, err, ErrUnknownField("extra"))
+
+	_, err = s.Encode(1, testFields(t, `{"user": "bob", "attempts": 256, "score": 0, "tags": []}`))
+	ensure(t, err.Error(), `field "attempts": cannot encode 256 as Uint8`)
+
+	_, err = s.Encode(2, testFields(t, `{"id": 1, "data": "xyz"}`))
+	ensure(t, err.Error(), `field "data": cannot encode "xyz" as Bytes`)
+}
+
+func TestEncodeValue(t *testing.T) {
+	cases := []struct {
+		typ      string
+		value    interface{}
+		expected gobsp.Binary
+	}{
+		{"Int8", json.Number("-128"), func() gobsp.Binary { v := gobsp.Int8(-128); return &v }()},
+		{"Uint16", "0xFFFF", func() gobsp.Binary { v := gobsp.Uint16(0xFFFF); return &v }()},
+		{"Uint64", "18446744073709551615", func() gobsp.Binary { v := gobsp.Uint64(1<<64 - 1); return &v }()},
+		{"UVWI", float64(300), func() gobsp.Binary { v := gobsp.UVWI(300); return &v }()},
+		{"Float32", json.Number("0.5"), func() gobsp.Binary { v := gobsp.Float32(0.5); return &v }()},
+		{"String", "hi", func() gobsp.Binary { v := gobsp.String("hi"); return &v }()},
+		{"StringSlice", []interface{}{"a"}, func() gobsp.Binary { v := gobsp.StringSlice{"a"}; return &v }()},
+	}
+	for _, c := range cases {
+		bb := new(bytes.Buffer)
+		if err := EncodeValue(bb, c.typ, c.value); err != nil {
+			t.Errorf("%s %v: %s", c.typ, c.value, err)
+			continue
+		}
+		ensure(t, bytes.Equal(bb.Bytes(), testBody(t, c.expected)), true)
+	}
+
+	bb := new(bytes.Buffer)
+	ensure(t, EncodeValue(bb, "Float64", "NaN"), nil)
+	buf, err := json.Marshal(Record{Fields: []Value{{"f", mustDecode(t, "Float64", bb.Bytes())}}})
+	ensure(t, err, nil)
+	ensure(t, string(buf), `{"offset":0,"type":0,"fields":{"f":"NaN"}}`)
+
+	ensure(t, EncodeValue(bb, "Int8", "1.5"), ErrInvalidValue{Type: "Int8", Value: "1.5"})
+	ensure(t, EncodeValue(bb, "Float32", "1e39"), ErrInvalidValue{Type: "Float32", Value: "1e39"})
+	ensure(t, EncodeValue(bb, "Complex", 1), ErrUnknownFieldType{Type: "Complex"})
+}
+
+func mustDecode(t *testing.T, typ string, buf []byte) interface{} {
+	v, err := decodeField(typ, &byteReader{buf: buf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestFieldType(t *testing.T) {
+	typ, ok := FieldType("uvwi")
+	ensure(t, typ, "UVWI")
+	ensure(t, ok, true)
+
+	_, ok = FieldType("uint128")
+	ensure(t, ok, false)
+}
+
+func TestTypeOf(t *testing.T) {
+	s := testLoad(t)
+	mt, err := s.TypeOf("Blob")
+	ensure(t, err, nil)
+	ensure(t, mt, gobsp.MessageType(2))
+
+	mt, err = s.TypeOf("Ping")
+	ensure(t, err, nil)
+	ensure(t, mt, gobsp.MTPing)
+
+	_, err = s.TypeOf("Nope")
+	ensure(t, err, ErrUnknownMessage("Nope"))
+}
@@ -0,0 +1,96 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+
+	"github.com/karrick/gobsp"
+)
+
+// Format selects how Export writes records.
+type Format int
+
+const (
+	// JSONLines writes each record as a JSON object on its own line, which
+	// suits streaming tools such as jq.
+	JSONLines Format = iota
+
+	// JSON writes every record as an element of a single, indented JSON
+	// array.
+	JSON
+)
+
+// Export scans every message from the gobsp stream read from ior, decodes each
+// one using the schema, and writes the records to iow in the specified format.
+// The configuration functions are applied to the Scanner, so a stream that
+// begins with a preamble may be exported by passing gobsp.CheckPreamble.
+//
+// Control messages the schema describes are exported like any other message,
+// rather than being processed by the Scanner, so a Close message does not end
+// the export. Messages whose bodies cannot be decoded are exported undecoded,
+// with the reason, rather than ending the export.
+func Export(iow io.Writer, ior io.Reader, s *Schema, format Format, configurators ...gobsp.ScannerConfig) error {
+	var scanner *gobsp.Scanner
+	var count int
+	var body bytes.Buffer
+	handler := func(r io.Reader) error {
+		body.Reset()
+		if _, err := body.ReadFrom(r); err != nil {
+			return err
+		}
+		record := s.Decode(scanner.MessageType(), append([]byte(nil), body.Bytes()...))
+		record.Offset = scanner.Offset()
+
+		var buf []byte
+		var err error
+		switch format {
+		case JSON:
+			prefix := ",\n  "
+			if count == 0 {
+				prefix = "[\n  "
+			}
+			if buf, err = json.MarshalIndent(record, "  ", "  "); err != nil {
+				return err
+			}
+			buf = append([]byte(prefix), buf...)
+		default:
+			if buf, err = json.Marshal(record); err != nil {
+				return err
+			}
+			buf = append(buf, '\n')
+		}
+		count++
+		_, err = iow.Write(buf)
+		return err
+	}
+
+	handlers := make(map[uint32]gobsp.MessageHandler)
+	for mt := range s.Messages {
+		if mt >= gobsp.MinReservedMessageType {
+			handlers[uint32(mt)] = handler
+		}
+	}
+	configurators = append(configurators, gobsp.Handlers(handlers), gobsp.DefaultHandler(handler))
+	scanner, err := gobsp.NewScanner(ior, configurators...)
+	if err != nil {
+		return err
+	}
+	for scanner.Scan() {
+		if err := scanner.Handle(); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if format == JSON {
+		end := "\n]\n"
+		if count == 0 {
+			end = "[]\n"
+		}
+		_, err = io.WriteString(iow, end)
+	}
+	return err
+}
@@ -0,0 +1,77 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/karrick/gobsp"
+)
+
+// fuzzSchema describes a message of every field type.
+const fuzzSchema = `{"messages": {
+	"1": {"name": "Numbers", "fields": [
+		{"name": "i8", "type": "Int8"}, {"name": "u8", "type": "Uint8"},
+		{"name": "i16", "type": "Int16"}, {"name": "u16", "type": "Uint16"},
+		{"name": "i32", "type": "Int32"}, {"name": "u32", "type": "Uint32"},
+		{"name": "i64", "type": "Int64"}, {"name": "u64", "type": "Uint64"},
+		{"name": "vwi", "type": "VWI"}, {"name": "uvwi", "type": "UVWI"},
+		{"name": "f32", "type": "Float32"}, {"name": "f64", "type": "Float64"}
+	]},
+	"2": {"name": "Text", "fields": [
+		{"name": "s", "type": "String"},
+		{"name": "ss", "type": "StringSlice"},
+		{"name": "rest", "type": "Bytes"}
+	]}
+}}`
+
+// FuzzDecode checks that a decoded body survives export as JSON, as gobsp-json
+// writes it, and encoding from that JSON, as gobsp-encode reads it.
+func FuzzDecode(f *testing.F) {
+	s, err := Load(strings.NewReader(fuzzSchema))
+	if err != nil {
+		f.Fatal(err)
+	}
+	types := append([]gobsp.MessageType{1, 2}, gobsp.MTHello, gobsp.MTError, gobsp.MTPing)
+	f.Add(uint8(0), make([]byte, 1+1+2+2+4+4+8+8+1+1+4+8))
+	f.Add(uint8(0), []byte("\x80\xff\x80\x00\xff\xff\x80\x00\x00\x00\xff\xff\xff\xff\x80\x00\x00\x00\x00\x00\x00\x00"+
+		"\xff\xff\xff\xff\xff\xff\xff\xff\x7f\x80\x01\x7f\x80\x00\x00\xff\xf0\x00\x00\x00\x00\x00\x00"))
+	f.Add(uint8(1), []byte("\x02hi\x02\x01a\x00rest"))
+	f.Add(uint8(1), []byte("\x02\xc3\x28\x00"))
+	f.Add(uint8(2), []byte("\x04gobs\x031.0"))
+	f.Add(uint8(3), []byte("\x01\x02no"))
+	f.Add(uint8(4), []byte("\x00\x00\x00\x00\x00\x00\x00\x07"))
+
+	f.Fuzz(func(t *testing.T, kind uint8, body []byte) {
+		mt := types[int(kind)%len(types)]
+		r := s.Decode(mt, body)
+		if r.Err != nil {
+			return
+		}
+		exported, err := json.Marshal(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var decoded struct {
+			Fields map[string]interface{} `json:"fields"`
+		}
+		d := json.NewDecoder(bytes.NewReader(exported))
+		d.UseNumber()
+		if err = d.Decode(&decoded); err != nil {
+			t.Fatal(err)
+		}
+		encoded, err := s.Encode(mt, decoded.Fields)
+		if err != nil {
+			t.Fatalf("cannot encode %s: %s", exported, err)
+		}
+		again, err := json.Marshal(s.Decode(mt, encoded))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(again) != string(exported) {
+			t.Fatalf("Actual: %s; Expected: %s", again, exported)
+		}
+	})
+}
@@ -0,0 +1,396 @@
+// Package schema decodes gobsp messages into named, typed fields, as described
+// by a schema, and exports them as JSON. It also encodes message bodies from
+// such fields.
+//
+// A schema is written in JSON. It maps each message type, in decimal or 0x
+// hexadecimal, to the message's name and the primitives that make up its body,
+// in order:
+//
+//	{
+//		"messages": {
+//			"1": {
+//				"name": "Login",
+//				"fields": [
+//					{"name": "user", "type": "String"},
+//					{"name": "attempts", "type": "Uint8"}
+//				]
+//			}
+//		}
+//	}
+//
+// Field types are the names of the gobsp primitives: Int8, Uint8, Int16,
+// Uint16, Int32, Uint32, Int64, Uint64, VWI, UVWI, Float32, Float64, String,
+// and StringSlice. The additional type Bytes consumes the remainder of the
+// body, and is exported as hexadecimal. Every schema also describes the
+// reserved control messages, unless it defines those message types itself, and
+// no two message types it describes may share a name.
+package schema
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/karrick/gobsp"
+)
+
+// Field describes one field of a message body.
+type Field struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// Message describes the body of one message type.
+type Message struct {
+	Name   string  `json:"name"`
+	Fields []Field `json:"fields"`
+}
+
+// Schema describes the bodies of message types.
+type Schema struct {
+	Messages map[gobsp.MessageType]Message
+}
+
+// ErrUnknownFieldType is an error that is returned when a schema names a field
+// type that is not a gobsp primitive.
+type ErrUnknownFieldType struct {
+	Message string
+	Field   string
+	Type    string
+}
+
+func (e ErrUnknownFieldType) Error() string {
+	return "message " + strconv.Quote(e.Message) + " field " + strconv.Quote(e.Field) + ": unknown type: " + strconv.Quote(e.Type)
+}
+
+// ErrInvalidMessageType is an error that is returned when a schema key is not
+// a message type.
+type ErrInvalidMessageType string
+
+func (e ErrInvalidMessageType) Error() string {
+	return "invalid message type: " + strconv.Quote(string(e))
+}
+
+// ErrDuplicateMessageName is an error that is returned when a schema gives the
+// same name to more than one message type, including a reserved control message
+// type the schema does not define itself.
+type ErrDuplicateMessageName string
+
+func (e ErrDuplicateMessageName) Error() string {
+	return "duplicate message name: " + strconv.Quote(string(e))
+}
+
+// ErrTrailingBytes is an error that is returned when a message body holds more
+// bytes than its fields.
+type ErrTrailingBytes int
+
+func (e ErrTrailingBytes) Error() string {
+	return strconv.Itoa(int(e)) + " bytes after last field"
+}
+
+// controlMessages describes the reserved control messages whose bodies are
+// made of primitives.
+var controlMessages = map[gobsp.MessageType]Message{
+	gobsp.MTHello: {Name: "Hello", Fields: []Field{{"name", "String"}, {"version", "String"}}},
+	gobsp.MTError: {Name: "Error", Fields: []Field{{"code", "UVWI"}, {"text", "String"}}},
+	gobsp.MTClose: {Name: "Close", Fields: []Field{{"reason", "String"}}},
+	gobsp.MTPing:  {Name: "Ping", Fields: []Field{{"token", "Uint64"}}},
+	gobsp.MTPong:  {Name: "Pong", Fields: []Field{{"token", "Uint64"}}},
+}
+
+// New returns a Schema describing the specified messages, and the reserved
+// control messages. Each message type must have a different name, so that a
+// name identifies a single message type.
+func New(messages map[gobsp.MessageType]Message) (*Schema, error) {
+	s := &Schema{Messages: make(map[gobsp.MessageType]Message, len(messages)+len(controlMessages))}
+	for mt, m := range controlMessages {
+		s.Messages[mt] = m
+	}
+	for mt, m := range messages {
+		for _, f := range m.Fields {
+			if !fieldTypes[f.Type] {
+				return nil, ErrUnknownFieldType{Message: m.Name, Field: f.Name, Type: f.Type}
+			}
+		}
+		s.Messages[mt] = m
+	}
+
+	mts := make([]gobsp.MessageType, 0, len(s.Messages))
+	for mt := range s.Messages {
+		mts = append(mts, mt)
+	}
+	sort.Slice(mts, func(i, j int) bool { return mts[i] < mts[j] }) // report the same name each time
+	names := make(map[string]bool, len(mts))
+	for _, mt := range mts {
+		name := s.Messages[mt].Name
+		if name == "" {
+			continue
+		}
+		if names[name] {
+			return nil, ErrDuplicateMessageName(name)
+		}
+		names[name] = true
+	}
+	return s, nil
+}
+
+// Load reads a schema written in JSON from the specified io.Reader.
+func Load(ior io.Reader) (*Schema, error) {
+	var doc struct {
+		Messages map[string]Message `json:"messages"`
+	}
+	if err := json.NewDecoder(ior).Decode(&doc); err != nil {
+		return nil, err
+	}
+	messages := make(map[gobsp.MessageType]Message, len(doc.Messages))
+	for key, m := range doc.Messages {
+		mt, err := strconv.ParseUint(key, 0, 64)
+		if err != nil {
+			return nil, ErrInvalidMessageType(key)
+		}
+		messages[gobsp.MessageType(mt)] = m
+	}
+	return New(messages)
+}
+
+// Record is a decoded message.
+type Record struct {
+	Offset int64             // stream offset of the frame carrying the message
+	Type   gobsp.MessageType // message type
+	Name   string            // message name; empty when the schema does not describe the type
+	Fields []Value           // decoded fields, in schema order
+	Body   []byte            // undecoded body, when the type is not described or cannot be decoded
+	Err    error             // why the body could not be decoded
+}
+
+// Value is a decoded field.
+type Value struct {
+	Name  string
+	Value interface{}
+}
+
+// MarshalJSON encodes the record as a JSON object, with its fields in schema
+// order.
+func (r Record) MarshalJSON() ([]byte, error) {
+	buf := []byte(`{"offset":`)
+	buf = strconv.AppendInt(buf, r.Offset, 10)
+	buf = append(buf, `,"type":`...)
+	buf = strconv.AppendUint(buf, uint64(r.Type), 10)
+	if r.Name != "" {
+		buf = append(buf, `,"name":`...)
+		buf = appendString(buf, r.Name)
+	}
+	if r.Fields != nil {
+		buf = append(buf, `,"fields":{`...)
+		for i, v := range r.Fields {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			value, err := json.Marshal(v.Value)
+			if err != nil {
+				return nil, err
+			}
+			buf = appendString(buf, v.Name)
+			buf = append(buf, ':')
+			buf = append(buf, value...)
+		}
+		buf = append(buf, '}')
+	}
+	if r.Err != nil {
+		buf = append(buf, `,"error":`...)
+		buf = appendString(buf, r.Err.Error())
+	}
+	if r.Body != nil {
+		buf = append(buf, `,"body":"`...)
+		buf = append(buf, hex.EncodeToString(r.Body)...)
+		buf = append(buf, '"')
+	}
+	return append(buf, '}'), nil
+}
+
+// appendString appends s to buf as a JSON string.
+func appendString(buf []byte, s string) []byte {
+	quoted, _ := json.Marshal(s) // cannot fail for a string
+	return append(buf, quoted...)
+}
+
+// Decode decodes a message having the specified type and body. A body the
+// schema does not describe, or that does not match its description, is
+// returned undecoded, and the reason recorded in the Record's Err field.
+func (s *Schema) Decode(messageType gobsp.MessageType, body []byte) Record {
+	r := Record{Type: messageType}
+	if body == nil {
+		body = []byte{} // exported as an empty body, rather than omitted
+	}
+	m, ok := s.Messages[messageType]
+	if !ok {
+		r.Body = body
+		return r
+	}
+	r.Name = m.Name
+	br := &byteReader{buf: body}
+	fields := make([]Value, 0, len(m.Fields))
+	for _, f := range m.Fields {
+		value, err := decodeField(f.Type, br)
+		if err != nil {
+			r.Body, r.Err = body, fieldError{field: f.Name, err: err}
+			return r
+		}
+		fields = append(fields, Value{Name: f.Name, Value: value})
+	}
+	if len(br.buf) > 0 {
+		r.Body, r.Err = body, ErrTrailingBytes(len(br.buf))
+		return r
+	}
+	r.Fields = fields
+	return r
+}
+
+// fieldError names the field that could not be decoded.
+type fieldError struct {
+	field string
+	err   error
+}
+
+func (e fieldError) Error() string {
+	return "field " + strconv.Quote(e.field) + ": " + e.err.Error()
+}
+
+// Types returns the message types the schema describes, in ascending order.
+func (s *Schema) Types() []gobsp.MessageType {
+	types := make([]gobsp.MessageType, 0, len(s.Messages))
+	for mt := range s.Messages {
+		types = append(types, mt)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+// byteReader is an io.Reader and io.ByteReader over a message body, which
+// reports a body ending part way through a field as io.ErrUnexpectedEOF.
+type byteReader struct {
+	buf []byte
+}
+
+func (br *byteReader) Read(p []byte) (int, error) {
+	if len(br.buf) == 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	n := copy(p, br.buf)
+	br.buf = br.buf[n:]
+	return n, nil
+}
+
+func (br *byteReader) ReadByte() (byte, error) {
+	if len(br.buf) == 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	b := br.buf[0]
+	br.buf = br.buf[1:]
+	return b, nil
+}
+
+// fieldTypes are the names of the types a field may have.
+var fieldTypes = map[string]bool{
+	"Int8": true, "Uint8": true, "Int16": true, "Uint16": true,
+	"Int32": true, "Uint32": true, "Int64": true, "Uint64": true,
+	"VWI": true, "UVWI": true, "Float32": true, "Float64": true,
+	"String": true, "StringSlice": true, "Bytes": true,
+}
+
+// decodeField decodes a field of the specified type into a value that encodes
+// as JSON.
+func decodeField(typ string, br *byteReader) (interface{}, error) {
+	switch typ {
+	case "Int8":
+		var v gobsp.Int8
+		err := v.UnmarshalBinaryFrom(br)
+		return int8(v), err
+	case "Uint8":
+		var v gobsp.Uint8
+		err := v.UnmarshalBinaryFrom(br)
+		return uint8(v), err
+	case "Int16":
+		var v gobsp.Int16
+		err := v.UnmarshalBinaryFrom(br)
+		return int16(v), err
+	case "Uint16":
+		var v gobsp.Uint16
+		err := v.UnmarshalBinaryFrom(br)
+		return uint16(v), err
+	case "Int32":
+		var v gobsp.Int32
+		err := v.UnmarshalBinaryFrom(br)
+		return int32(v), err
+	case "Uint32":
+		var v gobsp.Uint32
+		err := v.UnmarshalBinaryFrom(br)
+		return uint32(v), err
+	case "Int64":
+		var v gobsp.Int64
+		err := v.UnmarshalBinaryFrom(br)
+		return int64(v), err
+	case "Uint64":
+		var v gobsp.Uint64
+		err := v.UnmarshalBinaryFrom(br)
+		return uint64(v), err
+	case "VWI":
+		var v gobsp.VWI
+		err := v.UnmarshalBinaryFrom(br)
+		return int64(v), err
+	case "UVWI":
+		var v gobsp.UVWI
+		err := v.UnmarshalBinaryFrom(br)
+		return uint64(v), err
+	case "Float32":
+		var v gobsp.Float32
+		err := v.UnmarshalBinaryFrom(br)
+		return float(float64(v)), err
+	case "Float64":
+		var v gobsp.Float64
+		err := v.UnmarshalBinaryFrom(br)
+		return float(float64(v)), err
+	case "String":
+		var v gobsp.String
+		err := v.UnmarshalBinaryFrom(br)
+		return string(v), err
+	case "StringSlice":
+		var v gobsp.StringSlice
+		err := v.UnmarshalBinaryFrom(br)
+		values := make([]string, len(v))
+		for i, s := range v {
+			values[i] = string(s)
+		}
+		return values, err
+	case "Bytes":
+		buf, err := ioutil.ReadAll(&remainder{br})
+		return hex.EncodeToString(buf), err
+	}
+	return nil, ErrUnknownFieldType{Type: typ}
+}
+
+// remainder reads the rest of a body, reporting its end as io.EOF.
+type remainder struct {
+	br *byteReader
+}
+
+func (r *remainder) Read(p []byte) (int, error) {
+	if len(r.br.buf) == 0 {
+		return 0, io.EOF
+	}
+	return r.br.Read(p)
+}
+
+// float returns a value that encodes as JSON: the number itself, unless it is
+// not finite, which JSON numbers cannot represent, when it is the number's
+// name as a string.
+func float(f float64) interface{} {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	return f
+}
@@ -0,0 +1,159 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/karrick/gobsp"
+)
+
+func ensure(t *testing.T, actual, expected interface{}) {
+	t.Helper()
+	if actual != expected {
+		t.Errorf("Actual: %#v; Expected: %#v", actual, expected)
+	}
+}
+
+const testSchema = `{
+	"messages": {
+		"1": {"name": "Login", "fields": [
+			{"name": "user", "type": "String"},
+			{"name": "attempts", "type": "Uint8"},
+			{"name": "score", "type": "Float64"},
+			{"name": "tags", "type": "StringSlice"}
+		]},
+		"0x2": {"name": "Blob", "fields": [
+			{"name": "id", "type": "VWI"},
+			{"name": "data", "type": "Bytes"}
+		]}
+	}
+}`
+
+func testLoad(t *testing.T) *Schema {
+	s, err := Load(strings.NewReader(testSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// testBody returns the encoded form of the specified values.
+func testBody(t *testing.T, values ...gobsp.Binary) []byte {
+	bb := new(bytes.Buffer)
+	for _, v := range values {
+		if err := v.MarshalBinaryTo(bb); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return bb.Bytes()
+}
+
+func testLogin(t *testing.T) []byte {
+	user, attempts, score := gobsp.String("bob"), gobsp.Uint8(3), gobsp.Float64(1.5)
+	tags := gobsp.StringSlice{"a", "b"}
+	return testBody(t, &user, &attempts, &score, &tags)
+}
+
+func TestDecode(t *testing.T) {
+	s := testLoad(t)
+	buf, err := json.Marshal(s.Decode(1, testLogin(t)))
+	ensure(t, err, nil)
+	ensure(t, string(buf), `{"offset":0,"type":1,"name":"Login","fields":{"user":"bob","attempts":3,"score":1.5,"tags":["a","b"]}}`)
+
+	id := gobsp.VWI(-2)
+	buf, err = json.Marshal(s.Decode(2, append(testBody(t, &id), 0xDE, 0xAD)))
+	ensure(t, err, nil)
+	ensure(t, string(buf), `{"offset":0,"type":2,"name":"Blob","fields":{"id":-2,"data":"dead"}}`)
+}
+
+func TestDecodeUndescribed(t *testing.T) {
+	buf, err := json.Marshal(testLoad(t).Decode(9, []byte{0x01, 0x02}))
+	ensure(t, err, nil)
+	ensure(t, string(buf), `{"offset":0,"type":9,"body":"0102"}`)
+}
+
+func TestDecodeMismatch(t *testing.T) {
+	s := testLoad(t)
+	buf, err := json.Marshal(s.Decode(1, []byte{0x05, 'a'}))
+	ensure(t, err, nil)
+	ensure(t, string(buf), `{"offset":0,"type":1,"name":"Login","error":"field \"user\": unexpected EOF","body":"0561"}`)
+
+	buf, err = json.Marshal(s.Decode(1, append(testLogin(t), 0xFF)))
+	ensure(t, err, nil)
+	if !strings.Contains(string(buf), `"error":"1 bytes after last field"`) {
+		t.Errorf("Actual: %s; Expected: trailing bytes error", buf)
+	}
+}
+
+func TestRecordMarshalJSONEscapes(t *testing.T) {
+	r := Record{
+		Type:   1,
+		Name:   "del\x7f",
+		Fields: []Value{{Name: "f\x7f", Value: "v"}},
+		Err:    errors.New("bad \xff"),
+	}
+	buf, err := json.Marshal(r)
+	ensure(t, err, nil)
+	ensure(t, string(buf), "{\"offset\":0,\"type\":1,\"name\":\"del\x7f\",\"fields\":{\"f\x7f\":\"v\"},\"error\":\"bad \ufffd\"}")
+	var decoded map[string]interface{}
+	ensure(t, json.Unmarshal(buf, &decoded), nil)
+}
+
+func TestLoadErrors(t *testing.T) {
+	_, err := Load(strings.NewReader(`{"messages": {"x": {"name": "X"}}}`))
+	ensure(t, err, error(ErrInvalidMessageType("x")))
+
+	_, err = Load(strings.NewReader(`{"messages": {"1": {"name": "X", "fields": [{"name": "f", "type": "Int128"}]}}}`))
+	ensure(t, err, error(ErrUnknownFieldType{Message: "X", Field: "f", Type: "Int128"}))
+
+	_, err = Load(strings.NewReader(`{"messages": {"1": {"name": "X"}, "2": {"name": "Y"}, "3": {"name": "X"}}}`))
+	ensure(t, err, error(ErrDuplicateMessageName("X")))
+
+	_, err = Load(strings.NewReader(`{"messages": {"5": {"name": "Ping"}}}`))
+	ensure(t, err, error(ErrDuplicateMessageName("Ping")))
+
+	// A schema that defines a control message type itself may reuse its name.
+	s, err := New(map[gobsp.MessageType]Message{gobsp.MTPing: {Name: "Ping", Fields: []Field{{Name: "Token", Type: "Uint64"}}}})
+	ensure(t, err, error(nil))
+	mt, err := s.TypeOf("Ping")
+	ensure(t, err, error(nil))
+	ensure(t, mt, gobsp.MTPing)
+}
+
+func testStream(t *testing.T) []byte {
+	bb := new(bytes.Buffer)
+	w := gobsp.NewComposer(bb)
+	ensure(t, w.Compose(1, testLogin(t)), nil)
+	ensure(t, w.ComposeBinary(gobsp.MTClose, &gobsp.CloseMessage{Reason: "bye"}), nil)
+	ensure(t, w.Compose(9, nil), nil)
+	ensure(t, w.Close(), nil)
+	return bb.Bytes()
+}
+
+func TestExportJSONLines(t *testing.T) {
+	bb := new(bytes.Buffer)
+	ensure(t, Export(bb, bytes.NewReader(testStream(t)), testLoad(t), JSONLines), nil)
+	expected := `{"offset":0,"type":1,"name":"Login","fields":{"user":"bob","attempts":3,"score":1.5,"tags":["a","b"]}}
+{"offset":20,"type":4294967044,"name":"Close","fields":{"reason":"bye"}}
+{"offset":30,"type":9,"body":""}
+`
+	ensure(t, bb.String(), expected)
+}
+
+func TestExportJSON(t *testing.T) {
+	bb := new(bytes.Buffer)
+	ensure(t, Export(bb, bytes.NewReader(testStream(t)), testLoad(t), JSON), nil)
+	var records []map[string]interface{}
+	if err := json.Unmarshal(bb.Bytes(), &records); err != nil {
+		t.Fatalf("%s: %s", err, bb.String())
+	}
+	ensure(t, len(records), 3)
+	ensure(t, records[1]["name"], "Close")
+
+	bb.Reset()
+	ensure(t, Export(bb, bytes.NewReader(nil), testLoad(t), JSON), nil)
+	ensure(t, bb.String(), "[]\n")
+}
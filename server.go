@@ -0,0 +1,315 @@
+package gobsp
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// ConnHandler is any function that consumes the entirety of the specified
+// io.Reader stream, which holds a single message received on the specified
+// connection, and returns any error that occurred while reading that message.
+type ConnHandler func(*Conn, io.Reader) error
+
+// Conn is the per-connection context passed to a Server's handlers.
+type Conn struct {
+	ctx      context.Context
+	netConn  net.Conn
+	mu       sync.Mutex // guards composer
+	composer *Composer
+
+	stateMu  sync.Mutex // guards handling
+	handling bool       // a message is being handled
+}
+
+// Context returns a context that is canceled when the connection ends or the
+// server shuts down.
+func (c *Conn) Context() context.Context {
+	return c.ctx
+}
+
+// RemoteAddr returns the network address of the peer.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.netConn.RemoteAddr()
+}
+
+// Reply writes a message to the peer and flushes it. It is safe to call from
+// multiple goroutines.
+func (c *Conn) Reply(messageType MessageType, messageBody []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.composer.Compose(messageType, messageBody); err != nil {
+		return err
+	}
+	return c.composer.Flush()
+}
+
+// ErrServerClosed is an error that is returned by Server.Serve and
+// Server.ListenAndServe after Server.Shutdown is called.
+type ErrServerClosed struct{}
+
+func (e ErrServerClosed) Error() string {
+	return "gobsp: server closed"
+}
+
+// Server accepts connections on a listener, and for each connection scans
+// messages and dispatches them to the handler registered for each message type.
+type Server struct {
+	// Handlers are the handlers for the user-defined message types.
+	Handlers map[uint32]ConnHandler
+
+	// DefaultHandler, when not nil, is invoked for messages whose type has no
+	// handler. When nil, such a message ends its connection.
+	DefaultHandler ConnHandler
+
+	// MaxConns, when greater than 0, limits the number of connections served
+	// at once. Further connections wait to be accepted.
+	MaxConns int
+
+	// ScannerConfigs and ComposerConfigs are applied to the Scanner and
+	// Composer created for each connection. Each Scanner replies to control
+	// messages that require a reply, such as MTPing, with its connection's
+	// Composer.
+	ScannerConfigs  []ScannerConfig
+	ComposerConfigs []ComposerConfig
+
+	// ErrorLog, when not nil, is called with errors that end a connection.
+	ErrorLog func(net.Addr, error)
+
+	mu        sync.Mutex // guards fields below
+	listeners map[net.Listener]struct{}
+	conns     map[*Conn]struct{}
+	cancel    context.CancelFunc
+	ctx       context.Context
+	closing   bool
+	wg        sync.WaitGroup
+}
+
+// init prepares the server's state, when not already prepared. The caller must
+// hold s.mu.
+func (s *Server) init() {
+	if s.conns == nil {
+		s.listeners = make(map[net.Listener]struct{})
+		s.conns = make(map[*Conn]struct{})
+		s.ctx, s.cancel = context.WithCancel(context.Background())
+	}
+}
+
+// ListenAndServe listens on the specified network and address, which may be a
+// TCP or Unix socket, and serves connections accepted on it.
+func (s *Server) ListenAndServe(network, address string) error {
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on the specified listener and serves each in its
+// own goroutine. It always returns a non-nil error, which is ErrServerClosed
+// after Shutdown is called.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	s.init()
+	if s.closing {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed{}
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+
+	var slots chan struct{}
+	if s.MaxConns > 0 {
+		slots = make(chan struct{}, s.MaxConns)
+	}
+
+	for {
+		if slots != nil {
+			slots <- struct{}{}
+		}
+		nc, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closing := s.closing
+			delete(s.listeners, l)
+			s.mu.Unlock()
+			if closing {
+				return ErrServerClosed{}
+			}
+			return err
+		}
+		go func() {
+			s.serveConn(nc)
+			if slots != nil {
+				<-slots
+			}
+		}()
+	}
+}
+
+// serveConn scans and dispatches messages from a single connection until it
+// ends.
+func (s *Server) serveConn(nc net.Conn) {
+	defer nc.Close()
+
+	composer, err := NewComposerWithOptions(nc, s.ComposerConfigs...)
+	if err != nil {
+		s.logError(nc, err)
+		return
+	}
+	defer composer.Close() // stops any heartbeat or queue writer goroutine
+
+	s.mu.Lock()
+	if s.closing {
+		s.mu.Unlock()
+		return
+	}
+	ctx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
+	c := &Conn{ctx: ctx, netConn: nc, composer: composer}
+	s.conns[c] = struct{}{}
+	s.wg.Add(1)
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		s.wg.Done()
+	}()
+
+	// ReplyTo comes first, so that the server's ScannerConfigs may override it.
+	configs := append([]ScannerConfig{ReplyTo(composer), Handlers(s.messageHandlers(c))}, s.ScannerConfigs...)
+	if s.DefaultHandler != nil {
+		configs = append(configs, DefaultHandler(func(ior io.Reader) error {
+			return s.DefaultHandler(c, ior)
+		}))
+	}
+	scanner, err := NewScanner(connReader{c}, configs...)
+	if err != nil {
+		s.logError(nc, err)
+		return
+	}
+
+	for scanner.Scan() {
+		if !c.setHandling(true) {
+			return // shutting down
+		}
+		err = scanner.Handle()
+		if !c.setHandling(false) || err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = scanner.Err()
+	}
+	if err != nil && !s.isClosing() {
+		s.logError(nc, err)
+	}
+}
+
+// connReader reads a connection for its Scanner. Shutdown interrupts a
+// connection waiting for its next message by setting a read deadline, but a
+// Scanner configured with IdleTimeout extends the deadline before each read, so
+// connReader also refuses to read once the server is shutting down, unless a
+// message is being handled.
+type connReader struct {
+	c *Conn
+}
+
+func (r connReader) Read(buf []byte) (int, error) {
+	r.c.stateMu.Lock()
+	stopped := !r.c.handling && r.c.ctx.Err() != nil
+	r.c.stateMu.Unlock()
+	if stopped {
+		return 0, ErrServerClosed{}
+	}
+	return r.c.netConn.Read(buf)
+}
+
+func (r connReader) SetReadDeadline(t time.Time) error {
+	return r.c.netConn.SetReadDeadline(t)
+}
+
+// messageHandlers returns the server's handlers bound to the specified
+// connection.
+func (s *Server) messageHandlers(c *Conn) map[uint32]MessageHandler {
+	handlers := make(map[uint32]MessageHandler, len(s.Handlers))
+	for mt, handler := range s.Handlers {
+		handler := handler
+		handlers[mt] = func(ior io.Reader) error {
+			return handler(c, ior)
+		}
+	}
+	return handlers
+}
+
+// setHandling records whether the connection is handling a message, and
+// returns false when the connection ought to stop because the server is
+// shutting down.
+func (c *Conn) setHandling(handling bool) bool {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	c.handling = handling
+	return c.ctx.Err() == nil
+}
+
+func (s *Server) isClosing() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closing
+}
+
+func (s *Server) logError(nc net.Conn, err error) {
+	if s.ErrorLog != nil {
+		s.ErrorLog(nc.RemoteAddr(), err)
+	}
+}
+
+// Shutdown gracefully shuts down the server. It closes all listeners, lets
+// each connection finish handling the message in progress, then closes the
+// connection. It waits until every connection has closed, or the specified
+// context is done, in which case it closes the remaining connections and
+// returns the context's error.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.init()
+	s.closing = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	s.cancel()
+	conns := make([]*Conn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.mu.Unlock()
+
+	// Interrupt connections waiting for their next message. Connections
+	// handling a message notice the shutdown once the handler returns.
+	for _, c := range conns {
+		c.stateMu.Lock()
+		if !c.handling {
+			c.netConn.SetReadDeadline(time.Now())
+		}
+		c.stateMu.Unlock()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		for _, c := range conns {
+			c.netConn.Close()
+		}
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,218 @@
+package gobsp
+
+import (
+	"context"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testServe starts serving the server on a new listener for the specified
+// network, and returns the listener's address.
+func testServe(t *testing.T, s *Server, network, address string) net.Addr {
+	l, err := net.Listen(network, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(l) }()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if err := s.Shutdown(ctx); err != nil {
+			t.Error(err)
+		}
+		ensure(t, <-served, error(ErrServerClosed{}))
+	})
+	return l.Addr()
+}
+
+// testDial connects to the specified address, returning a Composer for sending
+// messages and a Scanner that stores the body of each received message.
+func testDial(t *testing.T, addr net.Addr, bodies chan<- string) (net.Conn, *Composer, *Scanner) {
+	nc, err := net.Dial(addr.Network(), addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { nc.Close() })
+	w := NewComposer(nc)
+	s, err := NewScanner(nc, DefaultHandler(func(ior io.Reader) error {
+		buf, err := io.ReadAll(ior)
+		bodies <- string(buf)
+		return err
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return nc, w, s
+}
+
+func echoServer() *Server {
+	return &Server{
+		Handlers: map[uint32]ConnHandler{
+			1: func(c *Conn, ior io.Reader) error {
+				buf, err := io.ReadAll(ior)
+				if err != nil {
+					return err
+				}
+				return c.Reply(2, buf)
+			},
+		},
+	}
+}
+
+func testEcho(t *testing.T, network, address string) {
+	addr := testServe(t, echoServer(), network, address)
+
+	bodies := make(chan string, 1)
+	_, w, s := testDial(t, addr, bodies)
+	ensure(t, w.Compose(1, []byte("hello")), nil)
+	ensure(t, w.Flush(), nil)
+
+	ensure(t, s.Scan(), true)
+	ensure(t, s.Handle(), nil)
+	ensure(t, <-bodies, "hello")
+}
+
+func TestServerTCP(t *testing.T) {
+	testEcho(t, "tcp", "127.0.0.1:0")
+}
+
+func TestServerUnix(t *testing.T) {
+	testEcho(t, "unix", filepath.Join(t.TempDir(), "gobsp.sock"))
+}
+
+func TestServerPing(t *testing.T) {
+	addr := testServe(t, echoServer(), "tcp", "127.0.0.1:0")
+
+	nc, w, _ := testDial(t, addr, nil)
+	var pong PongMessage
+	s, err := NewScanner(nc, Handlers(map[uint32]MessageHandler{
+		uint32(MTPong): pong.UnmarshalBinaryFrom,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ensure(t, nc.SetReadDeadline(time.Now().Add(5*time.Second)), nil)
+	ensure(t, w.ComposeBinary(MTPing, &PingMessage{Token: 42}), nil)
+	ensure(t, w.Flush(), nil)
+
+	ensure(t, s.Scan(), true)
+	ensure(t, s.MessageType(), MTPong)
+	ensure(t, s.Handle(), nil)
+	ensure(t, pong.Token, Uint64(42))
+}
+
+func TestServerConnContext(t *testing.T) {
+	remote := make(chan net.Addr, 1)
+	server := &Server{
+		DefaultHandler: func(c *Conn, ior io.Reader) error {
+			remote <- c.RemoteAddr()
+			return DiscardAll(ior)
+		},
+	}
+	addr := testServe(t, server, "tcp", "127.0.0.1:0")
+
+	nc, w, _ := testDial(t, addr, nil)
+	ensure(t, w.Compose(9, nil), nil)
+	ensure(t, w.Flush(), nil)
+	ensure(t, (<-remote).String(), nc.LocalAddr().String())
+}
+
+func TestServerShutdownDrainsInFlight(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	server := &Server{
+		Handlers: map[uint32]ConnHandler{
+			1: func(c *Conn, ior io.Reader) error {
+				close(started)
+				<-release
+				return c.Reply(2, []byte("done"))
+			},
+		},
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(l)
+
+	bodies := make(chan string, 1)
+	_, w, s := testDial(t, l.Addr(), bodies)
+	ensure(t, w.Compose(1, nil), nil)
+	ensure(t, w.Flush(), nil)
+	<-started
+
+	shutdown := make(chan error)
+	go func() { shutdown <- server.Shutdown(context.Background()) }()
+
+	select {
+	case err := <-shutdown:
+		t.Fatalf("shutdown returned before in-flight message was handled: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+
+	ensure(t, s.Scan(), true)
+	ensure(t, s.Handle(), nil)
+	ensure(t, <-bodies, "done")
+	ensure(t, <-shutdown, nil)
+}
+
+func TestServerMaxConns(t *testing.T) {
+	server := echoServer()
+	server.MaxConns = 1
+	addr := testServe(t, server, "tcp", "127.0.0.1:0")
+
+	firstBodies := make(chan string, 1)
+	first, _, _ := testDial(t, addr, firstBodies)
+
+	// The second connection is not served while the first remains open.
+	secondBodies := make(chan string, 1)
+	_, w, s := testDial(t, addr, secondBodies)
+	ensure(t, w.Compose(1, []byte("second")), nil)
+	ensure(t, w.Flush(), nil)
+
+	served := make(chan bool)
+	go func() {
+		ok := s.Scan() && s.Handle() == nil
+		served <- ok
+	}()
+	select {
+	case <-served:
+		t.Fatal("second connection served while first connection open")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	first.Close()
+	ensure(t, <-served, true)
+	ensure(t, <-secondBodies, "second")
+}
+
+func TestServerShutdownWithIdleTimeout(t *testing.T) {
+	server := echoServer()
+	server.ScannerConfigs = []ScannerConfig{IdleTimeout(time.Minute)}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(l) }()
+
+	// Heartbeats keep the Scanner reading without returning a message, and
+	// each read extends the deadline Shutdown sets to interrupt it.
+	_, w, _ := testDial(t, l.Addr(), nil)
+	ensure(t, w.Compose(MTHeartbeat, nil), nil)
+	ensure(t, w.Flush(), nil)
+	go func() {
+		for w.Compose(MTHeartbeat, nil) == nil && w.Flush() == nil {
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ensure(t, server.Shutdown(ctx), nil)
+	ensure(t, <-served, error(ErrServerClosed{}))
+}
@@ -0,0 +1,135 @@
+package stats
+
+import (
+	"math"
+
+	"github.com/karrick/gobsp"
+	"github.com/karrick/gobsp/schema"
+)
+
+// fieldKey identifies a field of a message type.
+type fieldKey struct {
+	messageType gobsp.MessageType
+	index       int
+}
+
+// FieldSummary summarizes the values of one integer field of a message type,
+// comparing the bytes its values took with the bytes they would take encoded
+// as variable or fixed width integers.
+type FieldSummary struct {
+	Type      gobsp.MessageType
+	Message   string // message name
+	Field     string // field name
+	FieldType string // field type, such as Uint32 or VWI
+
+	Count int64 // values decoded
+	Bytes int64 // bytes the values took
+
+	// VariableBytes is the bytes the values would take encoded as variable
+	// width integers: VWI for signed field types, and UVWI for unsigned.
+	VariableBytes int64
+
+	// FixedWidth is the width, in bytes, of the narrowest fixed width
+	// integer of the field's signedness that holds every value.
+	FixedWidth int
+
+	index int // position of the field in the message
+}
+
+// FixedBytes returns the bytes the values would take encoded as the narrowest
+// fixed width integer that holds every value.
+func (f FieldSummary) FixedBytes() int64 {
+	return f.Count * int64(f.FixedWidth)
+}
+
+// fixedWidths are the widths, in bytes, of the fixed width integer types.
+var fixedWidths = map[string]int{
+	"Int8": 1, "Uint8": 1, "Int16": 2, "Uint16": 2,
+	"Int32": 4, "Uint32": 4, "Int64": 8, "Uint64": 8,
+}
+
+// addFields records the integer fields of a message decoded using the schema.
+func (c *Collector) addFields(r schema.Record) {
+	messageType := r.Type
+	for i, v := range r.Fields {
+		var signed int64
+		var unsigned uint64
+		var isSigned bool
+		switch value := v.Value.(type) {
+		case int8:
+			signed, isSigned = int64(value), true
+		case int16:
+			signed, isSigned = int64(value), true
+		case int32:
+			signed, isSigned = int64(value), true
+		case int64:
+			signed, isSigned = value, true
+		case uint8:
+			unsigned = uint64(value)
+		case uint16:
+			unsigned = uint64(value)
+		case uint32:
+			unsigned = uint64(value)
+		case uint64:
+			unsigned = value
+		default:
+			continue // not an integer
+		}
+
+		key := fieldKey{messageType: messageType, index: i}
+		f, ok := c.fields[key]
+		if !ok {
+			typ := c.schema.Messages[messageType].Fields[i].Type
+			f = &FieldSummary{Type: messageType, Message: r.Name, Field: v.Name, FieldType: typ, index: i}
+			c.fields[key] = f
+		}
+		f.Count++
+
+		var variable, fixed int
+		if isSigned {
+			// zig-zag encoding, as VWI uses
+			variable = width(uint64((signed << 1) ^ (signed >> 63)))
+			fixed = signedWidth(signed)
+		} else {
+			variable = width(unsigned)
+			fixed = unsignedWidth(unsigned)
+		}
+		f.VariableBytes += int64(variable)
+		if fixed > f.FixedWidth {
+			f.FixedWidth = fixed
+		}
+		if w, ok := fixedWidths[f.FieldType]; ok {
+			f.Bytes += int64(w)
+		} else {
+			f.Bytes += int64(variable)
+		}
+	}
+}
+
+// signedWidth returns the width of the narrowest signed fixed width integer
+// that holds the specified value.
+func signedWidth(v int64) int {
+	switch {
+	case v >= math.MinInt8 && v <= math.MaxInt8:
+		return 1
+	case v >= math.MinInt16 && v <= math.MaxInt16:
+		return 2
+	case v >= math.MinInt32 && v <= math.MaxInt32:
+		return 4
+	}
+	return 8
+}
+
+// unsignedWidth returns the width of the narrowest unsigned fixed width integer
+// that holds the specified value.
+func unsignedWidth(v uint64) int {
+	switch {
+	case v <= math.MaxUint8:
+		return 1
+	case v <= math.MaxUint16:
+		return 2
+	case v <= math.MaxUint32:
+		return 4
+	}
+	return 8
+}
@@ -0,0 +1,307 @@
+// Package stats summarizes gobsp streams: how many messages of each type they
+// carry, how large those messages are, how much of the stream is spent on
+// framing, and, for timestamped streams such as archives, how traffic varies
+// over time.
+//
+// A Collector may be embedded in a program to summarize the messages it sends
+// or receives, or fed an entire stream with ReadFrom. When configured with a
+// schema, a Collector also decodes message bodies, and reports for each integer
+// field how many bytes it took, and how many it would take were it encoded as
+// a variable width integer, or as the narrowest fixed width integer that holds
+// every value seen, which informs the choice between the two that the
+// package's benchmarks discuss.
+package stats
+
+import (
+	"bytes"
+	"io"
+	"math/bits"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/karrick/gobsp"
+	"github.com/karrick/gobsp/schema"
+)
+
+// DefaultInterval is the width of the throughput intervals, unless configured
+// with Interval.
+const DefaultInterval = time.Second
+
+// Config is a function that modifies a newly created Collector instance.
+type Config func(*Collector) error
+
+// Interval sets the width of the intervals into which messages added with
+// AddAt are grouped to report throughput.
+func Interval(interval time.Duration) Config {
+	return func(c *Collector) error {
+		if interval <= 0 {
+			return ErrInvalidInterval(interval)
+		}
+		c.interval = interval
+		return nil
+	}
+}
+
+// Schema causes a Collector to decode message bodies using the specified
+// schema, and to report on their integer fields.
+func Schema(s *schema.Schema) Config {
+	return func(c *Collector) error {
+		c.schema = s
+		return nil
+	}
+}
+
+// ErrInvalidInterval is an error that is returned when a throughput interval is
+// not positive.
+type ErrInvalidInterval time.Duration
+
+func (e ErrInvalidInterval) Error() string {
+	return "invalid interval: " + time.Duration(e).String()
+}
+
+// Collector accumulates statistics about messages. It is safe for concurrent
+// use.
+type Collector struct {
+	interval time.Duration
+	schema   *schema.Schema
+
+	mu         sync.Mutex
+	frames     int64
+	headers    int64 // bytes of frame headers
+	bodies     int64 // bytes of frame bodies
+	types      map[gobsp.MessageType]*TypeSummary
+	typeWidths [MaxWidth + 1]int64
+	sizeWidths [MaxWidth + 1]int64
+	intervals  map[int64]*IntervalSummary // keyed by interval number
+	fields     map[fieldKey]*FieldSummary
+}
+
+// NewCollector returns a new Collector, modified by the specified configuration
+// functions.
+func NewCollector(configurators ...Config) (*Collector, error) {
+	c := &Collector{
+		interval:  DefaultInterval,
+		types:     make(map[gobsp.MessageType]*TypeSummary),
+		intervals: make(map[int64]*IntervalSummary),
+		fields:    make(map[fieldKey]*FieldSummary),
+	}
+	for _, configurator := range configurators {
+		if err := configurator(c); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// MaxWidth is the most bytes a variable width integer takes.
+const MaxWidth = 10
+
+// width returns the number of bytes the variable width encoding of the
+// specified value takes.
+func width(value uint64) int {
+	if value == 0 {
+		return 1
+	}
+	return (bits.Len64(value) + 6) / 7
+}
+
+// Add records a message having the specified type and body, as carried by a
+// single frame.
+func (c *Collector) Add(messageType gobsp.MessageType, messageBody []byte) {
+	c.add(time.Time{}, messageType, messageBody)
+}
+
+// AddAt records a message having the specified type and body, sent or received
+// at the specified time, for the throughput report.
+func (c *Collector) AddAt(when time.Time, messageType gobsp.MessageType, messageBody []byte) {
+	c.add(when, messageType, messageBody)
+}
+
+func (c *Collector) add(when time.Time, messageType gobsp.MessageType, messageBody []byte) {
+	size := uint64(len(messageBody))
+	typeWidth, sizeWidth := width(uint64(messageType)), width(size)
+	var r schema.Record
+	if c.schema != nil {
+		r = c.schema.Decode(messageType, messageBody)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.frames++
+	c.headers += int64(typeWidth + sizeWidth)
+	c.bodies += int64(size)
+	c.typeWidths[typeWidth]++
+	c.sizeWidths[sizeWidth]++
+
+	t, ok := c.types[messageType]
+	if !ok {
+		t = &TypeSummary{Type: messageType, Min: size}
+		c.types[messageType] = t
+	}
+	t.add(size)
+
+	if !when.IsZero() {
+		n := when.UnixNano() / int64(c.interval)
+		i, ok := c.intervals[n]
+		if !ok {
+			i = &IntervalSummary{Start: time.Unix(0, n*int64(c.interval))}
+			c.intervals[n] = i
+		}
+		i.Frames++
+		i.Bytes += int64(typeWidth+sizeWidth) + int64(size)
+	}
+
+	if r.Fields != nil {
+		c.addFields(r)
+	}
+}
+
+// ReadFrom records every frame of the gobsp stream read from ior, until it ends,
+// and returns the number of bytes read. Frames are recorded as they appear in
+// the stream, so batches and fragments are recorded as frames of those types,
+// rather than as the messages they carry. A preamble beginning the stream is
+// skipped.
+func (c *Collector) ReadFrom(ior io.Reader) (int64, error) {
+	configurators := []gobsp.ScannerConfig{gobsp.Raw(), gobsp.OptionalPreamble(func(gobsp.Preamble) error { return nil })}
+	cr := &countingReader{ior: ior}
+
+	var scanner *gobsp.Scanner
+	var body bytes.Buffer
+	configurators = append(configurators, gobsp.DefaultHandler(func(r io.Reader) error {
+		body.Reset()
+		if _, err := body.ReadFrom(r); err != nil {
+			return err
+		}
+		if uint64(body.Len()) != scanner.MessageSize() {
+			return io.ErrUnexpectedEOF
+		}
+		c.Add(scanner.MessageType(), body.Bytes())
+		return nil
+	}))
+	scanner, err := gobsp.NewScanner(cr, configurators...)
+	if err != nil {
+		return cr.n, err
+	}
+	for scanner.Scan() {
+		if err := scanner.Handle(); err != nil {
+			return cr.n, err
+		}
+	}
+	return cr.n, scanner.Err()
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	ior io.Reader
+	n   int64
+}
+
+func (cr *countingReader) Read(buf []byte) (int, error) {
+	n, err := cr.ior.Read(buf)
+	cr.n += int64(n)
+	return n, err
+}
+
+// TypeSummary summarizes the messages of one type.
+type TypeSummary struct {
+	Type  gobsp.MessageType
+	Count int64
+	Bytes int64  // total body bytes
+	Min   uint64 // smallest body
+	Max   uint64 // largest body
+
+	// Histogram counts bodies by size. Histogram[0] counts empty bodies,
+	// and Histogram[n] counts bodies of at least 2^(n-1), and fewer than
+	// 2^n, bytes.
+	Histogram []int64
+}
+
+func (t *TypeSummary) add(size uint64) {
+	t.Count++
+	t.Bytes += int64(size)
+	if size < t.Min {
+		t.Min = size
+	}
+	if size > t.Max {
+		t.Max = size
+	}
+	bucket := bits.Len64(size)
+	for len(t.Histogram) <= bucket {
+		t.Histogram = append(t.Histogram, 0)
+	}
+	t.Histogram[bucket]++
+}
+
+// IntervalSummary summarizes the frames added with AddAt during one interval.
+type IntervalSummary struct {
+	Start  time.Time
+	Frames int64
+	Bytes  int64 // frame bytes, headers included
+}
+
+// Summary is a snapshot of the statistics a Collector has accumulated.
+type Summary struct {
+	Frames      int64
+	HeaderBytes int64 // bytes of frame headers
+	BodyBytes   int64 // bytes of frame bodies
+
+	// Types summarizes each message type, in ascending order of type.
+	Types []TypeSummary
+
+	// TypeWidths and SizeWidths count frames by the width, in bytes, of the
+	// variable width integers in their headers that encode the message type
+	// and body size.
+	TypeWidths [MaxWidth + 1]int64
+	SizeWidths [MaxWidth + 1]int64
+
+	// Intervals summarizes the frames added with AddAt, in time order, for
+	// each interval having any.
+	Intervals []IntervalSummary
+
+	// Fields summarizes the integer fields described by the schema, in
+	// ascending order of message type, then in field order.
+	Fields []FieldSummary
+}
+
+// Overhead returns the percentage of the stream's bytes spent on frame headers.
+func (s Summary) Overhead() float64 {
+	total := s.HeaderBytes + s.BodyBytes
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(s.HeaderBytes) / float64(total)
+}
+
+// Summary returns a snapshot of the statistics accumulated so far.
+func (c *Collector) Summary() Summary {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := Summary{
+		Frames:      c.frames,
+		HeaderBytes: c.headers,
+		BodyBytes:   c.bodies,
+		TypeWidths:  c.typeWidths,
+		SizeWidths:  c.sizeWidths,
+	}
+	for _, t := range c.types {
+		t := *t
+		t.Histogram = append([]int64(nil), t.Histogram...)
+		s.Types = append(s.Types, t)
+	}
+	sort.Slice(s.Types, func(i, j int) bool { return s.Types[i].Type < s.Types[j].Type })
+	for _, i := range c.intervals {
+		s.Intervals = append(s.Intervals, *i)
+	}
+	sort.Slice(s.Intervals, func(i, j int) bool { return s.Intervals[i].Start.Before(s.Intervals[j].Start) })
+	for _, f := range c.fields {
+		s.Fields = append(s.Fields, *f)
+	}
+	sort.Slice(s.Fields, func(i, j int) bool {
+		if s.Fields[i].Type != s.Fields[j].Type {
+			return s.Fields[i].Type < s.Fields[j].Type
+		}
+		return s.Fields[i].index < s.Fields[j].index
+	})
+	return s
+}
@@ -0,0 +1,169 @@
+package stats
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/karrick/gobsp"
+	"github.com/karrick/gobsp/schema"
+)
+
+func ensure(t *testing.T, actual, expected interface{}) {
+	t.Helper()
+	if actual != expected {
+		t.Errorf("Actual: %#v; Expected: %#v", actual, expected)
+	}
+}
+
+func TestWidth(t *testing.T) {
+	for _, v := range []uint64{0, 1, 127, 128, 16383, 16384, 1<<63 - 1, 1<<64 - 1} {
+		bb := new(bytes.Buffer)
+		if err := gobsp.UVWI(v).MarshalBinaryTo(bb); err != nil {
+			t.Fatal(err)
+		}
+		ensure(t, width(v), bb.Len())
+	}
+}
+
+func TestCollector(t *testing.T) {
+	c, err := NewCollector()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Add(1, nil)
+	c.Add(1, []byte("abc"))
+	c.Add(200, bytes.Repeat([]byte("x"), 200))
+
+	s := c.Summary()
+	ensure(t, s.Frames, int64(3))
+	ensure(t, s.HeaderBytes, int64(2+2+4)) // type 200 and size 200 take two bytes each
+	ensure(t, s.BodyBytes, int64(203))
+	ensure(t, s.TypeWidths, [MaxWidth + 1]int64{0, 2, 1})
+	ensure(t, s.SizeWidths, [MaxWidth + 1]int64{0, 2, 1})
+	ensure(t, s.Overhead(), 100*8.0/211)
+	ensure(t, len(s.Intervals), 0)
+
+	ensure(t, len(s.Types), 2)
+	one := s.Types[0]
+	ensure(t, one.Type, gobsp.MessageType(1))
+	ensure(t, one.Count, int64(2))
+	ensure(t, one.Bytes, int64(3))
+	ensure(t, one.Min, uint64(0))
+	ensure(t, one.Max, uint64(3))
+	ensure(t, len(one.Histogram), 3)
+	ensure(t, one.Histogram[0], int64(1)) // empty
+	ensure(t, one.Histogram[2], int64(1)) // 2-3 bytes
+	two := s.Types[1]
+	ensure(t, two.Min, uint64(200))
+	ensure(t, len(two.Histogram), 9) // 128-255 bytes
+}
+
+func TestCollectorIntervals(t *testing.T) {
+	c, err := NewCollector(Interval(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	c.AddAt(start.Add(90*time.Second), 1, []byte("a"))
+	c.AddAt(start.Add(10*time.Second), 1, []byte("bc"))
+	c.AddAt(start.Add(70*time.Second), 1, nil)
+
+	s := c.Summary()
+	ensure(t, len(s.Intervals), 2)
+	ensure(t, s.Intervals[0].Start.Equal(start), true)
+	ensure(t, s.Intervals[0].Frames, int64(1))
+	ensure(t, s.Intervals[0].Bytes, int64(4))
+	ensure(t, s.Intervals[1].Start.Equal(start.Add(time.Minute)), true)
+	ensure(t, s.Intervals[1].Frames, int64(2))
+	ensure(t, s.Intervals[1].Bytes, int64(5))
+
+	_, err = NewCollector(Interval(0))
+	ensure(t, err, ErrInvalidInterval(0))
+}
+
+func TestCollectorFields(t *testing.T) {
+	s, err := schema.Load(strings.NewReader(`{"messages": {"1": {"name": "Reading", "fields": [
+		{"name": "sensor", "type": "Uint32"},
+		{"name": "delta", "type": "VWI"},
+		{"name": "label", "type": "String"}
+	]}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewCollector(Schema(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []struct {
+		sensor gobsp.Uint32
+		delta  gobsp.VWI
+	}{{7, -1}, {300, 100}} {
+		bb := new(bytes.Buffer)
+		label := gobsp.String("x")
+		for _, b := range []gobsp.Binary{&v.sensor, &v.delta, &label} {
+			if err = b.MarshalBinaryTo(bb); err != nil {
+				t.Fatal(err)
+			}
+		}
+		c.Add(1, bb.Bytes())
+	}
+	c.Add(1, []byte{0x01}) // does not match the schema
+
+	fields := c.Summary().Fields
+	ensure(t, len(fields), 2)
+	sensor := fields[0]
+	ensure(t, sensor.Message, "Reading")
+	ensure(t, sensor.Field, "sensor")
+	ensure(t, sensor.FieldType, "Uint32")
+	ensure(t, sensor.Count, int64(2))
+	ensure(t, sensor.Bytes, int64(8))
+	ensure(t, sensor.VariableBytes, int64(1+2))
+	ensure(t, sensor.FixedWidth, 2)
+	ensure(t, sensor.FixedBytes(), int64(4))
+
+	delta := fields[1]
+	ensure(t, delta.Field, "delta")
+	ensure(t, delta.Bytes, int64(1+2)) // 100 zig-zags to 200
+	ensure(t, delta.VariableBytes, int64(3))
+	ensure(t, delta.FixedWidth, 1)
+}
+
+func TestCollectorReadFrom(t *testing.T) {
+	bb := new(bytes.Buffer)
+	w, err := gobsp.NewComposerWithOptions(bb, gobsp.WritePreamble("test", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Compose(1, []byte("abc")); err != nil {
+		t.Fatal(err)
+	}
+	b := w.Batch()
+	if err = b.Add(2, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err = b.Send(); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	size := int64(bb.Len())
+
+	c, err := NewCollector()
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := c.ReadFrom(bb)
+	ensure(t, err, nil)
+	ensure(t, n, size)
+	s := c.Summary()
+	ensure(t, s.Frames, int64(2))
+	ensure(t, s.Types[0].Type, gobsp.MessageType(1))
+	ensure(t, s.Types[1].Type, gobsp.MTBatch)
+
+	_, err = c.ReadFrom(bytes.NewReader([]byte{0x01, 0x05, 'a'}))
+	ensure(t, err, io.ErrUnexpectedEOF)
+}